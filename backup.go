@@ -0,0 +1,289 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// GenesisHashFile records, within the run directory, the hash of the
+	// genesis the node's state was initialized from.
+	GenesisHashFile = "genesis.hash"
+
+	// BackupManifestFile is the last entry of a backup archive, listing the
+	// files before it with their checksums.
+	BackupManifestFile = "manifest.json"
+
+	backupVersion = 1
+)
+
+// BackupManifest describes the contents of a backup archive, and the node and
+// genesis its state belongs to.
+type BackupManifest struct {
+	Version     int          `json:"version"`
+	NodeID      uint64       `json:"node_id"`
+	GenesisHash string       `json:"genesis_hash"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Files       []BackupFile `json:"files"`
+}
+
+// BackupFile is a file of a backup archive, by its slash separated path
+// relative to the run directory.
+type BackupFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// skipBackup reports whether the file at the relative path is excluded from
+// backups, being the request store's lock or a snapshot yet to be written in
+// its entirety.
+func skipBackup(rel string) bool {
+	base := filepath.Base(rel)
+	return (filepath.Dir(rel) == RequestStoreDir && base == "LOCK") ||
+		strings.HasPrefix(base, ".snapshot-")
+}
+
+// WriteBackup writes a gzipped tar archive of the regular files within runDir
+// to w, followed by the manifest of their checksums.  The files must not
+// change while they are archived, so the node must be stopped or its
+// processing quiesced, see Server.Quiesce.
+func WriteBackup(w io.Writer, runDir string, nodeID uint64, genesisHash string) (*BackupManifest, error) {
+	var paths []string
+	err := filepath.Walk(runDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(runDir, path)
+		if err != nil {
+			return err
+		}
+		if !skipBackup(rel) {
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not list run directory")
+	}
+	sort.Strings(paths)
+
+	// Speed is favoured over size, as a running node is paused while its
+	// run directory is archived.
+	gw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(gw)
+
+	manifest := &BackupManifest{
+		Version:     backupVersion,
+		NodeID:      nodeID,
+		GenesisHash: genesisHash,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	for _, rel := range paths {
+		file, err := archiveFile(tw, filepath.Join(runDir, rel), filepath.ToSlash(rel))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "could not archive '%s'", rel)
+		}
+		manifest.Files = append(manifest.Files, *file)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     BackupManifestFile,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  manifest.CreatedAt,
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not archive manifest")
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, errors.WithMessage(err, "could not archive manifest")
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// archiveFile writes the file at path to the archive under name, returning
+// its size and checksum.
+func archiveFile(tw *tar.Writer, path, name string) (*BackupFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(info.Mode().Perm()),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tw, hash), f)
+	if err != nil {
+		return nil, err
+	}
+	if size != info.Size() {
+		return nil, errors.Errorf("file changed size while it was archived")
+	}
+
+	return &BackupFile{
+		Path:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// ReadBackup reads the backup archive from r, verifying each file against the
+// manifest, and returns the manifest.  If dir is not empty the files are
+// extracted into it, and should be discarded should an error be returned.
+func ReadBackup(r io.Reader, dir string) (*BackupManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not read backup")
+	}
+	tr := tar.NewReader(gr)
+
+	read := map[string]*BackupFile{}
+	var manifest *BackupManifest
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "could not read backup")
+		}
+		if manifest != nil {
+			return nil, errors.Errorf("backup has entry '%s' after its manifest", header.Name)
+		}
+
+		if header.Name == BackupManifestFile {
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, errors.WithMessage(err, "could not parse backup manifest")
+			}
+			continue
+		}
+
+		name := path.Clean(header.Name)
+		if header.Typeflag != tar.TypeReg || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, errors.Errorf("backup has unexpected entry '%s'", header.Name)
+		}
+		if _, ok := read[name]; ok {
+			return nil, errors.Errorf("backup has entry '%s' more than once", name)
+		}
+
+		file, err := extractFile(tr, dir, name, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return nil, errors.WithMessagef(err, "could not read '%s' from backup", name)
+		}
+		read[name] = file
+	}
+
+	if manifest == nil {
+		return nil, errors.Errorf("backup has no manifest, it may be truncated")
+	}
+	if manifest.Version != backupVersion {
+		return nil, errors.Errorf("backup is of version %d, but only version %d is supported", manifest.Version, backupVersion)
+	}
+
+	for _, expected := range manifest.Files {
+		file, ok := read[expected.Path]
+		if !ok {
+			return nil, errors.Errorf("backup is missing '%s'", expected.Path)
+		}
+		if *file != expected {
+			return nil, errors.Errorf("backup of '%s' has size %d and checksum %s, but the manifest expects size %d and checksum %s", expected.Path, file.Size, file.SHA256, expected.Size, expected.SHA256)
+		}
+		delete(read, expected.Path)
+	}
+	for name := range read {
+		return nil, errors.Errorf("backup has '%s', which is not in its manifest", name)
+	}
+
+	return manifest, nil
+}
+
+// extractFile reads the current entry of the archive, writing it to name
+// within dir unless dir is empty, and returns its size and checksum.
+func extractFile(r io.Reader, dir, name string, perm os.FileMode) (*BackupFile, error) {
+	w := io.Discard
+	if dir != "" {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), r)
+	if err != nil {
+		return nil, err
+	}
+
+	if f, ok := w.(*os.File); ok {
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &BackupFile{
+		Path:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	runDir := t.TempDir()
+	files := map[string]string{
+		GenesisHashFile: "abcd\n",
+		filepath.Join(WALDir, "00000000000000000001"):                    "wal",
+		filepath.Join(RequestStoreDir, "000001.vlog"):                    "requests",
+		filepath.Join(SnapshotDir, "snapshot-00000000000000000020.json"): "{}",
+	}
+	for path, data := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(runDir, path)), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(runDir, path), []byte(data), 0600))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(runDir, RequestStoreDir, "LOCK"), []byte("1\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(runDir, SnapshotDir, ".snapshot-1"), []byte("{"), 0600))
+
+	archive := &bytes.Buffer{}
+	written, err := WriteBackup(archive, runDir, 3, "abcd")
+	require.NoError(t, err)
+	assert.Len(t, written.Files, len(files), "the lock and incomplete snapshot are excluded")
+
+	dir := t.TempDir()
+	read, err := ReadBackup(bytes.NewReader(archive.Bytes()), dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), read.NodeID)
+	assert.Equal(t, "abcd", read.GenesisHash)
+	assert.Equal(t, written.Files, read.Files)
+
+	for path, data := range files {
+		restored, err := ioutil.ReadFile(filepath.Join(dir, path))
+		require.NoError(t, err)
+		assert.Equal(t, data, string(restored))
+	}
+}
+
+func TestReadBackupErrors(t *testing.T) {
+	type entry struct {
+		name string
+		data string
+	}
+
+	manifest := func(files ...BackupFile) entry {
+		data, err := json.Marshal(&BackupManifest{Version: backupVersion, Files: files})
+		require.NoError(t, err)
+		return entry{name: BackupManifestFile, data: string(data)}
+	}
+
+	data := entry{name: "data", data: "data"}
+	dataFile := BackupFile{
+		Path:   "data",
+		Size:   4,
+		SHA256: "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+	}
+
+	tests := []struct {
+		name    string
+		entries []entry
+		err     string
+	}{
+		{
+			name:    "valid",
+			entries: []entry{data, manifest(dataFile)},
+		},
+		{
+			name:    "no manifest",
+			entries: []entry{data},
+			err:     "backup has no manifest, it may be truncated",
+		},
+		{
+			name:    "checksum mismatch",
+			entries: []entry{{name: "data", data: "date"}, manifest(dataFile)},
+			err:     "backup of 'data' has size 4 and checksum 0e87632cd46bd4907c516317eb6d81fe0f921a23c7643018f21292894b470681, but the manifest expects size 4 and checksum " + dataFile.SHA256,
+		},
+		{
+			name:    "missing file",
+			entries: []entry{manifest(dataFile)},
+			err:     "backup is missing 'data'",
+		},
+		{
+			name:    "unlisted file",
+			entries: []entry{data, manifest()},
+			err:     "backup has 'data', which is not in its manifest",
+		},
+		{
+			name:    "escaping path",
+			entries: []entry{{name: "../data", data: "data"}, manifest(dataFile)},
+			err:     "backup has unexpected entry '../data'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := &bytes.Buffer{}
+			gw := gzip.NewWriter(archive)
+			tw := tar.NewWriter(gw)
+			for _, e := range tt.entries {
+				require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0600, Size: int64(len(e.data))}))
+				_, err := tw.Write([]byte(e.data))
+				require.NoError(t, err)
+			}
+			require.NoError(t, tw.Close())
+			require.NoError(t, gw.Close())
+
+			_, err := ReadBackup(archive, t.TempDir())
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -7,26 +7,59 @@ SPDX-License-Identifier: Apache-2.0
 package sample
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
 	"math"
+	"strings"
+	"sync"
 	"time"
 
-	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
 	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/logging"
 	"github.com/jyellick/mirbft-sample/network"
+	"github.com/jyellick/mirbft-sample/tracing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// sendConcurrency is the number of requests the client will have in the
+// process of being sent to any one node at a time.
+const sendConcurrency = 32
+
 type Client struct {
-	Logger       *zap.SugaredLogger
+	Loggers      *logging.Loggers
 	ClientConfig *config.ClientConfig
+
+	// RequestTimeout is how long the client waits for a node to acknowledge
+	// a request before resending it to that node.
+	RequestTimeout time.Duration
+
+	// MaxAttempts is the number of times the client will send a request to a
+	// node before giving up on delivering it to that node.
+	MaxAttempts int
+
+	// Tracer records a span for each request, and for each attempt to send
+	// it, if nil none are recorded.
+	Tracer trace.Tracer
+
+	logger *zap.SugaredLogger
 }
 
 func (c *Client) Run(requestCount uint64, requestSize uint16) error {
+	if requestCount == 0 {
+		return errors.Errorf("requestCount must be at least 1")
+	}
+
+	c.logger = c.Loggers.Named(logging.App)
+
+	tracer := c.Tracer
+	if tracer == nil {
+		tracer = nopTracer
+	}
+
 	// Create transport
-	t, err := network.NewClientTransport(c.Logger, c.ClientConfig)
+	t, err := network.NewClientTransport(c.Loggers, c.ClientConfig)
 	if err != nil {
 		return errors.WithMessage(err, "could not create networking")
 	}
@@ -40,19 +73,27 @@ func (c *Client) Run(requestCount uint64, requestSize uint16) error {
 	start := time.Now()
 
 	nextReqNos := make([]uint64, len(c.ClientConfig.Nodes))
+	nodeIDs := make([]uint64, len(c.ClientConfig.Nodes))
 
 	lowestReqNo := uint64(math.MaxUint64)
 	highestReqNo := uint64(0)
 
-	// Get the nextReqNo according to everyone
+	quorum := (len(c.ClientConfig.Nodes)-1)/3 + 1
+	windows := newWindowTracker(quorum)
+
+	// Get the nextReqNo according to everyone.  Nodes which cannot be reached
+	// are sent every request, and are retried like any other.
+	var lastErr error
 	for i, node := range c.ClientConfig.Nodes {
-		res, err := t.Request(node.ID, []byte{})
+		nodeIDs[i] = node.ID
+
+		reqNo, err := c.fetchNextReqNo(t, node.ID, windows)
 		if err != nil {
-			fmt.Printf("Error fetching next request number: %s", err)
-			return errors.WithMessage(err, "failed to submit client req")
+			c.logger.Warnw("Could not fetch next request number", "node_id", node.ID, "error", err)
+			lastErr = err
+			continue
 		}
 
-		reqNo := binary.BigEndian.Uint64(res)
 		nextReqNos[i] = reqNo
 		if reqNo < lowestReqNo {
 			lowestReqNo = reqNo
@@ -63,40 +104,274 @@ func (c *Client) Run(requestCount uint64, requestSize uint16) error {
 
 	}
 
+	if lowestReqNo == math.MaxUint64 {
+		return errors.WithMessage(lastErr, "could not fetch next request number from any node")
+	}
+
 	targetReqNo := highestReqNo + requestCount - 1
-	fmt.Printf("\nFound lowestReqNo=%d and highestReqNo=%d, will submit requests through targetReqNo=%d\n", lowestReqNo, highestReqNo, targetReqNo)
+	c.logger.Infow("Found next request numbers", "lowest_req_no", lowestReqNo, "highest_req_no", highestReqNo, "target_req_no", targetReqNo)
+
+	tracker := newInflightTracker(c.RequestTimeout, c.MaxAttempts)
+	spans := newRequestSpans(tracer)
+	defer spans.endAll()
+
+	// release begins tracking the requests which fall within the window
+	// accepted by the network, so that at most a window's worth of
+	// requests is ever outstanding.
+	nextReqNo := lowestReqNo
+	release := func() {
+		highWatermark, ok := windows.highWatermark()
+		for ; ok && nextReqNo <= targetReqNo && nextReqNo <= highWatermark; nextReqNo++ {
+			i := nextReqNo
+
+			data := make([]byte, requestSize)
+			for i, c := range fmt.Sprintf("data-%010d.%010d", c.ClientConfig.ID, i) {
+				data[i] = byte(c)
+			}
 
-	for i := lowestReqNo; i <= targetReqNo; i++ {
+			request := &network.Propose{
+				ClientID: c.ClientConfig.ID,
+				ReqNo:    i,
+				Data:     data,
+			}
+			tracker.add(request, nodeIDs)
+			spans.start(request)
+
+			for j, nodeID := range nodeIDs {
+				if nextReqNos[j] > i {
+					// The node already has this request
+					if tracker.ack(nodeID, i) {
+						spans.end(i)
+					}
+					continue
+				}
+				if nextReqNos[j] == i {
+					c.logger.Infow("Starting to send requests to node", "node_id", nodeID, "req_no", i)
+				}
+			}
+		}
+	}
+
+	// Each request is queued at most once per node at a time, and no more
+	// than a window's worth are outstanding, so a window bounds each queue.
+	queueSize := windows.width()
+	if queueSize == 0 || queueSize > requestCount {
+		queueSize = requestCount
+	}
+
+	queues := map[uint64]chan uint64{}
+	var wg sync.WaitGroup
+	for _, nodeID := range nodeIDs {
+		queue := make(chan uint64, queueSize)
+		queues[nodeID] = queue
+		for i := 0; i < sendConcurrency; i++ {
+			wg.Add(1)
+			go func(nodeID uint64) {
+				defer wg.Done()
+				for reqNo := range queue {
+					req := tracker.sent(nodeID, reqNo, time.Now())
+					ctx, span := tracer.Start(spans.context(reqNo), "client.send",
+						trace.WithSpanKind(trace.SpanKindClient),
+						tracing.Request(req.ClientID, req.ReqNo),
+						trace.WithAttributes(tracing.NodeID.Int64(int64(nodeID))),
+					)
+					ctx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+					reply, err := t.Request(ctx, nodeID, &network.ClientMsg{
+						Propose:     req,
+						TraceParent: tracing.Inject(ctx),
+					})
+					cancel()
+					tracing.End(span, err)
+					if reply != nil {
+						windows.update(nodeID, reply.Window)
+					}
+					if clientErr, ok := errors.Cause(err).(*network.ClientError); ok && clientErr.Code == network.ErrorOutsideWindow {
+						// The node is behind, and may accept the request
+						// once it has caught up, so this is not a failed attempt.
+						c.logger.Debugw("Request is outside the window of node, will retry", "req_no", reqNo, "node_id", nodeID)
+						tracker.requeue(nodeID, reqNo, err)
+						continue
+					}
+					if err != nil {
+						c.logger.Debugw("Could not deliver request", "req_no", reqNo, "node_id", nodeID, "error", err)
+						if tracker.fail(nodeID, reqNo, err) {
+							spans.end(reqNo)
+						}
+						continue
+					}
+					if tracker.ack(nodeID, reqNo) {
+						spans.end(reqNo)
+					}
+				}
+			}(nodeID)
+		}
+	}
 
-		data := make([]byte, requestSize)
-		for i, c := range fmt.Sprintf("data-%010d.%010d", c.ClientConfig.ID, i) {
-			data[i] = byte(c)
+	// pollC ensures only one poll of the nodes' windows is ongoing at a time.
+	pollC := make(chan struct{}, 1)
+	pollWindows := func() {
+		select {
+		case pollC <- struct{}{}:
+		default:
+			return
 		}
 
-		req := &pb.Request{
-			ClientId: c.ClientConfig.ID,
-			ReqNo:    i,
-			Data:     data,
+		go func() {
+			defer func() { <-pollC }()
+			var pollWG sync.WaitGroup
+			for _, nodeID := range nodeIDs {
+				pollWG.Add(1)
+				go func(nodeID uint64) {
+					defer pollWG.Done()
+					c.fetchNextReqNo(t, nodeID, windows)
+				}(nodeID)
+			}
+			pollWG.Wait()
+		}()
+	}
+
+	// Check for requests to release or retransmit several times per timeout period.
+	ticker := time.NewTicker(c.RequestTimeout / 4)
+	maxBlocked := c.RequestTimeout * time.Duration(c.MaxAttempts)
+	var blockedAt uint64
+	var blockedSince time.Time
+	var blockedErr error
+	for {
+		release()
+
+		for _, d := range tracker.due(time.Now()) {
+			select {
+			case queues[d.nodeID] <- d.reqNo:
+			default:
+				// The node has fallen a window behind, for instance
+				// as it is unavailable, so rather than block on it the
+				// delivery waits for the next check.
+				tracker.unqueue(d.nodeID, d.reqNo)
+			}
 		}
 
-		for j, node := range c.ClientConfig.Nodes {
-			if nextReqNos[j] > i {
-				continue
+		if nextReqNo > targetReqNo {
+			if tracker.settled() {
+				break
 			}
-			if nextReqNos[j] == i {
-				fmt.Printf("  starting to send reqs to node %d at reqNo=%d\n", j, i)
+		} else if tracker.quorumAcked(quorum) {
+			// Everything released has reached enough nodes to be ordered,
+			// so wait for the window to advance as those requests commit,
+			// rather than for any slow or unavailable nodes.
+			if blockedSince.IsZero() || blockedAt != nextReqNo {
+				c.logger.Infow("Waiting for the client window to advance", "req_no", nextReqNo)
+				blockedAt = nextReqNo
+				blockedSince = time.Now()
 			}
-			err := t.Send(node.ID, req)
-			if err != nil {
-				fmt.Printf("Error sending client request %d: %s", i, err)
-				return errors.WithMessage(err, "failed to submit client req")
+
+			if time.Since(blockedSince) > maxBlocked {
+				blockedErr = errors.Errorf("client window did not advance to reqNo=%d within %v", nextReqNo, maxBlocked)
+				break
 			}
+
+			pollWindows()
+		}
+
+		<-ticker.C
+	}
+	ticker.Stop()
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	c.logger.Infow("Completed sending requests", "duration", time.Since(start))
+
+	if err := c.report(tracker); err != nil {
+		return err
+	}
+
+	return blockedErr
+}
+
+// fetchNextReqNo asks the node for the next request number it expects from
+// this client, recording the client window the node reports.
+func (c *Client) fetchNextReqNo(t *network.ClientTransport, nodeID uint64, windows *windowTracker) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+	defer cancel()
+
+	reply, err := t.Request(ctx, nodeID, &network.ClientMsg{
+		NextReqNo: &network.NextReqNo{},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	windows.update(nodeID, reply.Window)
+
+	if reply.NextReqNo == nil {
+		return 0, errors.Errorf("node replied without a next request number")
+	}
+
+	return reply.NextReqNo.ReqNo, nil
+}
+
+// report summarizes the requests which were not acknowledged by every node
+// and returns an error if any request did not reach at least f+1 nodes, as
+// such a request may never be ordered.
+func (c *Client) report(tracker *inflightTracker) error {
+	undelivered := tracker.undelivered()
+	if len(undelivered) == 0 {
+		return nil
+	}
+
+	minAcks := (len(c.ClientConfig.Nodes)-1)/3 + 1
+
+	var failed []uint64
+	missing := map[uint64][]uint64{}
+	lastErrs := map[uint64]error{}
+	for _, u := range undelivered {
+		if u.acks < minAcks {
+			failed = append(failed, u.reqNo)
+		}
+
+		for nodeID, err := range u.errors {
+			missing[nodeID] = append(missing[nodeID], u.reqNo)
+			lastErrs[nodeID] = err
+		}
+	}
+
+	for _, node := range c.ClientConfig.Nodes {
+		reqNos, ok := missing[node.ID]
+		if !ok {
+			continue
 		}
+		c.logger.Warnw("Node did not acknowledge requests", "node_id", node.ID, "requests", len(reqNos), "req_nos", reqNoRanges(reqNos), "last_error", lastErrs[node.ID])
 	}
-	fmt.Printf("\n\nCompleted in %v\n\n", time.Since(start))
 
-	// XXX the close does not seem to wait until sends complete, investigate.
-	time.Sleep(2 * time.Second)
+	if len(failed) > 0 {
+		c.logger.Errorw("Failed to deliver requests to enough nodes", "requests", len(failed), "min_nodes", minAcks, "req_nos", reqNoRanges(failed))
+		return errors.Errorf("%d requests were not delivered to enough nodes after %d attempts each", len(failed), c.MaxAttempts)
+	}
 
 	return nil
 }
+
+// reqNoRanges formats a sorted list of request numbers compactly, for
+// instance [1 2 3 5] becomes "1-3,5".
+func reqNoRanges(reqNos []uint64) string {
+	var sb strings.Builder
+	for i := 0; i < len(reqNos); i++ {
+		start := reqNos[i]
+		for i+1 < len(reqNos) && reqNos[i+1] == reqNos[i]+1 {
+			i++
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteString(",")
+		}
+
+		if start == reqNos[i] {
+			fmt.Fprintf(&sb, "%d", start)
+		} else {
+			fmt.Fprintf(&sb, "%d-%d", start, reqNos[i])
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,33 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReqNoRanges(t *testing.T) {
+	tests := []struct {
+		reqNos []uint64
+		ranges string
+	}{
+		{reqNos: nil, ranges: ""},
+		{reqNos: []uint64{7}, ranges: "7"},
+		{reqNos: []uint64{1, 2, 3}, ranges: "1-3"},
+		{reqNos: []uint64{1, 2, 3, 5}, ranges: "1-3,5"},
+		{reqNos: []uint64{1, 3, 5}, ranges: "1,3,5"},
+		{reqNos: []uint64{0, 1, 4, 5, 6, 9}, ranges: "0-1,4-6,9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ranges, func(t *testing.T) {
+			assert.Equal(t, tt.ranges, reqNoRanges(tt.reqNos))
+		})
+	}
+}
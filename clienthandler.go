@@ -0,0 +1,292 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/jyellick/mirbft-sample/tracing"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// clientHandler implements the node side of the client protocol.
+type clientHandler struct {
+	logger    *zap.SugaredLogger
+	node      *nodeHolder
+	app       *application
+	reqStore  RequestStore
+	commitLog *commitLog
+	metrics   *metrics
+	tracer    trace.Tracer
+	traces    *requestTraces
+	doneC     <-chan struct{}
+
+	mutex         sync.Mutex
+	subscriptions map[uint64]*subscription
+}
+
+// maxSubscriptionTimeout bounds how long a subscription outlives the last
+// message from its client, as the node cannot otherwise tell that a client
+// has gone away.
+const maxSubscriptionTimeout = 5 * time.Minute
+
+// subscription tracks when its client last renewed it.
+type subscription struct {
+	cancelC chan struct{}
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	renewed time.Time
+}
+
+func (s *subscription) renew() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.renewed = time.Now()
+}
+
+// expiresIn returns how long until the subscription expires, if it is not
+// renewed in the meantime.
+func (s *subscription) expiresIn() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.timeout - time.Since(s.renewed)
+}
+
+func (ch *clientHandler) handle(clientID uint64, data []byte, stream network.Stream) ([]byte, error) {
+	var reply *network.ClientReply
+	msg, err := network.UnmarshalClientMsg(data)
+	if err == nil {
+		switch {
+		case msg.NextReqNo != nil:
+			reply, err = ch.nextReqNo(clientID)
+		case msg.Propose != nil:
+			ctx := tracing.Extract(context.Background(), msg.TraceParent)
+			reply, err = ch.propose(ctx, clientID, msg.Propose)
+			ch.metrics.proposed(err)
+		case msg.Subscribe != nil:
+			ch.subscribe(clientID, msg.Subscribe, stream)
+			return nil, nil
+		case msg.KeepAlive != nil:
+			ch.keepAlive(clientID)
+			return nil, nil
+		default:
+			err = errors.Errorf("unexpected client message")
+		}
+	}
+
+	if reply == nil {
+		reply = &network.ClientReply{}
+	}
+
+	if reply.Window == nil {
+		reply.Window = ch.app.clientWindow(clientID)
+	}
+
+	if err != nil {
+		clientErr, ok := errors.Cause(err).(*network.ClientError)
+		if !ok {
+			clientErr = &network.ClientError{
+				Code:    network.ErrorInternal,
+				Message: err.Error(),
+			}
+		}
+		ch.logger.Debugw("Replying to client with error", "client_id", clientID, "error", clientErr)
+		reply.Error = clientErr
+	}
+
+	return network.MarshalClientReply(reply)
+}
+
+func (ch *clientHandler) nextReqNo(clientID uint64) (*network.ClientReply, error) {
+	proposer := ch.node.get().Client(clientID)
+	nextReqNo, err := proposer.NextReqNo()
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not get next request number")
+	}
+
+	return &network.ClientReply{
+		NextReqNo: &network.NextReqNoReply{
+			ReqNo: nextReqNo,
+		},
+	}, nil
+}
+
+// propose proposes the request, recording its receipt within the trace
+// context of the client, if any.
+func (ch *clientHandler) propose(ctx context.Context, clientID uint64, msg *network.Propose) (reply *network.ClientReply, err error) {
+	ctx, span := ch.tracer.Start(ctx, "node.ingress", trace.WithSpanKind(trace.SpanKindServer), tracing.Request(msg.ClientID, msg.ReqNo))
+	defer func() { tracing.End(span, err) }()
+
+	if msg.ClientID != clientID {
+		return nil, &network.ClientError{
+			Code:    network.ErrorBadRequest,
+			Message: fmt.Sprintf("client ID mismatch, claims to be %d but is %d", msg.ClientID, clientID),
+		}
+	}
+
+	window := ch.app.clientWindow(clientID)
+	if window == nil {
+		return nil, &network.ClientError{
+			Code:    network.ErrorBadRequest,
+			Message: fmt.Sprintf("client %d is not part of the network state", clientID),
+		}
+	}
+
+	if msg.ReqNo > window.HighWatermark {
+		return &network.ClientReply{Window: window}, &network.ClientError{
+			Code:    network.ErrorOutsideWindow,
+			Message: fmt.Sprintf("req_no=%d is beyond the high watermark %d", msg.ReqNo, window.HighWatermark),
+		}
+	}
+
+	// Requests below the low watermark have already committed, so
+	// proposing them again is harmless and they are simply acknowledged.
+
+	_, proposeSpan := ch.tracer.Start(ctx, "node.propose", tracing.Request(msg.ClientID, msg.ReqNo))
+	proposer := ch.node.get().Client(clientID)
+	err = proposer.Propose(context.Background(), msg.ReqNo, msg.Data)
+	tracing.End(proposeSpan, err)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to propose message to client %d", clientID)
+	}
+
+	if msg.ReqNo >= window.LowWatermark {
+		ch.traces.propose(ctx, msg.ClientID, msg.ReqNo)
+	}
+
+	return &network.ClientReply{Window: window}, nil
+}
+
+// subscribe begins streaming committed entries to the client, replacing any
+// existing subscription the client holds with this node.
+func (ch *clientHandler) subscribe(clientID uint64, msg *network.Subscribe, stream network.Stream) {
+	timeout := time.Duration(msg.TimeoutMillis) * time.Millisecond
+	if timeout == 0 || timeout > maxSubscriptionTimeout {
+		timeout = maxSubscriptionTimeout
+	}
+
+	sub := &subscription{
+		cancelC: make(chan struct{}),
+		timeout: timeout,
+		renewed: time.Now(),
+	}
+
+	ch.mutex.Lock()
+	if prev, ok := ch.subscriptions[clientID]; ok {
+		close(prev.cancelC)
+	}
+	ch.subscriptions[clientID] = sub
+	ch.mutex.Unlock()
+
+	ch.logger.Infow("Client subscribed to committed entries", "client_id", clientID, "seq_no", msg.FromSeqNo)
+
+	go func() {
+		err := ch.serveSubscription(msg, stream, sub)
+		ch.logger.Infow("Subscription ended", "client_id", clientID, "reason", err)
+
+		ch.mutex.Lock()
+		if ch.subscriptions[clientID] == sub {
+			delete(ch.subscriptions, clientID)
+		}
+		ch.mutex.Unlock()
+	}()
+}
+
+// keepAlive renews the client's subscription, if it holds one.
+func (ch *clientHandler) keepAlive(clientID uint64) {
+	ch.mutex.Lock()
+	sub, ok := ch.subscriptions[clientID]
+	ch.mutex.Unlock()
+
+	if !ok {
+		ch.logger.Debugw("Ignoring keepalive from client, which holds no subscription", "client_id", clientID)
+		return
+	}
+
+	sub.renew()
+}
+
+// serveSubscription streams entries until the subscription is replaced or
+// expires.  Sending to a client which has gone away does not fail, so the
+// subscription relies on the client's keepalives to know it is still wanted.
+func (ch *clientHandler) serveSubscription(msg *network.Subscribe, stream network.Stream, sub *subscription) error {
+	send := func(entry *network.CommittedEntry) error {
+		data, err := network.MarshalClientMsg(&network.ClientMsg{
+			CommittedEntry: entry,
+		})
+		if err != nil {
+			return err
+		}
+		return stream(data)
+	}
+
+	for seqNo := msg.FromSeqNo; ; {
+		expiresIn := sub.expiresIn()
+		if expiresIn <= 0 {
+			return errors.Errorf("expired, no keepalive from client within %s", sub.timeout)
+		}
+
+		qEntry, updateC, err := ch.commitLog.get(seqNo)
+		if err != nil {
+			send(&network.CommittedEntry{
+				SeqNo: seqNo,
+				Error: err.Error(),
+			})
+			return err
+		}
+
+		if qEntry == nil {
+			expiryTimer := time.NewTimer(expiresIn)
+			select {
+			case <-updateC:
+				expiryTimer.Stop()
+				continue
+			case <-expiryTimer.C:
+				continue
+			case <-sub.cancelC:
+				expiryTimer.Stop()
+				return errors.Errorf("replaced by a new subscription")
+			case <-ch.doneC:
+				expiryTimer.Stop()
+				return errors.Errorf("node stopping")
+			}
+		}
+
+		entry := &network.CommittedEntry{
+			SeqNo: seqNo,
+		}
+		for _, ack := range qEntry.Requests {
+			request := &network.CommittedRequest{
+				ClientID: ack.ClientId,
+				ReqNo:    ack.ReqNo,
+				Digest:   ack.Digest,
+			}
+
+			if msg.IncludeData {
+				request.Data, err = ch.reqStore.GetRequest(ack)
+				if err != nil {
+					return errors.WithMessagef(err, "could not get request data for client_id=%d req_no=%d", ack.ClientId, ack.ReqNo)
+				}
+			}
+
+			entry.Requests = append(entry.Requests, request)
+		}
+
+		if err := send(entry); err != nil {
+			return errors.WithMessage(err, "could not send to client")
+		}
+
+		seqNo++
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/perlin-network/noise"
+	"github.com/pkg/errors"
+)
+
+type addClientArgs struct {
+	count uint16
+}
+
+type addNodeArgs struct {
+	node            topologyNode
+	adminPortOffset uint16
+	deploy          *deployArgs
+}
+
+// readPassphraseFor reads the passphrase to encrypt new keys with, if the
+// network's existing keys are encrypted and none was supplied.
+func (a *args) readPassphraseFor(n *network) error {
+	if len(a.passphrase) > 0 || !n.keysEncrypted() {
+		return nil
+	}
+
+	var err error
+	a.passphrase, err = config.ReadPassphrase()
+	return err
+}
+
+// addClients generates the configs and keys for new clients, and adds them to
+// every node config.
+func (a *args) addClients(n *network) error {
+	if err := a.readPassphraseFor(n); err != nil {
+		return err
+	}
+
+	template := n.template()
+	for i := uint16(0); i < a.addClient.count; i++ {
+		id := uint64(len(template.Clients))
+		dir := filepath.Join(n.dir, fmt.Sprintf("client%d", id))
+		if _, err := os.Stat(dir); err == nil {
+			return errors.Errorf("cannot add client %d, '%s' already exists", id, dir)
+		}
+
+		pubkey, privkey, err := noise.GenerateKeys(nil)
+		if err != nil {
+			return errors.WithMessagef(err, "could not generate key for client %d", id)
+		}
+
+		template.Clients = append(template.Clients, config.Client{
+			ID:        id,
+			PublicKey: pubkey.String(),
+		})
+
+		cd := &clientDir{
+			path: dir,
+			config: &config.ClientConfig{
+				Version: config.CurrentVersion,
+				ID:      id,
+			},
+		}
+
+		err = os.MkdirAll(cd.configDir(), 0700)
+		if err != nil {
+			return errors.WithMessage(err, "could not create config dir")
+		}
+
+		cd.config.PrivateKeyFile, err = a.writeKey(cd.configDir(), privkey.String())
+		if err != nil {
+			return errors.WithMessagef(err, "could not write key for client %d", id)
+		}
+
+		n.clients = append(n.clients, cd)
+		fmt.Printf("Added client %d in %s\n", id, dir)
+	}
+
+	return n.save()
+}
+
+// appendNode generates the config and key for a new node, adds it to every
+// node and client config, writes its systemd unit, and regenerates the
+// docker-compose file.
+func (a *args) appendNode(n *network) error {
+	if err := a.readPassphraseFor(n); err != nil {
+		return err
+	}
+
+	t, err := n.topology()
+	if err != nil {
+		return err
+	}
+
+	tn := a.addNode.node
+	if tn.Port == 0 {
+		for _, other := range t.Nodes {
+			if other.Port >= tn.Port {
+				tn.Port = other.Port + 1
+			}
+		}
+	}
+
+	id := len(t.Nodes)
+	if err := tn.validate(id); err != nil {
+		return err
+	}
+	if err := tn.setAdminPort(id, a.addNode.adminPortOffset); err != nil {
+		return err
+	}
+	t.Nodes = append(t.Nodes, tn)
+	if err := t.checkDistinct(); err != nil {
+		return err
+	}
+
+	compose, err := a.addNode.deploy.composeFile(t)
+	if err != nil {
+		return err
+	}
+
+	nd := &nodeDir{
+		path: filepath.Join(n.dir, tn.dirName(id)),
+	}
+	if _, err := os.Stat(nd.path); err == nil {
+		return errors.Errorf("cannot add node %d, '%s' already exists", id, nd.path)
+	}
+
+	pubkey, privkey, err := noise.GenerateKeys(nil)
+	if err != nil {
+		return errors.WithMessagef(err, "could not generate key for node %d", id)
+	}
+
+	template := n.template()
+	template.Nodes = append(template.Nodes, config.Node{
+		ID:        uint64(id),
+		Name:      tn.Name,
+		Address:   tn.address(),
+		PublicKey: pubkey.String(),
+	})
+
+	nd.config = &config.NodeConfig{
+		Version:       config.CurrentVersion,
+		ID:            uint64(id),
+		ListenAddress: tn.address(),
+		BindAddress:   tn.bindAddress(),
+		AdminAddress:  tn.adminAddress(),
+		PrivateKey:    privkey.String(),
+		GenesisFile:   genesisFile,
+		MirRuntime:    template.MirRuntime,
+		MirBootstrap:  template.MirBootstrap,
+		Nodes:         template.Nodes,
+		Clients:       template.Clients,
+	}
+
+	err = nd.config.Validate()
+	if err != nil {
+		return errors.WithMessagef(err, "generated node config %d is not valid", id)
+	}
+
+	err = os.MkdirAll(nd.configDir(), 0700)
+	if err != nil {
+		return errors.WithMessage(err, "could not create config dir")
+	}
+
+	err = os.MkdirAll(filepath.Join(nd.path, "run"), 0700)
+	if err != nil {
+		return errors.WithMessage(err, "could not create run dir")
+	}
+
+	nd.config.PrivateKeyFile, err = a.writeKey(nd.configDir(), nd.config.PrivateKey)
+	if err != nil {
+		return errors.WithMessagef(err, "could not write key for node %d", id)
+	}
+	nd.config.PrivateKey = ""
+
+	n.nodes = append(n.nodes, nd)
+
+	err = n.save()
+	if err != nil {
+		return err
+	}
+
+	err = a.addNode.deploy.writeSystemdUnit(n.dir, id, &tn)
+	if err != nil {
+		return err
+	}
+
+	err = writeComposeFile(n.dir, compose)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added node %d at %s in %s\n", id, tn.address(), nd.path)
+	return nil
+}
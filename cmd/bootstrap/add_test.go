@@ -0,0 +1,194 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// initNetwork bootstraps a network of four nodes and one client, listening on
+// the loopback interface from port 7000, within a temporary directory.
+func initNetwork(t *testing.T) string {
+	dir := t.TempDir()
+	a, err := parseArgs([]string{"init", "--outputDir", dir, "--basePort", "7000"})
+	require.NoError(t, err)
+	require.NoError(t, a.bootstrap())
+	return dir
+}
+
+// runCommand runs a command which modifies the network within its outputDir.
+func runCommand(t *testing.T, argv ...string) error {
+	a, err := parseArgs(argv)
+	require.NoError(t, err)
+	n, err := loadNetwork(a.outputDir)
+	require.NoError(t, err)
+
+	switch {
+	case a.addClient != nil:
+		return a.addClients(n)
+	case a.addNode != nil:
+		return a.appendNode(n)
+	case a.rotate != nil:
+		return a.rotateKey(n)
+	}
+	return errors.Errorf("unexpected command %s", a.command)
+}
+
+func genesisHash(t *testing.T, dir string) []byte {
+	g, err := config.LoadGenesisFile(filepath.Join(dir, genesisFile))
+	require.NoError(t, err)
+	return g.Hash()
+}
+
+// loadConfigs loads the config of each node and client of the network as it
+// would itself, resolving its key file and checking its genesis file, and
+// checks that each agrees with the genesis in the output directory.
+func loadConfigs(t *testing.T, dir string) ([]*config.NodeConfig, []*config.ClientConfig) {
+	n, err := loadNetwork(dir)
+	require.NoError(t, err)
+	hash := genesisHash(t, dir)
+
+	var nodeConfigs []*config.NodeConfig
+	for _, nd := range n.nodes {
+		f, err := os.Open(filepath.Join(nd.configDir(), nodeConfigFile))
+		require.NoError(t, err)
+		nc, err := config.LoadNodeConfig(f, config.WithBaseDir(nd.configDir()))
+		f.Close()
+		require.NoError(t, err, "node %d", nd.config.ID)
+		assert.Equal(t, hash, nc.Genesis().Hash(), "node %d", nc.ID)
+
+		g, err := config.LoadGenesisFile(filepath.Join(nd.configDir(), nc.GenesisFile))
+		require.NoError(t, err)
+		assert.Equal(t, hash, g.Hash(), "genesis of node %d", nc.ID)
+
+		nodeConfigs = append(nodeConfigs, nc)
+	}
+
+	var clientConfigs []*config.ClientConfig
+	for _, cd := range n.clients {
+		f, err := os.Open(filepath.Join(cd.configDir(), clientConfigFile))
+		require.NoError(t, err)
+		cc, err := config.LoadClientConfig(f, config.WithBaseDir(cd.configDir()))
+		f.Close()
+		require.NoError(t, err, "client %d", cd.config.ID)
+		assert.Equal(t, nodeConfigs[0].Nodes, cc.Nodes, "client %d", cc.ID)
+		clientConfigs = append(clientConfigs, cc)
+	}
+
+	return nodeConfigs, clientConfigs
+}
+
+func TestAddNode(t *testing.T) {
+	dir := initNetwork(t)
+	before := genesisHash(t, dir)
+
+	require.NoError(t, runCommand(t, "add-node", "--outputDir", dir, "--name", "epsilon"))
+
+	nodeConfigs, clientConfigs := loadConfigs(t, dir)
+	require.Len(t, nodeConfigs, 5)
+	assert.Len(t, clientConfigs, 1)
+	assert.NotEqual(t, before, genesisHash(t, dir))
+
+	added := nodeConfigs[4]
+	assert.Equal(t, uint64(4), added.ID)
+	assert.Equal(t, "127.0.0.1:7004", added.ListenAddress)
+	assert.Equal(t, "127.0.0.1:8004", added.AdminAddress)
+	for _, nc := range nodeConfigs {
+		require.Len(t, nc.Nodes, 5)
+		assert.Equal(t, "epsilon", nc.Nodes[4].Name)
+	}
+	assert.DirExists(t, filepath.Join(dir, "epsilon", "run"))
+
+	// A second node follows on from the first.
+	require.NoError(t, runCommand(t, "add-node", "--outputDir", dir))
+	nodeConfigs, _ = loadConfigs(t, dir)
+	require.Len(t, nodeConfigs, 6)
+	assert.Equal(t, "127.0.0.1:7005", nodeConfigs[5].ListenAddress)
+	assert.DirExists(t, filepath.Join(dir, "node5"))
+}
+
+func TestAddClient(t *testing.T) {
+	dir := initNetwork(t)
+	before := genesisHash(t, dir)
+
+	require.NoError(t, runCommand(t, "add-client", "--outputDir", dir, "--count", "2"))
+
+	nodeConfigs, clientConfigs := loadConfigs(t, dir)
+	require.Len(t, clientConfigs, 3)
+	assert.NotEqual(t, before, genesisHash(t, dir))
+	for i, cc := range clientConfigs {
+		assert.Equal(t, uint64(i), cc.ID)
+		for _, nc := range nodeConfigs {
+			require.Len(t, nc.Clients, 3)
+			assert.Equal(t, publicKey(t, cc.PrivateKey), nc.Clients[i].PublicKey, "client %d in the config of node %d", i, nc.ID)
+		}
+	}
+}
+
+func TestAddExisting(t *testing.T) {
+	tests := []struct {
+		name   string
+		exists string
+		args   []string
+		err    string
+	}{
+		{
+			name:   "node directory",
+			exists: "node4",
+			args:   []string{"add-node"},
+			err:    "cannot add node 4, '%s' already exists",
+		},
+		{
+			name: "node name",
+			args: []string{"add-node", "--name", "node0"},
+			err:  "topology nodes 0 and 4 would both be bootstrapped into 'node0'",
+		},
+		{
+			name: "node address",
+			args: []string{"add-node", "--host", "127.0.0.1", "--port", "7002"},
+			err:  "topology nodes 2 and 4 have the same address '127.0.0.1:7002'",
+		},
+		{
+			name:   "client directory",
+			exists: "client1",
+			args:   []string{"add-client", "--count", "2"},
+			err:    "cannot add client 1, '%s' already exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := initNetwork(t)
+			before := genesisHash(t, dir)
+			existing := filepath.Join(dir, "node0")
+			if tt.exists != "" {
+				existing = filepath.Join(dir, tt.exists)
+				require.NoError(t, os.Mkdir(existing, 0700))
+			}
+
+			err := runCommand(t, append(tt.args, "--outputDir", dir)...)
+			if strings.Contains(tt.err, "%s") {
+				tt.err = fmt.Sprintf(tt.err, existing)
+			}
+			assert.EqualError(t, err, tt.err)
+
+			nodeConfigs, clientConfigs := loadConfigs(t, dir)
+			assert.Len(t, nodeConfigs, 4)
+			assert.Len(t, clientConfigs, 1)
+			assert.Equal(t, before, genesisHash(t, dir), "the network is unchanged")
+		})
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+var systemdTemplate = template.Must(template.New("systemd").Parse(`[Unit]
+Description=mirbft-sample node {{.ID}}{{if .Name}} ({{.Name}}){{end}}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User={{.User}}
+# Set MIRSAMPLE_KEY_PASSPHRASE here if the private key is encrypted.
+EnvironmentFile=-{{.Dir}}/node.env
+ExecStart={{.Binary}} --nodeConfig={{.Dir}}/config/node-config.yaml --runDir={{.Dir}}/run --eventLog
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var composeTemplate = template.Must(template.New("compose").Parse(`# Generated by bootstrap, build the image from the repository root with:
+#   docker build -t {{.Image}} .
+version: "3.7"
+services:
+{{- range .Nodes}}
+  {{.Service}}:
+    image: {{$.Image}}
+{{- if $.HostNetwork}}
+    network_mode: host
+{{- else}}
+    hostname: {{.Service}}
+    ports:
+      - "{{.Port}}:{{.ContainerPort}}"
+{{- if .AdminPort}}
+      - "{{.AdminPort}}:{{.AdminPort}}"
+{{- end}}
+{{- end}}
+    command:
+      - --nodeConfig=/mirbft/{{.Service}}/config/node-config.yaml
+      - --runDir=/mirbft/{{.Service}}/run
+      - --eventLog
+    environment:
+      - MIRSAMPLE_KEY_PASSPHRASE
+    volumes:
+      - ./{{.Service}}/config:/mirbft/{{.Service}}/config:ro
+      - ./{{.Service}}/keys:/mirbft/{{.Service}}/keys:ro
+      - ./{{.Service}}/run:/mirbft/{{.Service}}/run
+    restart: on-failure
+{{- end}}
+`))
+
+type deployArgs struct {
+	deployDir  string
+	nodeBinary string
+	user       string
+	image      string
+}
+
+type systemdUnit struct {
+	ID     int
+	Name   string
+	Dir    string
+	Binary string
+	User   string
+}
+
+type composeNode struct {
+	Service       string
+	Port          uint16
+	ContainerPort uint16
+	AdminPort     uint16
+}
+
+type composeFile struct {
+	Image       string
+	HostNetwork bool
+	Nodes       []composeNode
+}
+
+// composeFile describes the docker-compose file for the whole network, and
+// is computed before anything is written, so that a topology which cannot
+// be deployed via docker-compose is rejected up front.
+func (da *deployArgs) composeFile(t *topology) (*composeFile, error) {
+	compose := &composeFile{
+		Image: da.image,
+	}
+
+	for i, tn := range t.Nodes {
+		dirName := tn.dirName(i)
+
+		// Containers on a bridge network reach each other by service name,
+		// so a bridge network only works if every node's host is its name.
+		// Otherwise, as for the default loopback topology, the containers
+		// share the host's network.
+		if trimBrackets(tn.Host) != dirName {
+			compose.HostNetwork = true
+		}
+
+		containerPort := tn.Port
+		if tn.BindPort != 0 {
+			containerPort = tn.BindPort
+		}
+
+		compose.Nodes = append(compose.Nodes, composeNode{
+			Service:       dirName,
+			Port:          tn.Port,
+			ContainerPort: containerPort,
+			AdminPort:     tn.AdminPort,
+		})
+	}
+
+	if !compose.HostNetwork {
+		// On a bridge network each node's port is published on the host,
+		// so that clients outside the network may reach the nodes.
+		published := map[uint16]string{}
+		for _, node := range compose.Nodes {
+			for _, port := range []uint16{node.Port, node.AdminPort} {
+				if port == 0 {
+					continue
+				}
+				if other, ok := published[port]; ok {
+					return nil, errors.Errorf("nodes %s and %s both use port %d, which cannot be published twice on the docker host, give them distinct ports", other, node.Service, port)
+				}
+				published[port] = node.Service
+			}
+		}
+	}
+
+	return compose, nil
+}
+
+// writeSystemdUnit writes the systemd unit for node i into its directory.
+func (da *deployArgs) writeSystemdUnit(outputDir string, i int, tn *topologyNode) error {
+	dirName := tn.dirName(i)
+
+	err := writeTemplate(
+		filepath.Join(outputDir, dirName, "mirbft-"+dirName+".service"),
+		systemdTemplate,
+		&systemdUnit{
+			ID:     i,
+			Name:   tn.Name,
+			Dir:    filepath.Join(da.deployDir, dirName),
+			Binary: da.nodeBinary,
+			User:   da.user,
+		},
+	)
+	if err != nil {
+		return errors.WithMessagef(err, "could not write systemd unit for node %d", i)
+	}
+
+	return nil
+}
+
+// writeComposeFile writes the docker-compose file for the whole network into
+// the output directory.
+func writeComposeFile(outputDir string, compose *composeFile) error {
+	err := writeTemplate(filepath.Join(outputDir, "docker-compose.yaml"), composeTemplate, compose)
+	if err != nil {
+		return errors.WithMessage(err, "could not write docker-compose file")
+	}
+
+	return nil
+}
+
+func writeTemplate(path string, tmpl *template.Template, data interface{}) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := tmpl.Execute(f, data); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
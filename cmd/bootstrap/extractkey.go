@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultKeyFile is where a private key is stored, relative to the directory
+// of the config which references it.
+const defaultKeyFile = "../keys/private.key"
+
+type extractKeyArgs struct {
+	configPath string
+	keyFile    string
+}
+
+// extract migrates a config written before keys were stored separately.  The
+// private_key entry is replaced by a private_key_file entry referencing the
+// newly written key file, and the original config is kept as a backup.  The
+// config is edited without decoding it into a config struct, so that the
+// ordering and remaining contents of the file are preserved.
+func (ea *extractKeyArgs) extract(passphrase []byte) error {
+	data, err := ioutil.ReadFile(ea.configPath)
+	if err != nil {
+		return errors.WithMessage(err, "could not read config")
+	}
+
+	var doc yaml.MapSlice
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return errors.WithMessage(err, "could not parse config")
+	}
+
+	index := -1
+	for i, item := range doc {
+		switch item.Key {
+		case "private_key_file":
+			return errors.Errorf("config already references a key file")
+		case "private_key":
+			index = i
+		}
+	}
+
+	if index == -1 {
+		return errors.Errorf("config does not contain a private_key")
+	}
+
+	key, ok := doc[index].Value.(string)
+	if !ok || key == "" {
+		return errors.Errorf("config's private_key is not a string")
+	}
+
+	keyPath := ea.keyFile
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(filepath.Dir(ea.configPath), keyPath)
+	}
+
+	if _, err := os.Stat(keyPath); err == nil {
+		return errors.Errorf("key file '%s' already exists", keyPath)
+	}
+
+	doc[index] = yaml.MapItem{Key: "private_key_file", Value: ea.keyFile}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return errors.WithMessage(err, "could not marshal config")
+	}
+
+	backupPath := ea.configPath + ".bak"
+	err = ioutil.WriteFile(backupPath, data, 0600)
+	if err != nil {
+		return errors.WithMessage(err, "could not back up config")
+	}
+
+	err = os.MkdirAll(filepath.Dir(keyPath), 0700)
+	if err != nil {
+		return errors.WithMessage(err, "could not create key dir")
+	}
+
+	err = config.WriteKeyFile(keyPath, key, passphrase)
+	if err != nil {
+		return errors.WithMessage(err, "could not write key file")
+	}
+
+	err = ioutil.WriteFile(ea.configPath, out, 0600)
+	if err != nil {
+		return errors.WithMessage(err, "could not write config")
+	}
+
+	fmt.Printf("Wrote key to %s and updated %s, the original config was saved to %s, delete it once the new config is verified\n", keyPath, ea.configPath, backupPath)
+
+	return nil
+}
@@ -35,49 +35,235 @@ func dirIsEmpty(name string) (bool, error) {
 	return false, err // Either not empty or error, suits both cases
 }
 
+// genesisFile is the name of the genesis written to the output directory, and
+// alongside each node config.
+const genesisFile = "genesis.yaml"
+
 type args struct {
+	command     string
 	outputDir   string
-	basePort    uint16
-	nodeCount   uint16
+	topology    *topology
 	clientCount uint16
+	passphrase  []byte
+	deploy      *deployArgs
+	extractKey  *extractKeyArgs
+	addClient   *addClientArgs
+	addNode     *addNodeArgs
+	rotate      *rotateKeyArgs
+	status      *statusArgs
+	migrate     bool
+}
+
+// deployFlags registers the flags describing how nodes are deployed, which
+// are resolved against the output directory once parsed.
+func deployFlags(cmd *kingpin.CmdClause) func(outputDir string) (*deployArgs, error) {
+	deployDir := cmd.Flag("deployDir", "The directory the output will be installed to on each host, referenced by the generated systemd units.  Defaults to the absolute path of outputDir.").String()
+	nodeBinary := cmd.Flag("nodeBinary", "The path to the node binary, referenced by the generated systemd units.").Default("/usr/local/bin/mirbft-node").String()
+	systemdUser := cmd.Flag("systemdUser", "The user the generated systemd units run the node as, which must own the node's run directory.").Default("mirbft").String()
+	image := cmd.Flag("image", "The docker image to run nodes from, referenced by the generated docker-compose file.").Default("mirbft-sample:latest").String()
+
+	return func(outputDir string) (*deployArgs, error) {
+		da := &deployArgs{
+			deployDir:  *deployDir,
+			nodeBinary: *nodeBinary,
+			user:       *systemdUser,
+			image:      *image,
+		}
+
+		if da.deployDir == "" {
+			var err error
+			da.deployDir, err = filepath.Abs(outputDir)
+			if err != nil {
+				return nil, errors.WithMessage(err, "could not determine absolute path of outputDir")
+			}
+		}
+
+		return da, nil
+	}
 }
 
 func parseArgs(argsString []string) (*args, error) {
 	app := kingpin.New("bootstrap", "A small bootstrapping tool to bootstrap a mir-sample network.")
-	outputDir := app.Flag("outputDir", "The directory in which to create the bootstrap config.").Default("bootstrap.d").ExistingDir()
-	basePort := app.Flag("basePort", "The initial port for the first node, incremented per node.").Default("5000").Uint16()
-	nodeCount := app.Flag("nodeCount", "The total number of nodes to create for this network.").Default("4").Uint16()
-	clientCount := app.Flag("clientCount", "The total number of clients to create for this network.").Default("1").Uint16()
-
-	_, err := app.Parse(argsString)
+	encryptKeys := app.Flag("encryptKeys", "Encrypt private keys with a passphrase, read from "+config.PassphraseEnv+" or prompted for.  New keys added to a network whose keys are encrypted are always encrypted.").Default("false").Bool()
+
+	initCmd := app.Command("init", "Generate the config and keys for a new network.").Default()
+	outputDir := initCmd.Flag("outputDir", "The directory in which to create the bootstrap config.").Default("bootstrap.d").ExistingDir()
+	basePort := initCmd.Flag("basePort", "The initial port for the first node, incremented per node.  Ignored if a topology is supplied.").Default("5000").Uint16()
+	nodeCount := initCmd.Flag("nodeCount", "The total number of nodes to create for this network.  Ignored if a topology is supplied.").Default("4").Uint16()
+	topologyFile := initCmd.Flag("topology", "A YAML file listing the host, port, and optional name of each node, for networks spanning several machines.").ExistingFile()
+	clientCount := initCmd.Flag("clientCount", "The total number of clients to create for this network.").Default("1").Uint16()
+	adminPortOffset := initCmd.Flag("adminPortOffset", "Each node serves its admin API on the port it listens on plus this offset, unless the topology gives it an admin_port.  Zero disables the admin API.").Default("1000").Uint16()
+	initDeploy := deployFlags(initCmd)
+
+	addClientCmd := app.Command("add-client", "Add clients to an existing network.")
+	addClientDir := addClientCmd.Flag("outputDir", "The bootstrap directory of the network.").Default("bootstrap.d").ExistingDir()
+	addClientCount := addClientCmd.Flag("count", "The number of clients to add.").Default("1").Uint16()
+
+	addNodeCmd := app.Command("add-node", "Add a node to an existing network.")
+	addNodeDir := addNodeCmd.Flag("outputDir", "The bootstrap directory of the network.").Default("bootstrap.d").ExistingDir()
+	addNodeName := addNodeCmd.Flag("name", "An optional name for the node, also used for its directory in place of node<id>.").String()
+	addNodeHost := addNodeCmd.Flag("host", "The host at which peers and clients reach the node.").Default("127.0.0.1").String()
+	addNodePort := addNodeCmd.Flag("port", "The port at which peers and clients reach the node.  Defaults to one more than the highest port of the existing nodes.").Uint16()
+	addNodeBindHost := addNodeCmd.Flag("bindHost", "The local IP the node listens on, if it differs from the host.").String()
+	addNodeBindPort := addNodeCmd.Flag("bindPort", "The local port the node listens on, if it differs from the port.").Uint16()
+	addNodeAdminPort := addNodeCmd.Flag("adminPort", "The port the node serves its admin API on.  Defaults to the port it listens on plus adminPortOffset.").Uint16()
+	addNodeAdminPortOffset := addNodeCmd.Flag("adminPortOffset", "The offset from the port the node listens on to its admin port, if adminPort is not given.  Zero disables the admin API.").Default("1000").Uint16()
+	addNodeDeploy := deployFlags(addNodeCmd)
+
+	rotateKeyCmd := app.Command("rotate-key", "Replace the key of a node or client of an existing network.")
+	rotateKeyDir := rotateKeyCmd.Flag("outputDir", "The bootstrap directory of the network.").Default("bootstrap.d").ExistingDir()
+	rotateKeyKind := rotateKeyCmd.Arg("kind", "Whether to rotate the key of a node or a client.").Required().Enum("node", "client")
+	rotateKeyID := rotateKeyCmd.Arg("id", "The ID of the node or client.").Required().Uint64()
+
+	showCmd := app.Command("show", "Summarize an existing network.")
+	showDir := showCmd.Flag("outputDir", "The bootstrap directory of the network.").Default("bootstrap.d").ExistingDir()
+
+	statusCmd := app.Command("status", "Query the admin API of each node of an existing network, and summarize their status.")
+	statusDir := statusCmd.Flag("outputDir", "The bootstrap directory of the network.").Default("bootstrap.d").ExistingDir()
+	statusNodes := statusCmd.Arg("node", "The IDs of the nodes to query, by default every node.").Uint64List()
+	statusTimeout := statusCmd.Flag("timeout", "How long to wait for each node to reply.").Default("5s").Duration()
+	statusJSON := statusCmd.Flag("json", "Print the status reported by each node as JSON.").Default("false").Bool()
+
+	migrateCmd := app.Command("migrate", "Upgrade the node and client configs of an existing network to the current config version, keeping each original as <config>.v<version>.bak.")
+	migrateDir := migrateCmd.Flag("outputDir", "The bootstrap directory of the network.").Default("bootstrap.d").ExistingDir()
+
+	extractKeyCmd := app.Command("extract-key", "Move the private key embedded in an existing node or client config into a key file.")
+	configPath := extractKeyCmd.Flag("config", "The node or client config containing a private_key.").Required().ExistingFile()
+	keyFile := extractKeyCmd.Flag("keyFile", "Where to write the key, relative paths are resolved against the config's directory.").Default(defaultKeyFile).String()
+
+	command, err := app.Parse(argsString)
 	if err != nil {
 		return nil, err
 	}
 
+	a := &args{
+		command: command,
+	}
+
+	if *encryptKeys {
+		a.passphrase, err = config.ReadNewPassphrase()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch command {
+	case extractKeyCmd.FullCommand():
+		a.extractKey = &extractKeyArgs{
+			configPath: *configPath,
+			keyFile:    *keyFile,
+		}
+		return a, nil
+	case addClientCmd.FullCommand():
+		a.outputDir = *addClientDir
+		a.addClient = &addClientArgs{
+			count: *addClientCount,
+		}
+		return a, nil
+	case addNodeCmd.FullCommand():
+		a.outputDir = *addNodeDir
+		a.addNode = &addNodeArgs{
+			node: topologyNode{
+				Name:      *addNodeName,
+				Host:      *addNodeHost,
+				Port:      *addNodePort,
+				BindHost:  *addNodeBindHost,
+				BindPort:  *addNodeBindPort,
+				AdminPort: *addNodeAdminPort,
+			},
+			adminPortOffset: *addNodeAdminPortOffset,
+		}
+		a.addNode.deploy, err = addNodeDeploy(a.outputDir)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	case rotateKeyCmd.FullCommand():
+		a.outputDir = *rotateKeyDir
+		a.rotate = &rotateKeyArgs{
+			kind: *rotateKeyKind,
+			id:   *rotateKeyID,
+		}
+		return a, nil
+	case showCmd.FullCommand():
+		a.outputDir = *showDir
+		return a, nil
+	case statusCmd.FullCommand():
+		a.outputDir = *statusDir
+		a.status = &statusArgs{
+			nodes:   *statusNodes,
+			timeout: *statusTimeout,
+			json:    *statusJSON,
+		}
+		return a, nil
+	case migrateCmd.FullCommand():
+		a.outputDir = *migrateDir
+		a.migrate = true
+		return a, nil
+	}
+
 	isEmpty, err := dirIsEmpty(*outputDir)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "could not read outputDir '%s'", *outputDir)
 	}
 
 	if !isEmpty {
-		return nil, errors.Errorf("outputDir '%s' is not empty", *outputDir)
+		return nil, errors.Errorf("outputDir '%s' is not empty, use add-client, add-node, rotate-key, or migrate to modify an existing network", *outputDir)
+	}
+
+	a.outputDir = *outputDir
+	a.deploy, err = initDeploy(a.outputDir)
+	if err != nil {
+		return nil, err
+	}
+	a.clientCount = *clientCount
+	a.topology = localTopology(*basePort, *nodeCount)
+	if *topologyFile != "" {
+		a.topology, err = loadTopology(*topologyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range a.topology.Nodes {
+		if err := a.topology.Nodes[i].setAdminPort(i, *adminPortOffset); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// writeKey writes the private key to the default key file for the config
+// directory, returning the path to reference from the config.
+func (a *args) writeKey(confDir, key string) (string, error) {
+	path := filepath.Join(confDir, defaultKeyFile)
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", errors.WithMessage(err, "could not create key dir")
+	}
+
+	err = config.WriteKeyFile(path, key, a.passphrase)
+	if err != nil {
+		return "", errors.WithMessage(err, "could not write key file")
 	}
 
-	return &args{
-		outputDir:   *outputDir,
-		basePort:    *basePort,
-		nodeCount:   *nodeCount,
-		clientCount: *clientCount,
-	}, nil
+	return defaultKeyFile, nil
 }
 
 func (a *args) bootstrap() error {
+	compose, err := a.deploy.composeFile(a.topology)
+	if err != nil {
+		return err
+	}
+
 	var nodes []config.Node
 	var nodePrivateKeys []string
 	var clients []config.Client
 	var clientPrivateKeys []string
 
-	for i := uint16(0); i < a.nodeCount; i++ {
+	for i, tn := range a.topology.Nodes {
 		pubkey, privkey, err := noise.GenerateKeys(nil)
 		if err != nil {
 			return errors.WithMessagef(err, "could not generate key for node %d", i)
@@ -87,7 +273,8 @@ func (a *args) bootstrap() error {
 
 		node := config.Node{
 			ID:        uint64(i),
-			Address:   fmt.Sprintf("127.0.0.1:%d", a.basePort+i),
+			Name:      tn.Name,
+			Address:   tn.address(),
 			PublicKey: pubkey.String(),
 		}
 		nodes = append(nodes, node)
@@ -108,11 +295,31 @@ func (a *args) bootstrap() error {
 		clients = append(clients, client)
 	}
 
-	for i := uint16(0); i < a.nodeCount; i++ {
+	mirBootstrap := config.MirBootstrap{
+		NumberOfBuckets:    1,
+		ClientWindowSize:   5000,
+		CheckpointInterval: 20,
+	}
+
+	genesis := config.NewGenesis(mirBootstrap, nodes, clients)
+	writeGenesis := func(dir string) error {
+		return config.WriteGenesisFile(filepath.Join(dir, genesisFile), genesis)
+	}
+
+	err = writeGenesis(a.outputDir)
+	if err != nil {
+		return errors.WithMessage(err, "could not write genesis")
+	}
+
+	for i, tn := range a.topology.Nodes {
 		config := config.NodeConfig{
+			Version:       config.CurrentVersion,
 			ID:            uint64(i),
 			ListenAddress: nodes[i].Address,
+			BindAddress:   tn.bindAddress(),
+			AdminAddress:  tn.adminAddress(),
 			PrivateKey:    nodePrivateKeys[i],
+			GenesisFile:   genesisFile,
 			MirRuntime: config.MirRuntime{
 				TickInterval:         time.Second,
 				HeartbeatTicks:       1,
@@ -121,33 +328,45 @@ func (a *args) bootstrap() error {
 				BatchSize:            20,
 				BufferSize:           5 * 1024 * 1024,
 			},
-			MirBootstrap: config.MirBootstrap{
-				NumberOfBuckets:    1,
-				ClientWindowSize:   5000,
-				CheckpointInterval: 20,
-			},
-			Nodes:   nodes,
-			Clients: clients,
+			MirBootstrap: mirBootstrap,
+			Nodes:        nodes,
+			Clients:      clients,
 		}
 
-		confDir := filepath.Join(a.outputDir, fmt.Sprintf("node%d", i), "config")
+		err := config.Validate()
+		if err != nil {
+			return errors.WithMessagef(err, "generated node config %d is not valid", i)
+		}
 
-		err := os.MkdirAll(confDir, 0700)
+		confDir := filepath.Join(a.outputDir, tn.dirName(i), "config")
+
+		err = os.MkdirAll(confDir, 0700)
 		if err != nil {
 			return errors.WithMessage(err, "could not create config dir")
 		}
 
+		config.PrivateKeyFile, err = a.writeKey(confDir, config.PrivateKey)
+		if err != nil {
+			return errors.WithMessagef(err, "could not write key for node %d", i)
+		}
+
+		err = writeGenesis(confDir)
+		if err != nil {
+			return errors.WithMessagef(err, "could not write genesis for node %d", i)
+		}
+		config.PrivateKey = ""
+
 		out, err := yaml.Marshal(config)
 		if err != nil {
 			return errors.WithMessagef(err, "could not marshal node config %d to yaml", i)
 		}
 
-		err = ioutil.WriteFile(filepath.Join(confDir, "node-config.yaml"), out, 0600)
+		err = ioutil.WriteFile(filepath.Join(confDir, nodeConfigFile), out, 0600)
 		if err != nil {
 			return errors.WithMessagef(err, "could not write node config %d", i)
 		}
 
-		runDir := filepath.Join(a.outputDir, fmt.Sprintf("node%d", i), "run")
+		runDir := filepath.Join(a.outputDir, tn.dirName(i), "run")
 
 		err = os.MkdirAll(runDir, 0700)
 		if err != nil {
@@ -157,6 +376,7 @@ func (a *args) bootstrap() error {
 
 	for i := uint16(0); i < a.clientCount; i++ {
 		config := config.ClientConfig{
+			Version:    config.CurrentVersion,
 			ID:         uint64(i),
 			PrivateKey: clientPrivateKeys[i],
 			Nodes:      nodes,
@@ -169,17 +389,31 @@ func (a *args) bootstrap() error {
 			return errors.WithMessage(err, "could not create config dir")
 		}
 
+		config.PrivateKeyFile, err = a.writeKey(confDir, config.PrivateKey)
+		if err != nil {
+			return errors.WithMessagef(err, "could not write key for client %d", i)
+		}
+		config.PrivateKey = ""
+
 		out, err := yaml.Marshal(config)
 		if err != nil {
 			return errors.WithMessagef(err, "could not marshal node config %d to yaml", i)
 		}
 
-		err = ioutil.WriteFile(filepath.Join(confDir, "client-config.yaml"), out, 0600)
+		err = ioutil.WriteFile(filepath.Join(confDir, clientConfigFile), out, 0600)
 		if err != nil {
 			return errors.WithMessagef(err, "could not write client config %d", i)
 		}
 	}
-	return nil
+
+	for i := range a.topology.Nodes {
+		err := a.deploy.writeSystemdUnit(a.outputDir, i, &a.topology.Nodes[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeComposeFile(a.outputDir, compose)
 }
 
 func main() {
@@ -189,9 +423,45 @@ func main() {
 		kingpin.Fatalf("Error parsing arguments, %s, try --help", err)
 	}
 
-	err = args.bootstrap()
+	switch {
+	case args.extractKey != nil:
+		err = args.extractKey.extract(args.passphrase)
+		if err != nil {
+			kingpin.Fatalf("Error extracting key, %s", err)
+		}
+		return
+	case args.topology != nil:
+		err = args.bootstrap()
+		if err != nil {
+			kingpin.Fatalf("Error bootstrapping, %s", err)
+		}
+		return
+	case args.migrate:
+		err = migrate(os.Stdout, args.outputDir)
+		if err != nil {
+			kingpin.Fatalf("Error migrating, %s", err)
+		}
+		return
+	}
+
+	n, err := loadNetwork(args.outputDir)
 	if err != nil {
-		kingpin.Fatalf("Error bootstrapping, %s", err)
+		kingpin.Fatalf("Error loading network, %s", err)
 	}
 
+	switch {
+	case args.addClient != nil:
+		err = args.addClients(n)
+	case args.addNode != nil:
+		err = args.appendNode(n)
+	case args.rotate != nil:
+		err = args.rotateKey(n)
+	case args.status != nil:
+		err = args.status.status(os.Stdout, n)
+	default:
+		err = show(os.Stdout, n)
+	}
+	if err != nil {
+		kingpin.Fatalf("Error running %s, %s", args.command, err)
+	}
 }
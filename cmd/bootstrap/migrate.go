@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+)
+
+// migrate upgrades every node and client config within dir to the current
+// version in place, keeping each original alongside it.
+func migrate(w io.Writer, dir string) error {
+	kinds := []struct {
+		file    string
+		migrate func([]byte) (*config.Migration, error)
+	}{
+		{file: nodeConfigFile, migrate: config.MigrateNodeConfig},
+		{file: clientConfigFile, migrate: config.MigrateClientConfig},
+	}
+
+	found := false
+	for _, kind := range kinds {
+		paths, err := filepath.Glob(filepath.Join(dir, "*", "config", kind.file))
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			found = true
+			if err := migrateConfig(w, path, kind.migrate); err != nil {
+				return errors.WithMessagef(err, "could not migrate '%s'", path)
+			}
+		}
+	}
+
+	if !found {
+		return errors.Errorf("'%s' contains no node or client configs, is it a bootstrap directory?", dir)
+	}
+
+	return nil
+}
+
+func migrateConfig(w io.Writer, path string, migrate func([]byte) (*config.Migration, error)) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	m, err := migrate(data)
+	if err != nil {
+		return err
+	}
+
+	if !m.Outdated() {
+		fmt.Fprintf(w, "%s is already version %d\n", path, config.CurrentVersion)
+		return nil
+	}
+
+	// The backup is named for its version, so that migrating again later
+	// never overwrites the backup of an earlier migration.
+	backupPath := fmt.Sprintf("%s.v%d.bak", path, m.FromVersion)
+	if _, err := os.Stat(backupPath); err == nil {
+		return errors.Errorf("backup '%s' already exists", backupPath)
+	}
+
+	err = ioutil.WriteFile(backupPath, data, 0600)
+	if err != nil {
+		return errors.WithMessage(err, "could not back up config")
+	}
+
+	err = ioutil.WriteFile(path, m.Data, 0600)
+	if err != nil {
+		return errors.WithMessage(err, "could not write config")
+	}
+
+	fmt.Fprintf(w, "Migrated %s from version %d to %d, the original was saved to %s\n", path, m.FromVersion, config.CurrentVersion, backupPath)
+	for _, change := range m.Changes {
+		fmt.Fprintf(w, "  %s\n", change)
+	}
+
+	return nil
+}
@@ -0,0 +1,282 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	nodeConfigFile   = "node-config.yaml"
+	clientConfigFile = "client-config.yaml"
+)
+
+// network is an existing bootstrap directory, holding the config of each
+// node and client of the network in a directory of its own.
+type network struct {
+	dir     string
+	nodes   []*nodeDir
+	clients []*clientDir
+}
+
+type nodeDir struct {
+	// path is the node's directory, containing its config, keys, and run
+	// directories.
+	path   string
+	config *config.NodeConfig
+}
+
+type clientDir struct {
+	path   string
+	config *config.ClientConfig
+}
+
+func (nd *nodeDir) configDir() string {
+	return filepath.Join(nd.path, "config")
+}
+
+func (cd *clientDir) configDir() string {
+	return filepath.Join(cd.path, "config")
+}
+
+// loadNetwork reads every node and client config within dir, and checks that
+// the node configs agree on the network.
+func loadNetwork(dir string) (*network, error) {
+	n := &network{dir: dir}
+
+	nodePaths, err := filepath.Glob(filepath.Join(dir, "*", "config", nodeConfigFile))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range nodePaths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithMessage(err, "could not read node config")
+		}
+
+		nc, err := config.ReadNodeConfig(data)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "could not parse '%s'", path)
+		}
+
+		n.nodes = append(n.nodes, &nodeDir{
+			path:   filepath.Dir(filepath.Dir(path)),
+			config: nc,
+		})
+	}
+
+	clientPaths, err := filepath.Glob(filepath.Join(dir, "*", "config", clientConfigFile))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range clientPaths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithMessage(err, "could not read client config")
+		}
+
+		cc, err := config.ReadClientConfig(data)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "could not parse '%s'", path)
+		}
+
+		n.clients = append(n.clients, &clientDir{
+			path:   filepath.Dir(filepath.Dir(path)),
+			config: cc,
+		})
+	}
+
+	if len(n.nodes) == 0 {
+		return nil, errors.Errorf("'%s' contains no node configs, is it a bootstrap directory?", dir)
+	}
+
+	sort.Slice(n.nodes, func(i, j int) bool { return n.nodes[i].config.ID < n.nodes[j].config.ID })
+	sort.Slice(n.clients, func(i, j int) bool { return n.clients[i].config.ID < n.clients[j].config.ID })
+
+	genesisHash := n.nodes[0].config.Genesis().Hash()
+	for i, node := range n.nodes {
+		if node.config.ID != uint64(i) {
+			return nil, errors.Errorf("expected the config of node %d, but found node %d in '%s'", i, node.config.ID, node.path)
+		}
+
+		if !bytes.Equal(node.config.Genesis().Hash(), genesisHash) {
+			return nil, errors.Errorf("the config in '%s' disagrees with node 0 on the network's nodes, clients, or mir_bootstrap parameters", node.path)
+		}
+	}
+
+	if len(n.nodes) != len(n.nodes[0].config.Nodes) {
+		return nil, errors.Errorf("the configs list %d nodes, but %d node directories were found", len(n.nodes[0].config.Nodes), len(n.nodes))
+	}
+
+	for i, client := range n.clients {
+		if client.config.ID != uint64(i) {
+			return nil, errors.Errorf("expected the config of client %d, but found client %d in '%s'", i, client.config.ID, client.path)
+		}
+	}
+
+	return n, nil
+}
+
+// template returns the config of node 0, whose entries shared by every node
+// may be modified and then saved to each node.
+func (n *network) template() *config.NodeConfig {
+	return n.nodes[0].config
+}
+
+// save writes every node and client config, and the genesis, propagating the
+// network's nodes, clients, and mir_bootstrap parameters from the template.
+func (n *network) save() error {
+	template := n.template()
+	genesis := template.Genesis()
+
+	err := config.WriteGenesisFile(filepath.Join(n.dir, genesisFile), genesis)
+	if err != nil {
+		return errors.WithMessage(err, "could not write genesis")
+	}
+
+	for _, node := range n.nodes {
+		node.config.Nodes = template.Nodes
+		node.config.Clients = template.Clients
+		node.config.MirBootstrap = template.MirBootstrap
+
+		genesisPath := node.config.GenesisFile
+		if genesisPath == "" {
+			genesisPath = genesisFile
+			node.config.GenesisFile = genesisFile
+		}
+		if !filepath.IsAbs(genesisPath) {
+			genesisPath = filepath.Join(node.configDir(), genesisPath)
+		}
+
+		err := config.WriteGenesisFile(genesisPath, genesis)
+		if err != nil {
+			return errors.WithMessagef(err, "could not write genesis for node %d", node.config.ID)
+		}
+
+		err = writeConfig(filepath.Join(node.configDir(), nodeConfigFile), node.config)
+		if err != nil {
+			return errors.WithMessagef(err, "could not write config for node %d", node.config.ID)
+		}
+	}
+
+	for _, client := range n.clients {
+		client.config.Nodes = template.Nodes
+
+		err := writeConfig(filepath.Join(client.configDir(), clientConfigFile), client.config)
+		if err != nil {
+			return errors.WithMessagef(err, "could not write config for client %d", client.config.ID)
+		}
+	}
+
+	return nil
+}
+
+// keyFile returns the path of the key file referenced by a config in dir.
+func keyFile(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// keysEncrypted returns true if the existing key of node 0 is encrypted, in
+// which case new keys should be encrypted likewise.
+func (n *network) keysEncrypted() bool {
+	node := n.nodes[0]
+	if node.config.PrivateKeyFile == "" {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(keyFile(node.configDir(), node.config.PrivateKeyFile))
+	if err != nil {
+		return false
+	}
+
+	return config.IsEncryptedKey(data)
+}
+
+// topology reconstructs the topology the network was bootstrapped from.
+func (n *network) topology() (*topology, error) {
+	t := &topology{}
+	for _, node := range n.nodes {
+		self := node.config.Nodes[node.config.ID]
+		host, port, err := splitHostPort(self.Address)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "node %d has invalid address", node.config.ID)
+		}
+
+		tn := topologyNode{
+			Name: self.Name,
+			Host: host,
+			Port: port,
+		}
+
+		if node.config.BindAddress != "" {
+			tn.BindHost, tn.BindPort, err = splitHostPort(node.config.BindAddress)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "node %d has invalid bind address", node.config.ID)
+			}
+		}
+
+		if node.config.AdminAddress != "" {
+			_, tn.AdminPort, err = splitHostPort(node.config.AdminAddress)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "node %d has invalid admin address", node.config.ID)
+			}
+		}
+
+		if tn.dirName(int(node.config.ID)) != filepath.Base(node.path) {
+			return nil, errors.Errorf("node %d is in '%s', but would be bootstrapped into '%s'", node.config.ID, node.path, tn.dirName(int(node.config.ID)))
+		}
+
+		t.Nodes = append(t.Nodes, tn)
+	}
+
+	return t, nil
+}
+
+func splitHostPort(address string) (string, uint16, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, errors.Errorf("invalid port '%s'", port)
+	}
+
+	return host, uint16(p), nil
+}
+
+func writeConfig(path string, c interface{}) error {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return errors.WithMessage(err, "could not marshal config to yaml")
+	}
+
+	return ioutil.WriteFile(path, out, 0600)
+}
+
+// shortKey abbreviates a public key for display.
+func shortKey(key string) string {
+	if len(key) <= 16 {
+		return key
+	}
+	return key[:16] + "..."
+}
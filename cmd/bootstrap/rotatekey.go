@@ -0,0 +1,85 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/perlin-network/noise"
+	"github.com/pkg/errors"
+)
+
+type rotateKeyArgs struct {
+	kind string // "node" or "client"
+	id   uint64
+}
+
+// rotateKey replaces the key of a node or client, and updates its public key
+// in every config.  The previous key file is kept as a backup.
+func (a *args) rotateKey(n *network) error {
+	if err := a.readPassphraseFor(n); err != nil {
+		return err
+	}
+
+	pubkey, privkey, err := noise.GenerateKeys(nil)
+	if err != nil {
+		return errors.WithMessage(err, "could not generate key")
+	}
+
+	template := n.template()
+	id := a.rotate.id
+
+	var confDir string
+	var privateKey, privateKeyFile *string
+	switch a.rotate.kind {
+	case "node":
+		if id >= uint64(len(n.nodes)) {
+			return errors.Errorf("there is no node %d", id)
+		}
+		template.Nodes[id].PublicKey = pubkey.String()
+		nc := n.nodes[id].config
+		confDir, privateKey, privateKeyFile = n.nodes[id].configDir(), &nc.PrivateKey, &nc.PrivateKeyFile
+	case "client":
+		if id >= uint64(len(n.clients)) {
+			return errors.Errorf("there is no client %d", id)
+		}
+		template.Clients[id].PublicKey = pubkey.String()
+		cc := n.clients[id].config
+		confDir, privateKey, privateKeyFile = n.clients[id].configDir(), &cc.PrivateKey, &cc.PrivateKeyFile
+	}
+
+	var backupPath string
+	if *privateKeyFile != "" {
+		keyPath := keyFile(confDir, *privateKeyFile)
+		backupPath = keyPath + ".bak"
+		err := os.Rename(keyPath, backupPath)
+		if err != nil {
+			return errors.WithMessage(err, "could not back up key file")
+		}
+	}
+
+	// A key embedded in the config is moved to a key file.
+	*privateKey = ""
+	*privateKeyFile, err = a.writeKey(confDir, privkey.String())
+	if err != nil {
+		return errors.WithMessage(err, "could not write key file")
+	}
+
+	err = n.save()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rotated the key of %s %d", a.rotate.kind, id)
+	if backupPath != "" {
+		fmt.Printf(", the previous key was saved to %s", backupPath)
+	}
+	fmt.Printf("\n")
+
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/perlin-network/noise"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// publicKey returns the public key of the hex encoded private key.
+func publicKey(t *testing.T, privateKey string) string {
+	key, err := noise.LoadKeysFromHex(privateKey)
+	require.NoError(t, err)
+	return key.Public().String()
+}
+
+func TestRotateKey(t *testing.T) {
+	tests := []struct {
+		kind string
+		id   uint64
+	}{
+		{kind: "node", id: 1},
+		{kind: "client", id: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			dir := initNetwork(t)
+			before := genesisHash(t, dir)
+			nodeConfigs, clientConfigs := loadConfigs(t, dir)
+			var oldKey string
+			switch tt.kind {
+			case "node":
+				oldKey = nodeConfigs[tt.id].PrivateKey
+			case "client":
+				oldKey = clientConfigs[tt.id].PrivateKey
+			}
+
+			require.NoError(t, runCommand(t, "rotate-key", "--outputDir", dir, tt.kind, strconv.FormatUint(tt.id, 10)))
+
+			nodeConfigs, clientConfigs = loadConfigs(t, dir)
+			assert.NotEqual(t, before, genesisHash(t, dir))
+
+			confDir := filepath.Join(dir, fmt.Sprintf("%s%d", tt.kind, tt.id), "config")
+			backup, err := ioutil.ReadFile(filepath.Join(confDir, defaultKeyFile+".bak"))
+			require.NoError(t, err)
+			assert.Equal(t, oldKey, strings.TrimSpace(string(backup)), "the previous key is kept")
+
+			if tt.kind == "client" {
+				newKey := clientConfigs[tt.id].PrivateKey
+				assert.NotEqual(t, oldKey, newKey)
+				for _, nc := range nodeConfigs {
+					assert.Equal(t, publicKey(t, newKey), nc.Clients[tt.id].PublicKey, "in the config of node %d", nc.ID)
+					assert.NotEqual(t, publicKey(t, oldKey), nc.Clients[tt.id].PublicKey, "in the config of node %d", nc.ID)
+				}
+				return
+			}
+
+			nc := nodeConfigs[tt.id]
+			assert.NotEqual(t, oldKey, nc.PrivateKey)
+			for _, other := range nodeConfigs {
+				assert.Equal(t, publicKey(t, nc.PrivateKey), other.Nodes[tt.id].PublicKey, "in the config of node %d", other.ID)
+			}
+
+			nc.PrivateKey = oldKey
+			nc.PrivateKeyFile = ""
+			assert.EqualError(t, nc.Validate(), "invalid config, 1 problem(s):\n  private_key: does not correspond to the public key of node 1 in nodes")
+		})
+	}
+}
+
+func TestRotateKeyUnknown(t *testing.T) {
+	dir := initNetwork(t)
+
+	err := runCommand(t, "rotate-key", "--outputDir", dir, "node", "4")
+	assert.EqualError(t, err, "there is no node 4")
+
+	err = runCommand(t, "rotate-key", "--outputDir", dir, "client", "1")
+	assert.EqualError(t, err, "there is no client 1")
+
+	assert.NoFileExists(t, filepath.Join(dir, "node0", "config", defaultKeyFile+".bak"))
+}
@@ -0,0 +1,51 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// show prints a summary of the network.
+func show(w io.Writer, n *network) error {
+	template := n.template()
+	mb := template.MirBootstrap
+
+	fmt.Fprintf(w, "Genesis hash: %x\n", template.Genesis().Hash())
+	fmt.Fprintf(w, "Bootstrap: number_of_buckets=%d client_window_size=%d checkpoint_interval=%d\n\n", mb.NumberOfBuckets, mb.ClientWindowSize, mb.CheckpointInterval)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NODE\tNAME\tADDRESS\tPUBLIC KEY\tDIR\n")
+	for i, node := range template.Nodes {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", node.ID, orDash(node.Name), node.Address, shortKey(node.PublicKey), n.nodes[i].path)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+
+	dirs := map[uint64]string{}
+	for _, client := range n.clients {
+		dirs[client.config.ID] = client.path
+	}
+
+	fmt.Fprintf(tw, "CLIENT\tPUBLIC KEY\tDIR\n")
+	for _, client := range template.Clients {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", client.ID, shortKey(client.PublicKey), orDash(dirs[client.ID]))
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
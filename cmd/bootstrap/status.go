@@ -0,0 +1,221 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/pkg/errors"
+)
+
+type statusArgs struct {
+	// nodes are the IDs of the nodes to query, or every node if empty.
+	nodes   []uint64
+	timeout time.Duration
+	json    bool
+}
+
+// nodeStatus is the status reported by a node, or the reason it could not be
+// queried.
+type nodeStatus struct {
+	ID      uint64         `json:"id"`
+	Address string         `json:"admin_address"`
+	Status  *sample.Status `json:"status,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// status queries the admin API of the selected nodes concurrently and prints
+// a summary of the cluster.
+func (sa *statusArgs) status(w io.Writer, n *network) error {
+	selected := map[uint64]bool{}
+	for _, id := range sa.nodes {
+		selected[id] = true
+	}
+
+	var statuses []*nodeStatus
+	for _, node := range n.nodes {
+		if len(selected) > 0 && !selected[node.config.ID] {
+			continue
+		}
+		delete(selected, node.config.ID)
+		statuses = append(statuses, &nodeStatus{
+			ID:      node.config.ID,
+			Address: adminAddress(node),
+		})
+	}
+
+	for id := range selected {
+		return errors.Errorf("node %d is not part of the network", id)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+
+	var wg sync.WaitGroup
+	for _, ns := range statuses {
+		if ns.Address == "" {
+			ns.Error = "no admin_address configured"
+			continue
+		}
+
+		wg.Add(1)
+		go func(ns *nodeStatus) {
+			defer wg.Done()
+			status, err := queryStatus(ns.Address, sa.timeout)
+			if err != nil {
+				ns.Error = err.Error()
+				return
+			}
+			ns.Status = status
+		}(ns)
+	}
+	wg.Wait()
+
+	if sa.json {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statuses)
+	}
+
+	return printStatuses(w, statuses)
+}
+
+// adminAddress returns the address at which to reach the node's admin API.
+// A node serving it on all interfaces is reached at its host.
+func adminAddress(node *nodeDir) string {
+	if node.config.AdminAddress == "" {
+		return ""
+	}
+
+	host, port, err := net.SplitHostPort(node.config.AdminAddress)
+	if err != nil {
+		return node.config.AdminAddress
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host, _, _ = net.SplitHostPort(node.config.Nodes[node.config.ID].Address)
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+func queryStatus(address string, timeout time.Duration) (*sample.Status, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, errors.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	status := &sample.Status{}
+	if err := json.NewDecoder(res.Body).Decode(status); err != nil {
+		return nil, errors.WithMessage(err, "could not decode status")
+	}
+
+	return status, nil
+}
+
+func printStatuses(w io.Writer, statuses []*nodeStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NODE\tADMIN ADDRESS\tEPOCH\tSTATE\tLEADERS\tCOMMITTED\tCHECKPOINT\tWATERMARKS\tPEERS\n")
+	for _, ns := range statuses {
+		s := ns.Status
+		if s == nil {
+			fmt.Fprintf(tw, "%d\t%s\tunavailable: %s\n", ns.ID, orDash(ns.Address), ns.Error)
+			continue
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t%d\t%d\t%d-%d\t%d/%d\n",
+			ns.ID, ns.Address, s.Epoch, orDash(s.EpochState), joinIDs(s.Leaders),
+			s.LastCommittedSeqNo, s.StableCheckpointSeqNo, s.LowWatermark, s.HighWatermark,
+			sample.ConnectedPeers(s.Peers), len(s.Peers))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// Each client's window, as seen by each node.
+	var ids []uint64
+	windows := map[uint64]map[uint64]sample.ClientStatus{}
+	for _, ns := range statuses {
+		if ns.Status == nil {
+			continue
+		}
+		ids = append(ids, ns.ID)
+		for _, client := range ns.Status.Clients {
+			if windows[client.ID] == nil {
+				windows[client.ID] = map[uint64]sample.ClientStatus{}
+			}
+			windows[client.ID][ns.ID] = client
+		}
+	}
+
+	if len(windows) == 0 {
+		return nil
+	}
+
+	var clientIDs []uint64
+	for id := range windows {
+		clientIDs = append(clientIDs, id)
+	}
+	sort.Slice(clientIDs, func(i, j int) bool { return clientIDs[i] < clientIDs[j] })
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(tw, "CLIENT")
+	for _, id := range ids {
+		fmt.Fprintf(tw, "\tNODE %d", id)
+	}
+	fmt.Fprintln(tw)
+	for _, clientID := range clientIDs {
+		fmt.Fprintf(tw, "%d", clientID)
+		for _, id := range ids {
+			window, ok := windows[clientID][id]
+			if !ok {
+				fmt.Fprintf(tw, "\t-")
+				continue
+			}
+			fmt.Fprintf(tw, "\t%d-%d", window.LowWatermark, window.HighWatermark)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func joinIDs(ids []uint64) string {
+	if len(ids) == 0 {
+		return "-"
+	}
+
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprint(id)
+	}
+	return strings.Join(s, ",")
+}
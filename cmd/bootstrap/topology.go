@@ -0,0 +1,214 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// topology describes where each node of the network runs.  For example:
+//
+//	nodes:
+//	- name: alpha
+//	  host: alpha.example.com
+//	  port: 5000
+//	- host: 2001:db8::2
+//	  port: 5000
+//	  bind_host: "::"
+//
+// Nodes are assigned IDs in the order they are listed.
+type topology struct {
+	Nodes []topologyNode `yaml:"nodes"`
+}
+
+type topologyNode struct {
+	// Name optionally names the node, and its output directory, which
+	// otherwise defaults to node<id>.
+	Name string `yaml:"name"`
+
+	// Host and Port are the address at which peers and clients reach the
+	// node.  Host may be an IPv4 or IPv6 address, or a DNS name.
+	Host string `yaml:"host"`
+	Port uint16 `yaml:"port"`
+
+	// BindHost and BindPort are the local address the node listens on, if
+	// it differs from the advertised address, for instance behind NAT.
+	// BindHost must be an IP, and BindPort defaults to Port.
+	BindHost string `yaml:"bind_host"`
+	BindPort uint16 `yaml:"bind_port"`
+
+	// AdminPort is the port the node serves its admin API on, at BindHost
+	// if set, and otherwise at Host.  If zero, it is the node's port plus
+	// the admin port offset given to bootstrap, if that is not zero.
+	AdminPort uint16 `yaml:"admin_port"`
+}
+
+func loadTopology(path string) (*topology, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not read topology")
+	}
+
+	t := &topology{}
+	if err := yaml.UnmarshalStrict(data, t); err != nil {
+		return nil, errors.WithMessage(err, "could not parse topology")
+	}
+
+	if len(t.Nodes) == 0 {
+		return nil, errors.Errorf("topology contains no nodes")
+	}
+
+	for i := range t.Nodes {
+		if err := t.Nodes[i].validate(i); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := t.checkDistinct(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// checkDistinct checks that no two nodes have the same address, or would be
+// bootstrapped into the same directory.
+func (t *topology) checkDistinct() error {
+	addresses := map[string]int{}
+	dirs := map[string]int{}
+	for i, tn := range t.Nodes {
+		address := tn.canonicalAddress()
+		if prev, ok := addresses[address]; ok {
+			return errors.Errorf("topology nodes %d and %d have the same address '%s'", prev, i, tn.address())
+		}
+		addresses[address] = i
+
+		dir := tn.dirName(i)
+		if prev, ok := dirs[dir]; ok {
+			return errors.Errorf("topology nodes %d and %d would both be bootstrapped into '%s'", prev, i, dir)
+		}
+		dirs[dir] = i
+	}
+
+	return nil
+}
+
+func (tn *topologyNode) validate(id int) error {
+	if tn.Host == "" || tn.Port == 0 {
+		return errors.Errorf("topology node %d must have a host and port", id)
+	}
+
+	if host := trimBrackets(tn.Host); strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return errors.Errorf("topology node %d has host '%s' which includes a port, give it as port instead", id, tn.Host)
+	}
+
+	if tn.BindHost != "" && net.ParseIP(trimBrackets(tn.BindHost)) == nil {
+		return errors.Errorf("topology node %d has bind_host '%s' which is not an IP address", id, tn.BindHost)
+	}
+
+	if strings.ContainsAny(tn.Name, `/\`) || tn.Name == "." || tn.Name == ".." {
+		return errors.Errorf("topology node %d has name '%s' which is not usable as a directory name", id, tn.Name)
+	}
+
+	return nil
+}
+
+// localTopology places every node on the loopback interface, with ports
+// incrementing from basePort.
+func localTopology(basePort, nodeCount uint16) *topology {
+	t := &topology{}
+	for i := uint16(0); i < nodeCount; i++ {
+		t.Nodes = append(t.Nodes, topologyNode{
+			Host: "127.0.0.1",
+			Port: basePort + i,
+		})
+	}
+	return t
+}
+
+func (tn *topologyNode) address() string {
+	return net.JoinHostPort(trimBrackets(tn.Host), strconv.Itoa(int(tn.Port)))
+}
+
+// canonicalAddress is the node's address with its IP, if it has one, in
+// canonical form, and its DNS name otherwise in lower case, so that two nodes
+// with the same address may be recognized however it is written.
+func (tn *topologyNode) canonicalAddress() string {
+	host := trimBrackets(tn.Host)
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(host)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(tn.Port)))
+}
+
+func (tn *topologyNode) bindAddress() string {
+	if tn.BindHost == "" && tn.BindPort == 0 {
+		return ""
+	}
+
+	port := tn.BindPort
+	if port == 0 {
+		port = tn.Port
+	}
+
+	return net.JoinHostPort(trimBrackets(tn.BindHost), strconv.Itoa(int(port)))
+}
+
+// setAdminPort assigns the node an admin port offset from the port it listens
+// on, unless it has one already or offset is zero.
+func (tn *topologyNode) setAdminPort(id int, offset uint16) error {
+	if tn.AdminPort != 0 || offset == 0 {
+		return nil
+	}
+
+	port := tn.Port
+	if tn.BindPort != 0 {
+		port = tn.BindPort
+	}
+
+	if uint32(port)+uint32(offset) > math.MaxUint16 {
+		return errors.Errorf("topology node %d has port %d, which cannot be offset by %d to give an admin port", id, port, offset)
+	}
+
+	tn.AdminPort = port + offset
+	return nil
+}
+
+func (tn *topologyNode) adminAddress() string {
+	if tn.AdminPort == 0 {
+		return ""
+	}
+
+	host := tn.BindHost
+	if host == "" {
+		host = tn.Host
+	}
+
+	return net.JoinHostPort(trimBrackets(host), strconv.Itoa(int(tn.AdminPort)))
+}
+
+func (tn *topologyNode) dirName(id int) string {
+	if tn.Name != "" {
+		return tn.Name
+	}
+	return fmt.Sprintf("node%d", id)
+}
+
+// trimBrackets allows IPv6 hosts to be written either bare or in brackets.
+func trimBrackets(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
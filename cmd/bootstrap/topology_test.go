@@ -0,0 +1,198 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTopology(t *testing.T) {
+	tests := []struct {
+		name     string
+		topology string
+		address  []string
+		bind     []string
+		err      string
+	}{
+		{
+			name: "IPv4",
+			topology: `
+nodes:
+- host: 10.0.0.1
+  port: 5000
+- host: 10.0.0.1
+  port: 5001
+  bind_host: 0.0.0.0
+`,
+			address: []string{"10.0.0.1:5000", "10.0.0.1:5001"},
+			bind:    []string{"", "0.0.0.0:5001"},
+		},
+		{
+			name: "IPv6",
+			topology: `
+nodes:
+- host: 2001:db8::2
+  port: 5000
+- host: "[::1]"
+  port: 5000
+  bind_host: "[::]"
+  bind_port: 6000
+`,
+			address: []string{"[2001:db8::2]:5000", "[::1]:5000"},
+			bind:    []string{"", "[::]:6000"},
+		},
+		{
+			name: "hostnames",
+			topology: `
+nodes:
+- name: alpha
+  host: alpha.example.com
+  port: 5000
+- host: beta.example.com
+  port: 5000
+  bind_host: 10.0.0.2
+`,
+			address: []string{"alpha.example.com:5000", "beta.example.com:5000"},
+			bind:    []string{"", "10.0.0.2:5000"},
+		},
+		{
+			name: "missing port",
+			topology: `
+nodes:
+- host: 10.0.0.1
+`,
+			err: "topology node 0 must have a host and port",
+		},
+		{
+			name: "missing host",
+			topology: `
+nodes:
+- host: 10.0.0.1
+  port: 5000
+- port: 5001
+`,
+			err: "topology node 1 must have a host and port",
+		},
+		{
+			name: "IPv6 host with port",
+			topology: `
+nodes:
+- host: "[::1]:5000"
+  port: 5000
+`,
+			err: "topology node 0 has host '[::1]:5000' which includes a port, give it as port instead",
+		},
+		{
+			name: "hostname with port",
+			topology: `
+nodes:
+- host: alpha.example.com:5000
+  port: 5000
+`,
+			err: "topology node 0 has host 'alpha.example.com:5000' which includes a port, give it as port instead",
+		},
+		{
+			name: "bind_host hostname",
+			topology: `
+nodes:
+- host: alpha.example.com
+  port: 5000
+  bind_host: alpha.example.com
+`,
+			err: "topology node 0 has bind_host 'alpha.example.com' which is not an IP address",
+		},
+		{
+			name: "duplicate address",
+			topology: `
+nodes:
+- host: 10.0.0.1
+  port: 5000
+- host: 10.0.0.2
+  port: 5000
+- host: 10.0.0.1
+  port: 5000
+`,
+			err: "topology nodes 0 and 2 have the same address '10.0.0.1:5000'",
+		},
+		{
+			name: "duplicate IPv6 address written differently",
+			topology: `
+nodes:
+- host: "::1"
+  port: 5000
+- host: "[0:0::1]"
+  port: 5000
+`,
+			err: "topology nodes 0 and 1 have the same address '[0:0::1]:5000'",
+		},
+		{
+			name: "duplicate hostname in another case",
+			topology: `
+nodes:
+- host: alpha.example.com
+  port: 5000
+- host: Alpha.Example.com
+  port: 5000
+`,
+			err: "topology nodes 0 and 1 have the same address 'Alpha.Example.com:5000'",
+		},
+		{
+			name: "duplicate directory",
+			topology: `
+nodes:
+- host: 10.0.0.1
+  port: 5000
+- name: node0
+  host: 10.0.0.2
+  port: 5000
+`,
+			err: "topology nodes 0 and 1 would both be bootstrapped into 'node0'",
+		},
+		{
+			name:     "no nodes",
+			topology: "nodes: []\n",
+			err:      "topology contains no nodes",
+		},
+		{
+			name: "unknown field",
+			topology: `
+nodes:
+- host: 10.0.0.1
+  port: 5000
+  address: 10.0.0.1:5000
+`,
+			err: "could not parse topology: yaml: unmarshal errors:\n  line 5: field address not found in type main.topologyNode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "topology.yaml")
+			require.NoError(t, ioutil.WriteFile(path, []byte(tt.topology), 0600))
+
+			topology, err := loadTopology(path)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			var address, bind []string
+			for _, tn := range topology.Nodes {
+				address = append(address, tn.address())
+				bind = append(bind, tn.bindAddress())
+			}
+			assert.Equal(t, tt.address, address)
+			assert.Equal(t, tt.bind, bind)
+		})
+	}
+}
@@ -7,50 +7,106 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	sample "github.com/jyellick/mirbft-sample"
 	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/logging"
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/jyellick/mirbft-sample/tracing"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
 )
 
 type args struct {
-	clientConfig *os.File
-	requestCount uint64
-	requestSize  uint16
+	command        string
+	clientConfig   *os.File
+	sets           []string
+	printConfig    bool
+	requestCount   uint64
+	requestSize    uint16
+	requestTimeout time.Duration
+	maxAttempts    int
+	fromSeqNo      uint64
+	includeData    bool
 }
 
 func parseArgs(argsString []string) (*args, error) {
 	app := kingpin.New("client", "A small sample client for the mirbft-sample application.")
 	clientConfig := app.Flag("clientConfig", "The YAML file containing this client's config (as generated via bootstrap).").Required().File()
-	requestCount := app.Flag("requestCount", "The total number of requests to send").Default("10000").Uint64()
-	requestSize := app.Flag("requestSize", "The size in bytes for each request (must be at least 26 bytes)").Default("10240").Uint16()
+	sets := app.Flag("set", "Override a config field, as key=value, e.g. nodes.0.address=10.0.0.1:5000 (may be repeated).  Fields may also be overridden by environment variables, e.g. "+config.ClientEnvPrefix+"NODES_0_ADDRESS=10.0.0.1:5000.").Strings()
+	printConfig := app.Flag("printConfig", "Print the effective config, with secrets redacted, and exit.").Default("false").Bool()
+	requestTimeout := app.Flag("requestTimeout", "How long to wait for a node to acknowledge a request before resending it").Default("5s").Duration()
 
-	_, err := app.Parse(argsString)
+	submit := app.Command("submit", "Submit requests to the network.").Default()
+	requestCount := submit.Flag("requestCount", "The total number of requests to send").Default("10000").Uint64()
+	requestSize := submit.Flag("requestSize", "The size in bytes for each request (must be at least 26 bytes)").Default("10240").Uint16()
+	maxAttempts := submit.Flag("maxAttempts", "The number of times to send a request to a node before giving up on that node").Default("5").Int()
+
+	subscribe := app.Command("subscribe", "Print each entry committed by the network, once verified by f+1 nodes.")
+	fromSeqNo := subscribe.Flag("fromSeqNo", "The sequence number of the first entry to print.").Default("1").Uint64()
+	includeData := subscribe.Flag("includeData", "Whether to retrieve the request data, rather than only its digest.").Default("false").Bool()
+
+	command, err := app.Parse(argsString)
 	if err != nil {
 		return nil, err
 	}
 
+	if *requestTimeout <= 0 {
+		return nil, errors.Errorf("requestTimeout must be positive")
+	}
+
+	if command == submit.FullCommand() && *maxAttempts < 1 {
+		return nil, errors.Errorf("maxAttempts must be at least 1")
+	}
+
+	if command == submit.FullCommand() && *requestCount < 1 {
+		return nil, errors.Errorf("requestCount must be at least 1")
+	}
+
 	return &args{
-		clientConfig: *clientConfig,
-		requestCount: *requestCount,
-		requestSize:  *requestSize,
+		command:        command,
+		clientConfig:   *clientConfig,
+		sets:           *sets,
+		printConfig:    *printConfig,
+		requestCount:   *requestCount,
+		requestSize:    *requestSize,
+		requestTimeout: *requestTimeout,
+		maxAttempts:    *maxAttempts,
+		fromSeqNo:      *fromSeqNo,
+		includeData:    *includeData,
 	}, nil
 
 }
 
-func (a *args) initializeClient() (*sample.Client, error) {
-	clientConfig, err := config.LoadClientConfig(a.clientConfig)
+func (a *args) loadConfig() (*config.ClientConfig, error) {
+	clientConfig, err := config.LoadClientConfig(
+		a.clientConfig,
+		config.WithEnv(config.ClientEnvPrefix),
+		config.WithSets(a.sets),
+		config.WithBaseDir(filepath.Dir(a.clientConfig.Name())),
+		config.WithPassphrase(config.ReadPassphrase),
+	)
 	if err != nil {
 		return nil, errors.WithMessage(err, "could not parse client config")
 	}
 
+	return clientConfig, nil
+}
+
+func (a *args) initializeClient(clientConfig *config.ClientConfig, loggers *logging.Loggers) (*sample.Client, error) {
+
 	return &sample.Client{
-		Logger:       zap.NewExample().Sugar(),
-		ClientConfig: clientConfig,
+		Loggers:        loggers,
+		ClientConfig:   clientConfig,
+		RequestTimeout: a.requestTimeout,
+		MaxAttempts:    a.maxAttempts,
 	}, nil
 }
 
@@ -61,15 +117,66 @@ func main() {
 		kingpin.Fatalf("Error parsing arguments, %s, try --help", err)
 	}
 
-	client, err := args.initializeClient()
+	clientConfig, err := args.loadConfig()
 	if err != nil {
 		kingpin.Fatalf("Error initializing client, %s", err)
 	}
 
-	err = client.Run(args.requestCount, args.requestSize)
+	if args.printConfig {
+		out, err := yaml.Marshal(clientConfig.Redacted())
+		if err != nil {
+			kingpin.Fatalf("Error printing config, %s", err)
+		}
+		fmt.Print(string(out))
+		return
+	}
+
+	loggers, err := logging.New(&clientConfig.Logging, zap.Uint64("client_id", clientConfig.ID))
 	if err != nil {
+		kingpin.Fatalf("Error initializing logging, %s", err)
+	}
+	defer loggers.Close()
+
+	tracing.LogErrors(loggers.Named(logging.App))
+	tracer, err := tracing.New(&clientConfig.Tracing, "mirbft-sample-client", tracing.ClientID.Int64(int64(clientConfig.ID)))
+	if err != nil {
+		kingpin.Fatalf("Error initializing tracing, %s", err)
+	}
+	defer func() {
+		if err := tracer.Close(); err != nil {
+			loggers.Named(logging.App).Warnw("Could not export the remaining spans", "error", err)
+		}
+	}()
+
+	client, err := args.initializeClient(clientConfig, loggers)
+	if err != nil {
+		kingpin.Fatalf("Error initializing client, %s", err)
+	}
+	client.Tracer = tracer.Tracer()
+
+	switch args.command {
+	case "subscribe":
+		err = client.Subscribe(context.Background(), args.fromSeqNo, args.includeData, printEntry)
+	default:
+		err = client.Run(args.requestCount, args.requestSize)
+	}
+	if err != nil {
+		tracer.Close()
+		loggers.Close()
 		kingpin.Fatalf("Client exited abnormally, %s", err)
 	}
 
-	fmt.Printf("Success! All worker go routines exited, terminating!\n")
+	loggers.Named(logging.App).Infow("All worker go routines exited, terminating")
+}
+
+func printEntry(entry *network.CommittedEntry) error {
+	fmt.Printf("seq_no=%d requests=%d\n", entry.SeqNo, len(entry.Requests))
+	for _, req := range entry.Requests {
+		if req.Data != nil {
+			fmt.Printf("  client_id=%d req_no=%d digest=%x data_length=%d\n", req.ClientID, req.ReqNo, req.Digest, len(req.Data))
+			continue
+		}
+		fmt.Printf("  client_id=%d req_no=%d digest=%x\n", req.ClientID, req.ReqNo, req.Digest)
+	}
+	return nil
 }
@@ -0,0 +1,319 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	nodeConfigFile = "node-config.yaml"
+	pidFile        = "node.pid"
+	logFile        = "node.log"
+)
+
+// cluster is a network bootstrapped into dir, whose nodes run locally.
+type cluster struct {
+	dir    string
+	binDir string
+	nodes  []*node
+}
+
+// node is a node of the cluster, whose process is tracked by the pid file in
+// its directory, and which logs to the log file alongside it.
+type node struct {
+	id     uint64
+	path   string
+	config *config.NodeConfig
+}
+
+func (n *node) name() string {
+	return filepath.Base(n.path)
+}
+
+func (n *node) configPath() string {
+	return filepath.Join(n.path, "config", nodeConfigFile)
+}
+
+func (n *node) runDir() string {
+	return filepath.Join(n.path, "run")
+}
+
+func (n *node) pidPath() string {
+	return filepath.Join(n.path, pidFile)
+}
+
+func (n *node) logPath() string {
+	return filepath.Join(n.path, logFile)
+}
+
+// loadCluster finds every node config within dir.  The nodes are referred to
+// by absolute paths, so that their processes are recognized whatever the
+// working directory.
+func loadCluster(dir, binDir string) (*cluster, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cluster{dir: dir, binDir: binDir}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "config", nodeConfigFile))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithMessage(err, "could not read node config")
+		}
+
+		nc, err := config.ReadNodeConfig(data)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "could not parse '%s'", path)
+		}
+
+		c.nodes = append(c.nodes, &node{
+			id:     nc.ID,
+			path:   filepath.Dir(filepath.Dir(path)),
+			config: nc,
+		})
+	}
+
+	if len(c.nodes) == 0 {
+		return nil, errors.Errorf("'%s' contains no node configs, is it a bootstrap directory?", dir)
+	}
+
+	sort.Slice(c.nodes, func(i, j int) bool { return c.nodes[i].id < c.nodes[j].id })
+
+	return c, nil
+}
+
+// node returns the node with the given ID, or directory name.
+func (c *cluster) node(name string) (*node, error) {
+	for _, n := range c.nodes {
+		if n.name() == name || strconv.FormatUint(n.id, 10) == name {
+			return n, nil
+		}
+	}
+
+	return nil, errors.Errorf("no node with ID or directory '%s' in '%s'", name, c.dir)
+}
+
+// binary returns the path to the named binary within the bin directory.
+func binary(binDir, name string) (string, error) {
+	path := filepath.Join(binDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", errors.Errorf("could not find the %s binary in '%s', build it with 'go build ./cmd/%s' or set --binDir", name, binDir, name)
+	}
+	return path, nil
+}
+
+// processState describes whether a node is running.
+type processState string
+
+const (
+	// stopped nodes have no pid file.
+	stopped processState = "stopped"
+
+	// exited nodes have a pid file, but the process has gone, as it crashed
+	// or was killed.
+	exited processState = "exited"
+
+	running processState = "running"
+)
+
+// state returns whether the node is running, and its pid if it has a pid
+// file.
+func (n *node) state() (processState, int, error) {
+	data, err := ioutil.ReadFile(n.pidPath())
+	if os.IsNotExist(err) {
+		return stopped, 0, nil
+	}
+	if err != nil {
+		return "", 0, errors.WithMessage(err, "could not read pid file")
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", 0, errors.WithMessagef(err, "could not parse pid file '%s'", n.pidPath())
+	}
+
+	if !n.isProcess(pid) {
+		return exited, pid, nil
+	}
+
+	return running, pid, nil
+}
+
+// isProcess returns whether the process with the pid is this node.  Where
+// the command line of the process can be read, it must name the node's
+// config, so that a stale pid file reused by another process is ignored.
+func (n *node) isProcess(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return true
+	}
+
+	return bytes.Contains(cmdline, []byte(n.configPath()))
+}
+
+// start starts the node in a process group of its own, so that it outlives
+// the cluster command, appending its output to its log file.  The process
+// is returned so that the caller may check it started cleanly.
+func (c *cluster) start(n *node, nodeArgs []string) (*exec.Cmd, error) {
+	state, pid, err := n.state()
+	if err != nil {
+		return nil, err
+	}
+	if state == running {
+		return nil, errors.Errorf("node %d is already running as pid %d", n.id, pid)
+	}
+
+	nodeBinary, err := binary(c.binDir, "node")
+	if err != nil {
+		return nil, err
+	}
+
+	logFile, err := os.OpenFile(n.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not open log file")
+	}
+	defer logFile.Close()
+
+	args := append([]string{"--nodeConfig=" + n.configPath(), "--runDir=" + n.runDir()}, nodeArgs...)
+	cmd := exec.Command(nodeBinary, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, errors.WithMessagef(err, "could not start node %d", n.id)
+	}
+
+	err = ioutil.WriteFile(n.pidPath(), []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644)
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, errors.WithMessage(err, "could not write pid file")
+	}
+
+	return cmd, nil
+}
+
+// awaitStarted waits for the grace period, returning an error if any of the
+// nodes exits within it.
+func awaitStarted(nodes []*node, cmds []*exec.Cmd, grace time.Duration) error {
+	type exit struct {
+		node *node
+		err  error
+	}
+
+	exitC := make(chan exit, len(cmds))
+	for i, cmd := range cmds {
+		go func(n *node, cmd *exec.Cmd) {
+			exitC <- exit{node: n, err: cmd.Wait()}
+		}(nodes[i], cmd)
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	var failed []string
+wait:
+	for len(failed) < len(cmds) {
+		select {
+		case e := <-exitC:
+			failed = append(failed, fmt.Sprintf("node %d exited straight away (%v), see %s", e.node.id, e.err, e.node.logPath()))
+		case <-timer.C:
+			break wait
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// stop signals the node to stop, and waits for it to exit, killing it if it
+// has not within the timeout.  The pid file is removed once it has exited.
+func (n *node) stop(timeout time.Duration) error {
+	state, pid, err := n.state()
+	if err != nil {
+		return err
+	}
+
+	if state == running {
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			return errors.WithMessagef(err, "could not signal node %d", n.id)
+		}
+
+		if !n.awaitExit(pid, timeout) {
+			fmt.Printf("Node %d did not stop within %s, killing it\n", n.id, timeout)
+			if err := n.kill(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Remove(n.pidPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithMessage(err, "could not remove pid file")
+	}
+
+	return nil
+}
+
+// kill kills the node without giving it the chance to stop cleanly, leaving
+// its pid file in place, as a crashed node would.
+func (n *node) kill() error {
+	state, pid, err := n.state()
+	if err != nil {
+		return err
+	}
+	if state != running {
+		return errors.Errorf("node %d is not running", n.id)
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		return errors.WithMessagef(err, "could not kill node %d", n.id)
+	}
+
+	if !n.awaitExit(pid, 5*time.Second) {
+		return errors.Errorf("node %d has not exited after being killed", n.id)
+	}
+
+	return nil
+}
+
+func (n *node) awaitExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for n.isProcess(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exitedPid returns the pid of a process which has exited.
+func exitedPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+// nodePid starts a process whose command line names the node's config, as
+// the node's own does, and returns its pid.
+func nodePid(t *testing.T, n *node) int {
+	cmd := exec.Command("sh", "-c", "sleep 60", n.configPath())
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd.Process.Pid
+}
+
+func TestNodeState(t *testing.T) {
+	tests := []struct {
+		name    string
+		pidFile func(t *testing.T, n *node) string
+		state   processState
+		pid     bool
+		err     string
+	}{
+		{
+			name:  "no pid file",
+			state: stopped,
+		},
+		{
+			name: "stale pid file",
+			pidFile: func(t *testing.T, n *node) string {
+				return strconv.Itoa(exitedPid(t))
+			},
+			state: exited,
+			pid:   true,
+		},
+		{
+			name: "pid reused by another process",
+			pidFile: func(t *testing.T, n *node) string {
+				return strconv.Itoa(os.Getpid())
+			},
+			state: exited,
+			pid:   true,
+		},
+		{
+			name: "running",
+			pidFile: func(t *testing.T, n *node) string {
+				return strconv.Itoa(nodePid(t, n)) + "\n"
+			},
+			state: running,
+			pid:   true,
+		},
+		{
+			name: "unparseable pid file",
+			pidFile: func(t *testing.T, n *node) string {
+				return "node0"
+			},
+			err: `could not parse pid file '%s': strconv.Atoi: parsing "node0": invalid syntax`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &node{id: 0, path: t.TempDir()}
+			var pid int
+			if tt.pidFile != nil {
+				data := tt.pidFile(t, n)
+				pid, _ = strconv.Atoi(strings.TrimSpace(data))
+				require.NoError(t, ioutil.WriteFile(n.pidPath(), []byte(data), 0644))
+			}
+
+			state, statePid, err := n.state()
+			if tt.err != "" {
+				assert.EqualError(t, err, fmt.Sprintf(tt.err, n.pidPath()))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.state, state)
+			if tt.pid {
+				assert.Equal(t, pid, statePid)
+			} else {
+				assert.Zero(t, statePid)
+			}
+		})
+	}
+}
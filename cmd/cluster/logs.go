@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type logsArgs struct {
+	follow bool
+	// lines is the number of lines to print from the end of the log, or
+	// zero to print it all.
+	lines int
+}
+
+// followInterval is how often a followed log is checked for more output.
+const followInterval = 250 * time.Millisecond
+
+func (la *logsArgs) run(w io.Writer, n *node) error {
+	f, err := os.Open(n.logPath())
+	if err != nil {
+		return errors.WithMessagef(err, "could not open the log of node %d", n.id)
+	}
+	defer f.Close()
+
+	if la.lines > 0 {
+		if err := seekLines(f, la.lines); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.Copy(w, f); err != nil {
+		return err
+	}
+	if !la.follow {
+		return nil
+	}
+
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	for {
+		time.Sleep(followInterval)
+
+		info, err := os.Stat(n.logPath())
+		if err != nil {
+			return errors.WithMessagef(err, "could not stat the log of node %d", n.id)
+		}
+
+		// The log shrank, as it was truncated or replaced, so begin again.
+		if info.Size() < offset {
+			f.Close()
+			f, err = os.Open(n.logPath())
+			if err != nil {
+				return errors.WithMessagef(err, "could not reopen the log of node %d", n.id)
+			}
+			offset = 0
+		}
+
+		written, err := io.Copy(w, f)
+		if err != nil {
+			return err
+		}
+		offset += written
+	}
+}
+
+// seekLines positions f at the start of the last count lines.
+func seekLines(f *os.File, count int) error {
+	// The offsets of the starts of the last count lines, as a ring.
+	starts := make([]int64, count)
+	var lines int
+	var offset int64
+	atStart := true
+
+	r := bufio.NewReader(f)
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(chunk) > 0 {
+			if atStart {
+				starts[lines%count] = offset
+				lines++
+			}
+			offset += int64(len(chunk))
+			atStart = chunk[len(chunk)-1] == '\n'
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+
+	start := int64(0)
+	if lines > count {
+		start = starts[lines%count]
+	}
+
+	_, err := f.Seek(start, io.SeekStart)
+	return err
+}
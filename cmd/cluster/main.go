@@ -0,0 +1,354 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type args struct {
+	command string
+	dir     string
+	binDir  string
+	node    string
+	timeout time.Duration
+	// nodeArgs are passed to each node started.
+	nodeArgs []string
+	up       *upArgs
+	logs     *logsArgs
+}
+
+type upArgs struct {
+	nodeCount   uint16
+	clientCount uint16
+	basePort    uint16
+	fresh       bool
+}
+
+func nodeArg(cmd *kingpin.CmdClause) *string {
+	return cmd.Arg("node", "The ID or directory name of the node.").Required().String()
+}
+
+// nodeFlags registers the flags passed on to the nodes started.
+func nodeFlags(cmd *kingpin.CmdClause) func() []string {
+	eventLog := cmd.Flag("eventLog", "Start the nodes recording their state machine events.").Default("true").Bool()
+	sets := cmd.Flag("set", "Override a field of the node configs, as key=value.  May be repeated.").Strings()
+
+	return func() []string {
+		var nodeArgs []string
+		if *eventLog {
+			nodeArgs = append(nodeArgs, "--eventLog")
+		}
+		for _, set := range *sets {
+			nodeArgs = append(nodeArgs, "--set="+set)
+		}
+		return nodeArgs
+	}
+}
+
+func timeoutFlag(cmd *kingpin.CmdClause) *time.Duration {
+	return cmd.Flag("timeout", "How long to wait for a node to stop before killing it.").Default("10s").Duration()
+}
+
+func parseArgs(argsString []string) (*args, error) {
+	app := kingpin.New("cluster", "Run a local mir-sample network, bootstrapping it if need be.")
+	dir := app.Flag("dir", "The bootstrap directory of the network.").Default("bootstrap.d").String()
+	binDir := app.Flag("binDir", "The directory containing the bootstrap and node binaries.  Defaults to the directory of this binary.").String()
+
+	upCmd := app.Command("up", "Start every node which is not running, bootstrapping the network first if the directory is missing or empty.")
+	nodeCount := upCmd.Flag("nodeCount", "The number of nodes to bootstrap.").Default("4").Uint16()
+	clientCount := upCmd.Flag("clientCount", "The number of clients to bootstrap.").Default("1").Uint16()
+	basePort := upCmd.Flag("basePort", "The port of the first node to bootstrap, incremented per node.").Default("5000").Uint16()
+	fresh := upCmd.Flag("fresh", "Remove the directory and bootstrap the network afresh.  Refused while any node is running, or if the directory holds anything bootstrap did not write.").Default("false").Bool()
+	upNodeFlags := nodeFlags(upCmd)
+
+	downCmd := app.Command("down", "Stop every node.")
+	downTimeout := timeoutFlag(downCmd)
+
+	restartCmd := app.Command("restart", "Stop a node if it is running, and start it again.")
+	restartNode := nodeArg(restartCmd)
+	restartTimeout := timeoutFlag(restartCmd)
+	restartNodeFlags := nodeFlags(restartCmd)
+
+	killCmd := app.Command("kill", "Kill a node without letting it stop cleanly, as if it crashed.")
+	killNode := nodeArg(killCmd)
+
+	statusCmd := app.Command("status", "Print whether each node is running, and the status reported by its admin API.")
+	statusTimeout := statusCmd.Flag("timeout", "How long to wait for each node to reply.").Default("2s").Duration()
+
+	logsCmd := app.Command("logs", "Print the log of a node.")
+	logsNode := nodeArg(logsCmd)
+	follow := logsCmd.Flag("follow", "Keep printing the log as the node writes it.").Short('f').Default("false").Bool()
+	lines := logsCmd.Flag("lines", "Print only the last this many lines.  Zero prints the whole log.").Short('n').Default("0").Int()
+
+	command, err := app.Parse(argsString)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &args{
+		command: command,
+		dir:     *dir,
+		binDir:  *binDir,
+	}
+
+	if a.binDir == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, errors.WithMessage(err, "could not determine the directory of this binary, set --binDir")
+		}
+		a.binDir = filepath.Dir(executable)
+	}
+
+	switch command {
+	case upCmd.FullCommand():
+		a.up = &upArgs{
+			nodeCount:   *nodeCount,
+			clientCount: *clientCount,
+			basePort:    *basePort,
+			fresh:       *fresh,
+		}
+		a.nodeArgs = upNodeFlags()
+	case downCmd.FullCommand():
+		a.timeout = *downTimeout
+	case restartCmd.FullCommand():
+		a.node = *restartNode
+		a.timeout = *restartTimeout
+		a.nodeArgs = restartNodeFlags()
+	case killCmd.FullCommand():
+		a.node = *killNode
+	case statusCmd.FullCommand():
+		a.timeout = *statusTimeout
+	case logsCmd.FullCommand():
+		a.node = *logsNode
+		a.logs = &logsArgs{
+			follow: *follow,
+			lines:  *lines,
+		}
+	}
+
+	return a, nil
+}
+
+// startGrace is how long a started node must stay up to be considered
+// started.  A misconfigured node exits well within it.
+const startGrace = 2 * time.Second
+
+func (a *args) run() error {
+	if a.up != nil {
+		return a.up.run(a.dir, a.binDir, a.nodeArgs)
+	}
+
+	c, err := loadCluster(a.dir, a.binDir)
+	if err != nil {
+		return err
+	}
+
+	switch a.command {
+	case "down":
+		for _, n := range c.nodes {
+			if err := n.stop(a.timeout); err != nil {
+				return err
+			}
+			fmt.Printf("Stopped node %d\n", n.id)
+		}
+		return nil
+	case "status":
+		return c.status(os.Stdout, a.timeout)
+	}
+
+	n, err := c.node(a.node)
+	if err != nil {
+		return err
+	}
+
+	switch a.command {
+	case "restart":
+		if err := n.stop(a.timeout); err != nil {
+			return err
+		}
+		cmd, err := c.start(n, a.nodeArgs)
+		if err != nil {
+			return err
+		}
+		if err := awaitStarted([]*node{n}, []*exec.Cmd{cmd}, startGrace); err != nil {
+			return err
+		}
+		fmt.Printf("Restarted node %d as pid %d\n", n.id, cmd.Process.Pid)
+	case "kill":
+		if err := n.kill(); err != nil {
+			return err
+		}
+		fmt.Printf("Killed node %d\n", n.id)
+	case "logs":
+		return a.logs.run(os.Stdout, n)
+	}
+
+	return nil
+}
+
+func (ua *upArgs) run(dir, binDir string, nodeArgs []string) error {
+	if ua.fresh {
+		if c, err := loadCluster(dir, binDir); err == nil {
+			for _, n := range c.nodes {
+				state, pid, err := n.state()
+				if err != nil {
+					return err
+				}
+				if state == running {
+					return errors.Errorf("node %d is running as pid %d, bring the network down before starting afresh", n.id, pid)
+				}
+			}
+		}
+
+		if err := checkRemovable(dir); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return errors.WithMessagef(err, "could not remove '%s'", dir)
+		}
+	}
+
+	if err := ua.bootstrap(dir, binDir); err != nil {
+		return err
+	}
+
+	c, err := loadCluster(dir, binDir)
+	if err != nil {
+		return err
+	}
+
+	var started []*node
+	var cmds []*exec.Cmd
+	for _, n := range c.nodes {
+		state, pid, err := n.state()
+		if err != nil {
+			return err
+		}
+		if state == running {
+			fmt.Printf("Node %d is already running as pid %d\n", n.id, pid)
+			continue
+		}
+
+		cmd, err := c.start(n, nodeArgs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Started node %d as pid %d, logging to %s\n", n.id, cmd.Process.Pid, n.logPath())
+		started = append(started, n)
+		cmds = append(cmds, cmd)
+	}
+
+	if err := awaitStarted(started, cmds, startGrace); err != nil {
+		return err
+	}
+
+	clientConfigs, err := filepath.Glob(filepath.Join(dir, "*", "config", "client-config.yaml"))
+	if err != nil {
+		return err
+	}
+	if len(clientConfigs) > 0 {
+		fmt.Printf("\nTo inject some requests into the network run:\n\n  ./client --clientConfig %s\n\n", clientConfigs[0])
+	}
+
+	return nil
+}
+
+// bootstrapFiles are the files bootstrap writes into the top of its output
+// directory, alongside the directory of each node and client.
+var bootstrapFiles = map[string]bool{
+	"genesis.yaml":        true,
+	"docker-compose.yaml": true,
+}
+
+// checkRemovable checks that dir may be removed to start afresh, as it does
+// not exist, is empty, or holds only what bootstrap writes, so that --fresh
+// pointed at the wrong directory does not remove it.
+func checkRemovable(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "could not read '%s'", dir)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "genesis.yaml")); err != nil {
+		return errors.Errorf("'%s' has no genesis.yaml, so is not a bootstrap directory, refusing to remove it", dir)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if info, err := os.Stat(filepath.Join(dir, entry.Name(), "config")); err == nil && info.IsDir() {
+				continue
+			}
+		} else if bootstrapFiles[entry.Name()] {
+			continue
+		}
+		return errors.Errorf("'%s' contains '%s', which bootstrap did not write, refusing to remove it", dir, entry.Name())
+	}
+
+	return nil
+}
+
+// bootstrap bootstraps the network into dir, unless it already holds one.
+func (ua *upArgs) bootstrap(dir, binDir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithMessagef(err, "could not read '%s'", dir)
+	}
+	if len(entries) > 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.WithMessagef(err, "could not create '%s'", dir)
+	}
+
+	bootstrapBinary, err := binary(binDir, "bootstrap")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Bootstrapping %d nodes and %d clients into %s\n", ua.nodeCount, ua.clientCount, dir)
+	cmd := exec.Command(bootstrapBinary, "init",
+		"--outputDir="+dir,
+		fmt.Sprintf("--nodeCount=%d", ua.nodeCount),
+		fmt.Sprintf("--clientCount=%d", ua.clientCount),
+		fmt.Sprintf("--basePort=%d", ua.basePort),
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.WithMessage(err, "could not bootstrap the network")
+	}
+
+	return nil
+}
+
+func main() {
+	kingpin.Version("0.0.1")
+	args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("Error parsing arguments, %s, try --help", err)
+	}
+
+	if err := args.run(); err != nil {
+		kingpin.Fatalf("Error, %s", err)
+	}
+}
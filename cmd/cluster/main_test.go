@@ -0,0 +1,98 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpFreshRefusesForeignDir(t *testing.T) {
+	// bootstrapped lays out dir as bootstrap would.
+	bootstrapped := func(t *testing.T, dir string) {
+		for _, name := range []string{"node0", "node1", "client0"} {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, name, "config"), 0700))
+		}
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "node0", "run"), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "genesis.yaml"), nil, 0600))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "docker-compose.yaml"), nil, 0600))
+	}
+
+	tests := []struct {
+		name   string
+		layout func(t *testing.T, dir string)
+		err    string
+	}{
+		{
+			name:   "missing",
+			layout: func(t *testing.T, dir string) {},
+		},
+		{
+			name: "empty",
+			layout: func(t *testing.T, dir string) {
+				require.NoError(t, os.Mkdir(dir, 0700))
+			},
+		},
+		{
+			name:   "bootstrapped",
+			layout: bootstrapped,
+		},
+		{
+			name: "no genesis",
+			layout: func(t *testing.T, dir string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0700))
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.go"), nil, 0600))
+			},
+			err: "'%s' has no genesis.yaml, so is not a bootstrap directory, refusing to remove it",
+		},
+		{
+			name: "foreign file",
+			layout: func(t *testing.T, dir string) {
+				bootstrapped(t, dir)
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600))
+			},
+			err: "'%s' contains 'notes.txt', which bootstrap did not write, refusing to remove it",
+		},
+		{
+			name: "foreign directory",
+			layout: func(t *testing.T, dir string) {
+				bootstrapped(t, dir)
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "data"), 0700))
+			},
+			err: "'%s' contains 'data', which bootstrap did not write, refusing to remove it",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "bootstrap.d")
+			tt.layout(t, dir)
+
+			err := checkRemovable(dir)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, fmt.Sprintf(tt.err, dir))
+
+			// up refuses before removing anything.
+			before, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			ua := &upArgs{fresh: true}
+			assert.EqualError(t, ua.run(dir, t.TempDir(), nil), fmt.Sprintf(tt.err, dir))
+			after, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Equal(t, len(before), len(after))
+		})
+	}
+}
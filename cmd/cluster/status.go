@@ -0,0 +1,158 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/pkg/errors"
+)
+
+// nodeState is the process state of a node, and, if it is running, the
+// readiness and status reported by its admin API.
+type nodeState struct {
+	node   *node
+	state  processState
+	pid    int
+	health *sample.Health
+	status *sample.Status
+	err    error
+}
+
+// status prints a table of the nodes, querying the admin API of each running
+// node concurrently.
+func (c *cluster) status(w io.Writer, timeout time.Duration) error {
+	states := make([]*nodeState, len(c.nodes))
+	var wg sync.WaitGroup
+	for i, n := range c.nodes {
+		state, pid, err := n.state()
+		if err != nil {
+			return err
+		}
+
+		ns := &nodeState{node: n, state: state, pid: pid}
+		states[i] = ns
+		if state != running || n.config.AdminAddress == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			address := adminAddress(ns.node)
+			ns.health = &sample.Health{}
+			if ns.err = query(address, "/readyz", timeout, ns.health); ns.err != nil {
+				ns.health = nil
+				return
+			}
+			ns.status = &sample.Status{}
+			if ns.err = query(address, "/status", timeout, ns.status); ns.err != nil {
+				ns.status = nil
+			}
+		}()
+	}
+	wg.Wait()
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NODE\tDIR\tPID\tSTATE\tREADY\tEPOCH\tCOMMITTED\n")
+	for _, ns := range states {
+		pid, ready, epoch, committed := "-", "-", "-", "-"
+		if ns.pid != 0 {
+			pid = fmt.Sprint(ns.pid)
+		}
+		if ns.health != nil {
+			ready = readiness(ns.health)
+		}
+		if ns.status != nil {
+			epoch = fmt.Sprint(ns.status.Epoch)
+			committed = fmt.Sprint(ns.status.LastCommittedSeqNo)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", ns.node.id, ns.node.name(), pid, ns.state, ready, epoch, committed)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, ns := range states {
+		if ns.err != nil {
+			fmt.Fprintf(w, "Node %d: could not query admin API: %s\n", ns.node.id, ns.err)
+		}
+	}
+
+	return nil
+}
+
+// readiness describes the health as yes, or no along with the checks which
+// failed.
+func readiness(health *sample.Health) string {
+	if health.Healthy {
+		return "yes"
+	}
+
+	var failed []string
+	for _, check := range health.Checks {
+		if !check.Healthy {
+			failed = append(failed, check.Name)
+		}
+	}
+	return fmt.Sprintf("no (%s)", strings.Join(failed, ","))
+}
+
+// adminAddress returns the address at which to reach the node's admin API.
+// A node serving it on all interfaces is reached on the loopback interface.
+func adminAddress(n *node) string {
+	host, port, err := net.SplitHostPort(n.config.AdminAddress)
+	if err != nil {
+		return n.config.AdminAddress
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// query gets the path from the admin API and decodes the JSON reply into v.
+// The health endpoints reply with JSON, with status 503, when unhealthy.
+func query(address, path string, timeout time.Duration, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+path, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.Header.Get("Content-Type") != "application/json" {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return errors.Errorf("%s replied %s: %s", path, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.WithMessagef(err, "could not decode reply to %s", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type args struct {
+	command string
+	paths   []string
+	filter  *filter
+	json    bool
+	replay  *replayArgs
+}
+
+func pathsArg(cmd *kingpin.CmdClause) *[]string {
+	return cmd.Arg("path", "Event log files, or node run directories to read every segment of the event log within.").Required().ExistingFilesOrDirs()
+}
+
+// filterFlags registers the flags selecting the events to inspect, which are
+// validated once parsed.
+func filterFlags(cmd *kingpin.CmdClause) func() (*filter, error) {
+	types := cmd.Flag("type", "Only include events of this type, such as step.preprepare, or of any type within it, such as step.  May be repeated.").Strings()
+	nodes := cmd.Flag("node", "Only include events recorded by this node.  May be repeated.").Uint64List()
+	fromSeqNo := cmd.Flag("fromSeqNo", "Only include events concerning this sequence number or later.").Uint64()
+	toSeqNo := cmd.Flag("toSeqNo", "Only include events concerning this sequence number or earlier.").Uint64()
+	since := cmd.Flag("since", "Only include events recorded at or after this RFC3339 time.").String()
+	until := cmd.Flag("until", "Only include events recorded at or before this RFC3339 time.").String()
+
+	return func() (*filter, error) {
+		f := &filter{
+			types:     *types,
+			nodes:     *nodes,
+			fromSeqNo: *fromSeqNo,
+			toSeqNo:   *toSeqNo,
+		}
+
+		if f.toSeqNo > 0 && f.toSeqNo < f.fromSeqNo {
+			return nil, errors.Errorf("toSeqNo %d is before fromSeqNo %d", f.toSeqNo, f.fromSeqNo)
+		}
+
+		var err error
+		if *since != "" {
+			f.since, err = time.Parse(time.RFC3339Nano, *since)
+			if err != nil {
+				return nil, errors.WithMessage(err, "could not parse since")
+			}
+		}
+
+		if *until != "" {
+			f.until, err = time.Parse(time.RFC3339Nano, *until)
+			if err != nil {
+				return nil, errors.WithMessage(err, "could not parse until")
+			}
+		}
+
+		return f, nil
+	}
+}
+
+// optionalUint64 registers the flag as a number whose zero value is
+// meaningful, which is nil once parsed unless the flag was given.
+func optionalUint64(flag *kingpin.FlagClause) func() *uint64 {
+	set := false
+	value := flag.Action(func(*kingpin.ParseContext) error {
+		set = true
+		return nil
+	}).Uint64()
+
+	return func() *uint64 {
+		if !set {
+			return nil
+		}
+		return value
+	}
+}
+
+func parseArgs(argsString []string) (*args, error) {
+	app := kingpin.New("eventlog", "Inspect the state machine events recorded by mir-sample nodes.")
+
+	printCmd := app.Command("print", "Print each event, one per line.").Default()
+	printPaths := pathsArg(printCmd)
+	printFilter := filterFlags(printCmd)
+	printJSON := printCmd.Flag("json", "Print each event as JSON.").Default("false").Bool()
+
+	summaryCmd := app.Command("summary", "Summarize the events recorded by each node, by type, and the timeline of epoch changes.")
+	summaryPaths := pathsArg(summaryCmd)
+	summaryFilter := filterFlags(summaryCmd)
+	summaryJSON := summaryCmd.Flag("json", "Print the summary as JSON.").Default("false").Bool()
+
+	replayCmd := app.Command("replay", "Rebuild the state of a node by replaying the events it recorded into a fresh state machine and application, and print its status as JSON.")
+	replayPaths := pathsArg(replayCmd)
+	replayNode := optionalUint64(replayCmd.Flag("node", "The node whose events to replay, required if the log holds the events of several."))
+	stopAtIndex := optionalUint64(replayCmd.Flag("stopAtIndex", "Stop once the event at this index within the node's log is replayed."))
+	stopAtSeqNo := replayCmd.Flag("stopAtSeqNo", "Stop once the entry at this sequence number is applied.").Uint64()
+	requestStore := replayCmd.Flag("requestStore", "The node's request store, from which to read the data of each request applied.  The node must not be running.  By default requests are applied without their data.").ExistingDir()
+	compare := replayCmd.Flag("compare", "A status reported by the node's admin API, such as saved from its /status endpoint, with which to compare the replayed status.").ExistingFile()
+	full := replayCmd.Flag("full", "Include the complete status of the state machine.").Default("false").Bool()
+	logLevel := replayCmd.Flag("logLevel", "The level at which to log the replayed state machine and application.").Default("warn").String()
+
+	command, err := app.Parse(argsString)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &args{
+		command: command,
+	}
+
+	switch command {
+	case printCmd.FullCommand():
+		a.paths = *printPaths
+		a.json = *printJSON
+		a.filter, err = printFilter()
+	case summaryCmd.FullCommand():
+		a.paths = *summaryPaths
+		a.json = *summaryJSON
+		a.filter, err = summaryFilter()
+	case replayCmd.FullCommand():
+		a.paths = *replayPaths
+		a.replay = &replayArgs{
+			node:         replayNode(),
+			stopAtIndex:  stopAtIndex(),
+			stopAtSeqNo:  *stopAtSeqNo,
+			requestStore: *requestStore,
+			compare:      *compare,
+			full:         *full,
+			logLevel:     *logLevel,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *args) run() error {
+	paths, err := segments(a.paths)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	warn := func(err error) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+	}
+
+	switch {
+	case a.replay != nil:
+		return a.replay.run(out, paths, warn)
+	case a.command == "summary":
+		s := newSummary()
+		err := readEvents(paths, warn, func(event *recordedEvent) error {
+			if !a.filter.matches(event) {
+				return nil
+			}
+			return s.add(event)
+		})
+		if err != nil {
+			return err
+		}
+
+		if a.json {
+			return s.printJSON(out)
+		}
+		return s.print(out)
+	}
+
+	p := &printer{w: out, json: a.json}
+	return readEvents(paths, warn, func(event *recordedEvent) error {
+		if !a.filter.matches(event) {
+			return nil
+		}
+		return p.print(event)
+	})
+}
+
+func main() {
+	kingpin.Version("0.0.1")
+	args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("Error parsing arguments, %s, try --help", err)
+	}
+
+	if err := args.run(); err != nil {
+		kingpin.Fatalf("Error reading event log, %s", err)
+	}
+}
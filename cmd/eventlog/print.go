@@ -0,0 +1,141 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// timeFormat prints event times to the millisecond, at which the node records
+// them, in a fixed width.
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// jsonEvent is an event as printed with --json, one per line.
+type jsonEvent struct {
+	Index  uint64          `json:"index"`
+	NodeID uint64          `json:"node_id"`
+	Time   time.Time       `json:"time"`
+	Type   string          `json:"type"`
+	SeqNo  *uint64         `json:"seq_no,omitempty"`
+	Epoch  *uint64         `json:"epoch,omitempty"`
+	Event  json.RawMessage `json:"event"`
+}
+
+// printer prints each event it is given, as a line of text or of JSON.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func (p *printer) print(event *recordedEvent) error {
+	if p.json {
+		return p.printJSON(event)
+	}
+
+	fields := eventFields(event.StateEvent)
+	line := fmt.Sprintf("%s  node=%d  #%d  %s", eventTime(event.Event).UTC().Format(timeFormat), event.NodeId, event.Index, eventType(event.StateEvent))
+	if len(fields) > 0 {
+		line += "  " + strings.Join(fields, " ")
+	}
+
+	_, err := fmt.Fprintln(p.w, line)
+	return err
+}
+
+func (p *printer) printJSON(event *recordedEvent) error {
+	raw, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(event.StateEvent)
+	if err != nil {
+		return err
+	}
+
+	je := &jsonEvent{
+		Index:  event.Index,
+		NodeID: event.NodeId,
+		Time:   eventTime(event.Event).UTC(),
+		Type:   eventType(event.StateEvent),
+		Event:  raw,
+	}
+	if seqNo, ok := eventSeqNo(event.StateEvent); ok {
+		je.SeqNo = &seqNo
+	}
+	if epoch, ok := eventEpoch(event.StateEvent); ok {
+		je.Epoch = &epoch
+	}
+
+	return json.NewEncoder(p.w).Encode(je)
+}
+
+// eventFields returns the fields of the event most useful when reading the
+// log, as key=value pairs.
+func eventFields(event *state.Event) []string {
+	var fields []string
+	if step := event.GetStep(); step != nil {
+		fields = append(fields, fmt.Sprintf("source=%d", step.Source))
+	}
+	if seqNo, ok := eventSeqNo(event); ok {
+		fields = append(fields, fmt.Sprintf("seq_no=%d", seqNo))
+	}
+	if epoch, ok := eventEpoch(event); ok {
+		fields = append(fields, fmt.Sprintf("epoch=%d", epoch))
+	}
+	if leaders := eventLeaders(event); leaders != nil {
+		fields = append(fields, fmt.Sprintf("leaders=%s", joinIDs(leaders)))
+	}
+	if ack := eventRequest(event); ack != nil {
+		fields = append(fields, fmt.Sprintf("client_id=%d req_no=%d", ack.ClientId, ack.ReqNo))
+	}
+	return fields
+}
+
+// eventRequest returns the request the event concerns, if any.
+func eventRequest(event *state.Event) *pb.RequestAck {
+	switch t := event.Type.(type) {
+	case *state.Event_RequestPersisted:
+		return t.RequestPersisted.RequestAck
+	case *state.Event_Step:
+		switch m := t.Step.GetMsg().GetType().(type) {
+		case *pb.Msg_FetchRequest:
+			return m.FetchRequest
+		case *pb.Msg_ForwardRequest:
+			return m.ForwardRequest.GetRequestAck()
+		case *pb.Msg_RequestAck:
+			return m.RequestAck
+		}
+	}
+	return nil
+}
+
+// eventLeaders returns the leaders of the epoch the event begins, if any.
+func eventLeaders(event *state.Event) []uint64 {
+	switch t := event.Type.(type) {
+	case *state.Event_LoadPersistedEntry:
+		if nEntry := t.LoadPersistedEntry.GetEntry().GetNEntry(); nEntry != nil {
+			return nEntry.GetEpochConfig().GetLeaders()
+		}
+	case *state.Event_Step:
+		if newEpoch := t.Step.GetMsg().GetNewEpoch(); newEpoch != nil {
+			return newEpoch.GetNewConfig().GetConfig().GetLeaders()
+		}
+	}
+	return nil
+}
+
+func joinIDs(ids []uint64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprint(id)
+	}
+	return strings.Join(s, ",")
+}
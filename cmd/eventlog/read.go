@@ -0,0 +1,319 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperledger-labs/mirbft/pkg/eventlog"
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/recording"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// segments expands each path into the event log files to read, in order.  A
+// directory, such as a node's run directory, is expanded to every segment of
+// the event log within it.
+func segments(paths []string) ([]string, error) {
+	var result []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			result = append(result, path)
+			continue
+		}
+
+		dirSegments, err := sample.EventLogSegments(filepath.Join(path, sample.EventLogFile))
+		if err != nil {
+			return nil, err
+		}
+		if len(dirSegments) == 0 {
+			return nil, errors.Errorf("no event log found in directory %s", path)
+		}
+		result = append(result, dirSegments...)
+	}
+
+	return result, nil
+}
+
+// recordedEvent is an event read from the log, along with its position.
+type recordedEvent struct {
+	*recording.Event
+
+	// Index counts the events read before this one from the same node.
+	Index   uint64
+	Segment string
+}
+
+// readEvents reads every event from the segments in order, passing each to
+// forEach.  A segment left incomplete, as its node did not stop cleanly, is
+// read as far as possible, and reported to warn.
+func readEvents(paths []string, warn func(error), forEach func(*recordedEvent) error) error {
+	indices := map[uint64]uint64{}
+	for _, path := range paths {
+		err := readSegment(path, func(event *recording.Event) error {
+			index := indices[event.NodeId]
+			indices[event.NodeId]++
+			return forEach(&recordedEvent{
+				Event:   event,
+				Index:   index,
+				Segment: path,
+			})
+		})
+		if err == errStop {
+			return nil
+		}
+		if err != nil {
+			if _, ok := err.(*segmentError); !ok {
+				return err
+			}
+			warn(err)
+		}
+	}
+
+	return nil
+}
+
+// errStop may be returned by forEach to stop reading.
+var errStop = errors.Errorf("stop reading")
+
+// segmentError describes a segment which could not be read in full.
+type segmentError struct {
+	path string
+	err  error
+}
+
+func (se *segmentError) Error() string {
+	return fmt.Sprintf("could not read all of %s, %s", se.path, se.err)
+}
+
+func readSegment(path string, forEach func(*recording.Event) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := eventlog.NewReader(f)
+	if err != nil {
+		return &segmentError{path: path, err: err}
+	}
+
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &segmentError{path: path, err: err}
+		}
+
+		if err := forEach(event); err != nil {
+			return err
+		}
+	}
+}
+
+// filter selects the events to inspect, each criterion matching every event
+// if unset.
+type filter struct {
+	types              []string
+	nodes              []uint64
+	fromSeqNo, toSeqNo uint64
+	since, until       time.Time
+}
+
+func (f *filter) matches(event *recordedEvent) bool {
+	if len(f.types) > 0 {
+		matched := false
+		name := eventType(event.StateEvent)
+		for _, t := range f.types {
+			if name == t || (len(name) > len(t) && name[:len(t)+1] == t+".") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.nodes) > 0 {
+		matched := false
+		for _, node := range f.nodes {
+			if event.NodeId == node {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if f.fromSeqNo > 0 || f.toSeqNo > 0 {
+		seqNo, ok := eventSeqNo(event.StateEvent)
+		if !ok || seqNo < f.fromSeqNo || (f.toSeqNo > 0 && seqNo > f.toSeqNo) {
+			return false
+		}
+	}
+
+	t := eventTime(event.Event)
+	if !f.since.IsZero() && t.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && t.After(f.until) {
+		return false
+	}
+
+	return true
+}
+
+// eventTime returns when the event was recorded, which the node stamps in
+// Unix milliseconds.
+func eventTime(event *recording.Event) time.Time {
+	return time.Unix(0, event.Time*int64(time.Millisecond))
+}
+
+// eventType names the type of the event by its field within the event
+// protobuf, qualified by the type of the message or entry it carries, for
+// instance step.preprepare or load_persisted_entry.q_entry.
+func eventType(event *state.Event) string {
+	name := oneofName(event.ProtoReflect())
+	switch t := event.Type.(type) {
+	case *state.Event_Step:
+		if t.Step.Msg != nil {
+			name += "." + oneofName(t.Step.Msg.ProtoReflect())
+		}
+	case *state.Event_LoadPersistedEntry:
+		if t.LoadPersistedEntry.Entry != nil {
+			name += "." + oneofName(t.LoadPersistedEntry.Entry.ProtoReflect())
+		}
+	}
+	return name
+}
+
+// oneofName returns the name of the field set within the type oneof of the
+// message.
+func oneofName(m protoreflect.Message) string {
+	oneof := m.Descriptor().Oneofs().ByName("type")
+	if oneof == nil {
+		return "unknown"
+	}
+
+	field := m.WhichOneof(oneof)
+	if field == nil {
+		return "unknown"
+	}
+
+	return string(field.Name())
+}
+
+// eventSeqNo returns the sequence number the event concerns, if any.
+func eventSeqNo(event *state.Event) (uint64, bool) {
+	switch t := event.Type.(type) {
+	case *state.Event_LoadPersistedEntry:
+		switch e := t.LoadPersistedEntry.Entry.GetType().(type) {
+		case *pb.Persistent_QEntry:
+			return e.QEntry.SeqNo, true
+		case *pb.Persistent_PEntry:
+			return e.PEntry.SeqNo, true
+		case *pb.Persistent_CEntry:
+			return e.CEntry.SeqNo, true
+		case *pb.Persistent_NEntry:
+			return e.NEntry.SeqNo, true
+		case *pb.Persistent_TEntry:
+			return e.TEntry.SeqNo, true
+		}
+	case *state.Event_CheckpointResult:
+		return t.CheckpointResult.SeqNo, true
+	case *state.Event_StateTransferComplete:
+		return t.StateTransferComplete.SeqNo, true
+	case *state.Event_StateTransferFailed:
+		return t.StateTransferFailed.SeqNo, true
+	case *state.Event_HashResult:
+		switch o := t.HashResult.GetOrigin().GetType().(type) {
+		case *state.HashOrigin_Batch_:
+			return o.Batch.SeqNo, true
+		case *state.HashOrigin_VerifyBatch_:
+			return o.VerifyBatch.SeqNo, true
+		}
+	case *state.Event_Step:
+		switch m := t.Step.GetMsg().GetType().(type) {
+		case *pb.Msg_Preprepare:
+			return m.Preprepare.SeqNo, true
+		case *pb.Msg_Prepare:
+			return m.Prepare.SeqNo, true
+		case *pb.Msg_Commit:
+			return m.Commit.SeqNo, true
+		case *pb.Msg_Checkpoint:
+			return m.Checkpoint.SeqNo, true
+		case *pb.Msg_FetchBatch:
+			return m.FetchBatch.SeqNo, true
+		case *pb.Msg_ForwardBatch:
+			return m.ForwardBatch.SeqNo, true
+		}
+	}
+
+	return 0, false
+}
+
+// eventEpoch returns the epoch the event concerns, if any.
+func eventEpoch(event *state.Event) (uint64, bool) {
+	switch t := event.Type.(type) {
+	case *state.Event_LoadPersistedEntry:
+		switch e := t.LoadPersistedEntry.Entry.GetType().(type) {
+		case *pb.Persistent_NEntry:
+			return e.NEntry.GetEpochConfig().GetNumber(), true
+		case *pb.Persistent_ECEntry:
+			return e.ECEntry.EpochNumber, true
+		case *pb.Persistent_Suspect:
+			return e.Suspect.Epoch, true
+		}
+	case *state.Event_HashResult:
+		switch o := t.HashResult.GetOrigin().GetType().(type) {
+		case *state.HashOrigin_Batch_:
+			return o.Batch.Epoch, true
+		case *state.HashOrigin_EpochChange_:
+			return o.EpochChange.GetEpochChange().GetNewEpoch(), true
+		}
+	case *state.Event_Step:
+		switch m := t.Step.GetMsg().GetType().(type) {
+		case *pb.Msg_Preprepare:
+			return m.Preprepare.Epoch, true
+		case *pb.Msg_Prepare:
+			return m.Prepare.Epoch, true
+		case *pb.Msg_Commit:
+			return m.Commit.Epoch, true
+		case *pb.Msg_Suspect:
+			return m.Suspect.Epoch, true
+		case *pb.Msg_EpochChange:
+			return m.EpochChange.NewEpoch, true
+		case *pb.Msg_EpochChangeAck:
+			return m.EpochChangeAck.GetEpochChange().GetNewEpoch(), true
+		case *pb.Msg_NewEpoch:
+			return m.NewEpoch.GetNewConfig().GetConfig().GetNumber(), true
+		case *pb.Msg_NewEpochEcho:
+			return m.NewEpochEcho.GetConfig().GetNumber(), true
+		case *pb.Msg_NewEpochReady:
+			return m.NewEpochReady.GetConfig().GetNumber(), true
+		}
+	}
+
+	return 0, false
+}
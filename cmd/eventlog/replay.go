@@ -0,0 +1,166 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hyperledger-labs/mirbft/pkg/reqstore"
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/logging"
+	"github.com/pkg/errors"
+)
+
+type replayArgs struct {
+	// node is the node whose events to replay, or if nil, the node which
+	// recorded the first event.
+	node *uint64
+
+	// stopAtIndex, if not nil, and stopAtSeqNo, if not zero, end the replay
+	// early.
+	stopAtIndex *uint64
+	stopAtSeqNo uint64
+
+	requestStore string
+	compare      string
+	full         bool
+	logLevel     string
+}
+
+// run replays the events of the node, and prints the status it reaches,
+// comparing it with the status the node reported if given.  Should the
+// replay diverge from the node, the status is printed as of the last event
+// replayed.
+func (ra *replayArgs) run(w io.Writer, paths []string, warn func(error)) error {
+	loggers, err := logging.New(&config.Logging{Level: ra.logLevel})
+	if err != nil {
+		return err
+	}
+	defer loggers.Close()
+
+	// Left a nil interface unless a store is opened, as the application
+	// only reads the data of requests from a store which is not nil.
+	var reqStore sample.RequestStore
+	if ra.requestStore != "" {
+		store, err := reqstore.Open(ra.requestStore)
+		if err != nil {
+			return errors.WithMessage(err, "could not open request store")
+		}
+		defer store.Close()
+		reqStore = store
+	}
+
+	replay := sample.NewReplay(loggers, reqStore)
+
+	node := ra.node
+	var last *recordedEvent
+	var replayErr error
+	err = readEvents(paths, warn, func(event *recordedEvent) error {
+		if node == nil {
+			node = &event.NodeId
+		}
+		if event.NodeId != *node {
+			if ra.node != nil {
+				return nil
+			}
+			return errors.Errorf("the log holds the events of nodes %d and %d, choose one with --node", *node, event.NodeId)
+		}
+
+		if err := replay.Apply(event.StateEvent); err != nil {
+			replayErr = errors.WithMessagef(err, "could not replay event %d, %s", event.Index, eventType(event.StateEvent))
+			return errStop
+		}
+		last = event
+
+		if ra.stopAtIndex != nil && event.Index >= *ra.stopAtIndex {
+			return errStop
+		}
+		if ra.stopAtSeqNo > 0 && replay.LastApplied() >= ra.stopAtSeqNo {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if last == nil {
+		if replayErr != nil {
+			return replayErr
+		}
+		return errors.Errorf("the log holds no events to replay")
+	}
+
+	fmt.Fprintf(os.Stderr, "Replayed %d events of node %d, through event %d, %s, recorded at %s, having applied seq_no %d\n",
+		replay.Events(), *node, last.Index, eventType(last.StateEvent), eventTime(last.Event).UTC().Format(timeFormat), replay.LastApplied())
+
+	if replayErr == nil {
+		if ra.stopAtIndex != nil && last.Index < *ra.stopAtIndex {
+			warn(errors.Errorf("the log ended before event %d", *ra.stopAtIndex))
+		}
+		if ra.stopAtSeqNo > 0 && replay.LastApplied() < ra.stopAtSeqNo {
+			warn(errors.Errorf("the log ended before seq_no %d was applied", ra.stopAtSeqNo))
+		}
+	}
+
+	status, err := replay.Status(ra.full)
+	if err != nil {
+		if replayErr != nil {
+			return replayErr
+		}
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(status); err != nil {
+		return err
+	}
+
+	if replayErr != nil {
+		return replayErr
+	}
+
+	if ra.compare == "" {
+		return nil
+	}
+
+	reported, err := readStatus(ra.compare)
+	if err != nil {
+		return err
+	}
+
+	diffs := sample.CompareStatus(status, reported)
+	for _, diff := range diffs {
+		fmt.Fprintf(os.Stderr, "Differs from reported status, %s\n", diff)
+	}
+	if len(diffs) > 0 {
+		return errors.Errorf("the replayed status differs from the reported status in %d fields", len(diffs))
+	}
+
+	fmt.Fprintf(os.Stderr, "Matches the reported status\n")
+	return nil
+}
+
+func readStatus(path string) (*sample.Status, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	status := &sample.Status{}
+	if err := json.NewDecoder(f).Decode(status); err != nil {
+		return nil, errors.WithMessage(err, "could not decode reported status")
+	}
+
+	return status, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger-labs/mirbft/pkg/testengine"
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordNetwork writes the event log of a small network, run by Mir's test
+// engine until its client's requests commit, to a file within dir.
+func recordNetwork(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "recording.eventlog")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+
+	recorder := (&testengine.Spec{
+		NodeCount:     1,
+		ClientCount:   1,
+		ReqsPerClient: 3,
+		TweakRecorder: func(r *testengine.Recorder) {
+			r.LogOutput = ioutil.Discard
+		},
+	}).Recorder()
+	recording, err := recorder.Recording(gw)
+	require.NoError(t, err)
+	_, err = recording.DrainClients(10000)
+	require.NoError(t, err)
+
+	return path
+}
+
+func TestReplay(t *testing.T) {
+	path := recordNetwork(t, t.TempDir())
+
+	tests := []struct {
+		name        string
+		args        []string
+		lastApplied uint64
+		err         string
+	}{
+		{
+			name:        "without request store",
+			args:        []string{"replay", "--stopAtSeqNo=1", path},
+			lastApplied: 1,
+		},
+		{
+			name: "other node",
+			args: []string{"replay", "--node=1", path},
+			err:  "the log holds no events to replay",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := parseArgs(tt.args)
+			require.NoError(t, err)
+			require.NotNil(t, a.replay)
+
+			paths, err := segments(a.paths)
+			require.NoError(t, err)
+
+			var out bytes.Buffer
+			err = a.replay.run(&out, paths, func(err error) { t.Log(err) })
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			status := &sample.Status{}
+			require.NoError(t, json.Unmarshal(out.Bytes(), status))
+			assert.Equal(t, uint64(0), status.NodeID)
+			assert.Equal(t, tt.lastApplied, status.LastCommittedSeqNo)
+		})
+	}
+}
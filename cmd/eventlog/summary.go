@@ -0,0 +1,232 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+)
+
+// nodeSummary describes the events recorded by a single node.
+type nodeSummary struct {
+	NodeID   uint64            `json:"node_id"`
+	Events   uint64            `json:"events"`
+	First    time.Time         `json:"first"`
+	Last     time.Time         `json:"last"`
+	Segments []string          `json:"segments"`
+	Types    map[string]uint64 `json:"types"`
+}
+
+// epochEvent is a step towards an epoch change, as seen by one node.
+type epochEvent struct {
+	Time   time.Time `json:"time"`
+	NodeID uint64    `json:"node_id"`
+	Index  uint64    `json:"index"`
+	Epoch  uint64    `json:"epoch"`
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+type epochEventKey struct {
+	nodeID uint64
+	epoch  uint64
+	event  string
+}
+
+// summary accumulates the events read into counts per node and type, and a
+// timeline of epoch changes.
+type summary struct {
+	nodes    map[uint64]*nodeSummary
+	timeline []*epochEvent
+	seen     map[epochEventKey]struct{}
+}
+
+func newSummary() *summary {
+	return &summary{
+		nodes: map[uint64]*nodeSummary{},
+		seen:  map[epochEventKey]struct{}{},
+	}
+}
+
+func (s *summary) add(event *recordedEvent) error {
+	t := eventTime(event.Event).UTC()
+	name := eventType(event.StateEvent)
+
+	ns, ok := s.nodes[event.NodeId]
+	if !ok {
+		ns = &nodeSummary{
+			NodeID: event.NodeId,
+			First:  t,
+			Types:  map[string]uint64{},
+		}
+		s.nodes[event.NodeId] = ns
+	}
+	ns.Events++
+	ns.Last = t
+	ns.Types[name]++
+	if len(ns.Segments) == 0 || ns.Segments[len(ns.Segments)-1] != event.Segment {
+		ns.Segments = append(ns.Segments, event.Segment)
+	}
+
+	s.addEpochEvent(event, t)
+	return nil
+}
+
+// addEpochEvent records the first time the node saw each step of an epoch
+// change: a suspicion of the current epoch, an epoch change for the next, the
+// new epoch and its leaders, and the first batch proposed within it.
+func (s *summary) addEpochEvent(event *recordedEvent, t time.Time) {
+	var epoch uint64
+	var step, detail string
+
+	switch et := event.StateEvent.Type.(type) {
+	case *state.Event_LoadPersistedEntry:
+		switch e := et.LoadPersistedEntry.GetEntry().GetType().(type) {
+		case *pb.Persistent_ECEntry:
+			epoch, step = e.ECEntry.EpochNumber, "persisted epoch change"
+		case *pb.Persistent_NEntry:
+			epoch, step = e.NEntry.GetEpochConfig().GetNumber(), "persisted new epoch"
+			detail = "leaders=" + joinIDs(e.NEntry.GetEpochConfig().GetLeaders())
+		default:
+			return
+		}
+	case *state.Event_Step:
+		source := fmt.Sprintf("source=%d", et.Step.Source)
+		switch m := et.Step.GetMsg().GetType().(type) {
+		case *pb.Msg_Suspect:
+			epoch, step, detail = m.Suspect.Epoch, "suspect", source
+		case *pb.Msg_EpochChange:
+			epoch, step, detail = m.EpochChange.NewEpoch, "epoch change", source
+		case *pb.Msg_NewEpoch:
+			config := m.NewEpoch.GetNewConfig().GetConfig()
+			epoch, step = config.GetNumber(), "new epoch"
+			detail = fmt.Sprintf("%s leaders=%s", source, joinIDs(config.GetLeaders()))
+		case *pb.Msg_Preprepare:
+			epoch, step = m.Preprepare.Epoch, "first preprepare"
+			detail = fmt.Sprintf("%s seq_no=%d", source, m.Preprepare.SeqNo)
+		default:
+			return
+		}
+	default:
+		return
+	}
+
+	key := epochEventKey{nodeID: event.NodeId, epoch: epoch, event: step}
+	if _, ok := s.seen[key]; ok {
+		return
+	}
+	s.seen[key] = struct{}{}
+
+	s.timeline = append(s.timeline, &epochEvent{
+		Time:   t,
+		NodeID: event.NodeId,
+		Index:  event.Index,
+		Epoch:  epoch,
+		Event:  step,
+		Detail: detail,
+	})
+}
+
+func (s *summary) sortedNodes() []*nodeSummary {
+	nodes := make([]*nodeSummary, 0, len(s.nodes))
+	for _, ns := range s.nodes {
+		nodes = append(nodes, ns)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+	return nodes
+}
+
+// sortedTimeline orders the epoch change steps by epoch, and then by when
+// they were recorded.
+func (s *summary) sortedTimeline() []*epochEvent {
+	timeline := append([]*epochEvent(nil), s.timeline...)
+	sort.SliceStable(timeline, func(i, j int) bool {
+		if timeline[i].Epoch != timeline[j].Epoch {
+			return timeline[i].Epoch < timeline[j].Epoch
+		}
+		return timeline[i].Time.Before(timeline[j].Time)
+	})
+	return timeline
+}
+
+func (s *summary) printJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Nodes    []*nodeSummary `json:"nodes"`
+		Timeline []*epochEvent  `json:"epoch_timeline"`
+	}{
+		Nodes:    s.sortedNodes(),
+		Timeline: s.sortedTimeline(),
+	})
+}
+
+func (s *summary) print(w io.Writer) error {
+	nodes := s.sortedNodes()
+	if len(nodes) == 0 {
+		_, err := fmt.Fprintln(w, "No events matched.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NODE\tEVENTS\tFIRST\tLAST\tSEGMENTS\n")
+	for _, ns := range nodes {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%d\n", ns.NodeID, ns.Events, ns.First.Format(timeFormat), ns.Last.Format(timeFormat), len(ns.Segments))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// The count of each type of event, as recorded by each node.
+	types := map[string]struct{}{}
+	for _, ns := range nodes {
+		for name := range ns.Types {
+			types[name] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(tw, "TYPE")
+	for _, ns := range nodes {
+		fmt.Fprintf(tw, "\tNODE %d", ns.NodeID)
+	}
+	fmt.Fprintln(tw)
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s", name)
+		for _, ns := range nodes {
+			fmt.Fprintf(tw, "\t%d", ns.Types[name])
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	timeline := s.sortedTimeline()
+	if len(timeline) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(tw, "EPOCH\tTIME\tNODE\tINDEX\tEVENT\tDETAIL\n")
+	for _, ee := range timeline {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%s\n", ee.Epoch, ee.Time.Format(timeFormat), ee.NodeID, ee.Index, ee.Event, ee.Detail)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,164 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// serveAdmin serves the admin API at the admin_address of the node config.
+// GET /status reports the node's status as JSON, including the complete
+// status of the state machine if the full query parameter is true.  GET
+// /healthz and /readyz report the node's liveness and readiness as JSON, with
+// status 200 if healthy and 503 otherwise.  POST /backup quiesces the node
+// while it archives the run directory, then responds with the archive.  The
+// API has no authentication, so should be served only on localhost.
+func serveAdmin(server *sample.Server, runDir string, logger *zap.SugaredLogger) error {
+	address := server.Config().AdminAddress
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.WithMessage(err, "could not listen for admin API")
+	}
+
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() {
+		logger.Warnw("Serving the admin API, which has no authentication, beyond localhost, so anyone able to connect may back up the node's state", "admin_address", address)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		full := false
+		if value := r.URL.Query().Get("full"); value != "" {
+			var err error
+			full, err = strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "full must be true or false", http.StatusBadRequest)
+				return
+			}
+		}
+
+		status, err := server.Status(full)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status, logger)
+	})
+	mux.HandleFunc("/backup", backupHandler(server, runDir, logger))
+	mux.HandleFunc("/healthz", healthHandler(server.Liveness, logger))
+	mux.HandleFunc("/readyz", healthHandler(server.Readiness, logger))
+
+	go func() {
+		err := http.Serve(listener, mux)
+		logger.Errorw("Stopped serving admin API", "error", err)
+	}()
+
+	return nil
+}
+
+func healthHandler(check func() *sample.Health, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "only GET and HEAD are supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		health := check()
+		code := http.StatusOK
+		if !health.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, health, logger)
+	}
+}
+
+func backupHandler(server *sample.Server, runDir string, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		nodeConfig := server.Config()
+		if runDir == "" || nodeConfig.Storage.Backend == "memory" {
+			http.Error(w, "the node keeps no state on disk to back up", http.StatusConflict)
+			return
+		}
+
+		// The archive is written to a temporary file, rather than straight
+		// to the response, so that the node is not held quiesced by a slow
+		// client.
+		f, err := ioutil.TempFile("", "mirbft-backup-*.tar.gz")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		nodeID := nodeConfig.ID
+		genesisHash := hex.EncodeToString(nodeConfig.Genesis().Hash())
+		var manifest *sample.BackupManifest
+		var paused time.Duration
+		err = server.Quiesce(func() error {
+			start := time.Now()
+			defer func() { paused = time.Since(start) }()
+
+			var err error
+			manifest, err = sample.WriteBackup(f, runDir, nodeID, genesisHash)
+			return err
+		})
+		if err != nil {
+			logger.Errorw("Could not back up run directory", "error", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		logger.Infow("Backed up run directory", "files", len(manifest.Files), "paused", paused)
+
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		if _, err := io.Copy(w, f); err != nil {
+			logger.Warnw("Could not write backup response", "error", err)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}, logger *zap.SugaredLogger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		logger.Warnw("Could not write admin API response", "error", err)
+	}
+}
@@ -7,32 +7,54 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 
 	sample "github.com/jyellick/mirbft-sample"
 	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/logging"
+	"github.com/jyellick/mirbft-sample/tracing"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
 )
 
 type args struct {
-	nodeConfig *os.File
-	runDir     string
-	eventLog   bool
-	serial     bool
+	nodeConfig  string
+	sets        []string
+	printConfig bool
+	runDir      string
+	eventLog    bool
+	serial      bool
+	metricsAddr string
+
+	passphraseOnce sync.Once
+	passphrase     []byte
+	passphraseErr  error
 }
 
 func parseArgs(argsString []string) (*args, error) {
 	app := kingpin.New("mirbft-sample", "A small sample application implemented using the mirbft library.")
-	nodeConfig := app.Flag("nodeConfig", "The YAML file containing this node's config (as generated via bootstrap).").Required().File()
+	nodeConfig := app.Flag("nodeConfig", "The YAML file containing this node's config (as generated via bootstrap).").Required().ExistingFile()
+	sets := app.Flag("set", "Override a config field, as key=value, e.g. mir_runtime.batch_size=50 (may be repeated).  Fields may also be overridden by environment variables, e.g. "+config.NodeEnvPrefix+"MIR_RUNTIME_BATCH_SIZE=50.").Strings()
+	printConfig := app.Flag("printConfig", "Print the effective config, with secrets redacted, and exit.").Default("false").Bool()
 	runDir := app.Flag("runDir", "A path to a location to write the WAL, RequestStore, and EventLog.").ExistingDir()
 	eventLog := app.Flag("eventLog", "Whether the node should record a state machine event log").Default("false").Bool()
 	serial := app.Flag("serial", "Causes the node to process actions in series rather than in parallel.").Default("false").Bool()
+	metricsAddr := app.Flag("metricsAddress", "The address, as host:port, on which to serve Prometheus metrics at /metrics.  Metrics are not served if unset.").String()
 
 	_, err := app.Parse(argsString)
 	if err != nil {
@@ -40,45 +62,163 @@ func parseArgs(argsString []string) (*args, error) {
 	}
 
 	return &args{
-		nodeConfig: *nodeConfig,
-		runDir:     *runDir,
-		eventLog:   *eventLog,
-		serial:     *serial,
+		nodeConfig:  *nodeConfig,
+		sets:        *sets,
+		printConfig: *printConfig,
+		runDir:      *runDir,
+		eventLog:    *eventLog,
+		serial:      *serial,
+		metricsAddr: *metricsAddr,
 	}, nil
 
 }
 
-func handleSignals(stop func()) {
+func handleSignals(logger *zap.SugaredLogger, reload, stop func()) {
 	sigC := make(chan os.Signal, 1)
 
-	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for sig := range sigC {
+		if sig == syscall.SIGHUP {
+			reload()
+			continue
+		}
 
-	sig := <-sigC
-	fmt.Printf("Caught signal, exiting: %v\n", sig)
-	stop()
+		logger.Infow("Caught signal, exiting", "signal", sig)
+		stop()
+		return
+	}
 }
 
-func (a *args) initializeServer() (*sample.Server, error) {
-	nodeConfig, err := config.LoadNodeConfig(a.nodeConfig)
+func (a *args) loadConfig() (*config.NodeConfig, error) {
+	f, err := os.Open(a.nodeConfig)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not open node config")
+	}
+	defer f.Close()
+
+	nodeConfig, err := config.LoadNodeConfig(
+		f,
+		config.WithEnv(config.NodeEnvPrefix),
+		config.WithSets(a.sets),
+		config.WithBaseDir(filepath.Dir(a.nodeConfig)),
+		config.WithPassphrase(a.readPassphrase),
+	)
 	if err != nil {
 		return nil, errors.WithMessage(err, "could not parse node config")
 	}
 
-	walDir := filepath.Join(a.runDir, "WAL")
-	reqStoreDir := filepath.Join(a.runDir, "reqStore")
+	return nodeConfig, nil
+}
+
+// readPassphrase reads the key passphrase only once, so that reloading the
+// config does not prompt for it again.
+func (a *args) readPassphrase() ([]byte, error) {
+	a.passphraseOnce.Do(func() {
+		a.passphrase, a.passphraseErr = config.ReadPassphrase()
+	})
+	return a.passphrase, a.passphraseErr
+}
+
+// reloadConfig re-reads the node config file, and applies it to the server.
+func (a *args) reloadConfig(server *sample.Server, logger *zap.SugaredLogger) {
+	nodeConfig, err := a.loadConfig()
+	if err != nil {
+		logger.Errorw("Could not reload config", "error", err)
+		return
+	}
+
+	if err := server.Reload(nodeConfig); err != nil {
+		logger.Errorw("Could not reload config", "error", err)
+	}
+}
+
+// checkRunDir records the genesis hash in the run directory when the node
+// first starts, and thereafter refuses to start from a run directory which was
+// initialized under a different genesis, such as before the network was
+// modified via bootstrap.
+func (a *args) checkRunDir(nodeConfig *config.NodeConfig) error {
+	path := filepath.Join(a.runDir, sample.GenesisHashFile)
+	genesisHash := hex.EncodeToString(nodeConfig.Genesis().Hash())
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ioutil.WriteFile(path, []byte(genesisHash+"\n"), 0600)
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not read genesis hash of run directory")
+	}
+
+	if recorded := strings.TrimSpace(string(data)); recorded != genesisHash {
+		return errors.Errorf("the run directory was initialized with genesis %s, but the config's genesis is %s, if the network was modified via bootstrap, empty the run directory", recorded, genesisHash)
+	}
+
+	return nil
+}
+
+// initializeServer opens the node's storage and creates the server using it.
+// The storage must be closed once the server has stopped.
+func (a *args) initializeServer(nodeConfig *config.NodeConfig, loggers *logging.Loggers) (*sample.Server, *sample.Storage, error) {
 	var eventLogPath string
 	if a.eventLog {
-		eventLogPath = filepath.Join(a.runDir, "eventlog.gz")
+		eventLogPath = filepath.Join(a.runDir, sample.EventLogFile)
+	}
+
+	storage, err := sample.OpenStorage(nodeConfig.Storage, a.runDir)
+	if err != nil {
+		return nil, nil, diagnoseStorage(err, a.runDir)
+	}
+
+	var snapshotDir string
+	if nodeConfig.Storage.Backend != "memory" && !nodeConfig.Snapshots.Disabled {
+		snapshotDir = filepath.Join(a.runDir, sample.SnapshotDir)
 	}
 
 	return &sample.Server{
-		Logger:           zap.NewExample().Sugar(),
-		NodeConfig:       nodeConfig,
-		Serial:           a.serial,
-		EventLogPath:     eventLogPath,
-		WALPath:          walDir,
-		RequestStorePath: reqStoreDir,
-	}, nil
+		Loggers:      loggers,
+		NodeConfig:   nodeConfig,
+		Serial:       a.serial,
+		EventLogPath: eventLogPath,
+		WAL:          storage.WAL,
+		RequestStore: storage.RequestStore,
+		SnapshotDir:  snapshotDir,
+	}, storage, nil
+}
+
+// diagnoseStorage adds to an error opening the node's storage the first
+// problem found by checking the run directory, if any.
+func diagnoseStorage(err error, runDir string) error {
+	report, checkErr := sample.CheckStorage(runDir)
+	if checkErr != nil || len(report.Problems) == 0 {
+		return err
+	}
+	return errors.Errorf("%s, as %s, see nodedata fsck", err, report.Problems[0])
+}
+
+// serveMetrics registers the server's metrics, along with those of the Go
+// runtime and the process, and serves them at /metrics.
+func (a *args) serveMetrics(server *sample.Server, logger *zap.SugaredLogger) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	server.Registerer = registry
+
+	listener, err := net.Listen("tcp", a.metricsAddr)
+	if err != nil {
+		return errors.WithMessage(err, "could not listen for metrics")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	go func() {
+		err := http.Serve(listener, mux)
+		logger.Errorw("Stopped serving metrics", "error", err)
+	}()
+
+	return nil
 }
 
 func main() {
@@ -88,19 +228,79 @@ func main() {
 		kingpin.Fatalf("Error parsing arguments, %s, try --help", err)
 	}
 
-	server, err := args.initializeServer()
+	nodeConfig, err := args.loadConfig()
 	if err != nil {
 		kingpin.Fatalf("Error initializing server, %s", err)
 	}
 
+	if args.printConfig {
+		out, err := yaml.Marshal(nodeConfig.Redacted())
+		if err != nil {
+			kingpin.Fatalf("Error printing config, %s", err)
+		}
+		fmt.Print(string(out))
+		return
+	}
+
+	err = args.checkRunDir(nodeConfig)
+	if err != nil {
+		kingpin.Fatalf("Error initializing server, %s", err)
+	}
+
+	loggers, err := logging.New(&nodeConfig.Logging, zap.Uint64("node_id", nodeConfig.ID))
+	if err != nil {
+		kingpin.Fatalf("Error initializing logging, %s", err)
+	}
+	defer loggers.Close()
+	logger := loggers.Named(logging.App)
+
+	tracing.LogErrors(logger)
+	tracer, err := tracing.New(&nodeConfig.Tracing, "mirbft-sample-node", tracing.NodeID.Int64(int64(nodeConfig.ID)))
+	if err != nil {
+		kingpin.Fatalf("Error initializing tracing, %s", err)
+	}
+	defer func() {
+		if err := tracer.Close(); err != nil {
+			logger.Warnw("Could not export the remaining spans", "error", err)
+		}
+	}()
+
+	server, storage, err := args.initializeServer(nodeConfig, loggers)
+	if err != nil {
+		kingpin.Fatalf("Error initializing server, %s", err)
+	}
+	defer func() {
+		if err := storage.Close(); err != nil {
+			logger.Errorw("Could not close storage", "error", err)
+		}
+	}()
+	server.Tracer = tracer.Tracer()
+
+	if args.metricsAddr != "" {
+		err = args.serveMetrics(server, logger)
+		if err != nil {
+			kingpin.Fatalf("Error initializing server, %s", err)
+		}
+	}
+
+	if nodeConfig.AdminAddress != "" {
+		err = serveAdmin(server, args.runDir, logger)
+		if err != nil {
+			kingpin.Fatalf("Error initializing server, %s", err)
+		}
+	}
+
 	go func() {
 		err = server.Run()
 		if err != nil {
+			storage.Close()
+			tracer.Close()
+			loggers.Close()
 			kingpin.Fatalf("Application exited abnormally, %s", err)
 		}
 	}()
 
-	handleSignals(server.Stop)
+	handleSignals(logger, func() { args.reloadConfig(server, logger) }, server.Stop)
 
-	fmt.Printf("Success! All worker go routines exited, terminating!\n")
+	logger.Infow("All worker go routines exited, terminating")
 }
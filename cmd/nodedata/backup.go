@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/pkg/errors"
+)
+
+type backupArgs struct {
+	output  string
+	timeout time.Duration
+}
+
+// run writes the archive to a temporary file beside the output, which
+// replaces the output only once the archive has been read back and verified.
+func (ba *backupArgs) run(n *node) error {
+	if err := n.checkGenesis(n.runDir); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(ba.output), "."+filepath.Base(ba.output)+".*")
+	if err != nil {
+		return errors.WithMessage(err, "could not create archive")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	lock, err := n.lock()
+	switch {
+	case err == errRunning:
+		fmt.Printf("Node %d is running, backing it up through its admin API\n", n.config.ID)
+		err = ba.fetch(f, n)
+	case err != nil:
+		return err
+	default:
+		_, err = sample.WriteBackup(f, n.runDir, n.config.ID, n.genesisHash)
+		lock.release()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return errors.WithMessage(err, "could not sync archive")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	manifest, err := sample.ReadBackup(f, "")
+	if err != nil {
+		return errors.WithMessage(err, "could not verify archive")
+	}
+	if manifest.NodeID != n.config.ID || manifest.GenesisHash != n.genesisHash {
+		return errors.Errorf("archive is of node %d with genesis %s, not node %d with genesis %s", manifest.NodeID, manifest.GenesisHash, n.config.ID, n.genesisHash)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.WithMessage(err, "could not write archive")
+	}
+	if err := os.Rename(f.Name(), ba.output); err != nil {
+		return errors.WithMessage(err, "could not write archive")
+	}
+
+	fmt.Printf("Backed up %d files of node %d to %s, %d bytes, sha256 %x\n", len(manifest.Files), n.config.ID, ba.output, size, hash.Sum(nil))
+	return nil
+}
+
+// fetch writes the archive the running node produces while quiesced to w.
+func (ba *backupArgs) fetch(w io.Writer, n *node) error {
+	if n.config.AdminAddress == "" {
+		return errors.Errorf("node %d is running without an admin_address through which to quiesce it, stop it first", n.config.ID)
+	}
+
+	client := &http.Client{Timeout: ba.timeout}
+	resp, err := client.Post("http://"+n.adminAddress()+"/backup", "", nil)
+	if err != nil {
+		return errors.WithMessage(err, "could not request backup")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return errors.Errorf("node refused backup, %s: %s", resp.Status, body)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return errors.WithMessage(err, "could not receive archive")
+	}
+	return nil
+}
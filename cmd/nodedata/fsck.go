@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/pkg/errors"
+)
+
+type fsckArgs struct {
+	repair     bool
+	acceptRisk bool
+}
+
+// run checks the WAL and request store, printing what was checked and each
+// problem found, and, if asked and the risk accepted, truncates the WAL back
+// to its last stable checkpoint.
+func (fa *fsckArgs) run(w io.Writer, n *node) error {
+	lock, err := n.lock()
+	if err == errRunning {
+		return errors.Errorf("node %d is running, stop it before checking its storage", n.config.ID)
+	}
+	if err != nil {
+		return err
+	}
+	// The request store takes the lock itself while it is checked.
+	lock.release()
+
+	report, err := sample.CheckStorage(n.runDir)
+	if err != nil {
+		return err
+	}
+
+	if report.Entries() == 0 {
+		fmt.Fprintf(w, "WAL: no entries\n")
+	} else {
+		fmt.Fprintf(w, "WAL: %d entries, indices %d to %d, %d checkpoints\n", report.Entries(), report.FirstIndex, report.LastIndex, report.Checkpoints)
+	}
+	fmt.Fprintf(w, "Request store: %d requests referenced by the WAL looked up\n", report.Requests)
+
+	if len(report.Problems) == 0 {
+		fmt.Fprintf(w, "No problems found\n")
+		return nil
+	}
+
+	fmt.Fprintf(w, "Problems:\n")
+	for _, p := range report.Problems {
+		fmt.Fprintf(w, "  %s\n", p)
+	}
+
+	if report.RepairIndex != 0 {
+		plan := fmt.Sprintf("Repairing would truncate the WAL after index %d, back to the stable checkpoint at seq_no %d, and change to epoch %d", report.RepairIndex, report.RepairSeqNo, report.RepairEpoch)
+		if report.Transfer != nil {
+			plan += fmt.Sprintf(", transferring the node's state to its snapshot at seq_no %d", report.Transfer.SeqNo)
+		}
+		fmt.Fprintf(w, "%s\n", plan)
+	}
+
+	switch {
+	case !fa.repair:
+		return errors.Errorf("found %d problems, run with --repair --acceptEquivocationRisk to truncate the WAL back to its last stable checkpoint", len(report.Problems))
+	case !fa.acceptRisk:
+		return errors.Errorf("found %d problems, and a repaired node may send messages contradicting those it discards the record of, run with --acceptEquivocationRisk if it is to be counted as faulty", len(report.Problems))
+	}
+
+	lock, err = n.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	aside, err := sample.RepairWAL(n.runDir, report, fa.acceptRisk)
+	if err != nil {
+		return errors.WithMessage(err, "could not repair")
+	}
+
+	fmt.Fprintf(w, "Truncated the WAL after index %d, having copied it as it was to %s\n", report.RepairIndex, aside)
+	if report.Transfer != nil {
+		fmt.Fprintf(w, "Once started, the node transfers its state to its snapshot at seq_no %d, and rejoins the network at epoch %d or later, counting as faulty\n", report.Transfer.SeqNo, report.RepairEpoch)
+	} else {
+		fmt.Fprintf(w, "Once started, the node recovers from the checkpoint at seq_no %d, and rejoins the network at epoch %d or later, fetching what it lost from its peers and counting as faulty\n", report.RepairSeqNo, report.RepairEpoch)
+	}
+	return nil
+}
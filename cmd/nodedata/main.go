@@ -0,0 +1,147 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type args struct {
+	command    string
+	nodeConfig string
+	runDir     string
+	backup     *backupArgs
+	restore    *restoreArgs
+	fsck       *fsckArgs
+}
+
+// nodeFlags registers the flags locating the node's config and run directory.
+func nodeFlags(cmd *kingpin.CmdClause) (*string, *string) {
+	nodeConfig := cmd.Flag("nodeConfig", "The YAML file containing the node's config.").Required().ExistingFile()
+	runDir := cmd.Flag("runDir", "The node's run directory.").Required().String()
+	return nodeConfig, runDir
+}
+
+func parseArgs(argsString []string) (*args, error) {
+	app := kingpin.New("nodedata", "Back up, restore, and check the durable state within the run directory of a mir-sample node.")
+
+	backupCmd := app.Command("backup", "Write a checksummed archive of the node's run directory.  A running node is quiesced through its admin API while it is archived.")
+	backupNodeConfig, backupRunDir := nodeFlags(backupCmd)
+	output := backupCmd.Flag("output", "The file to write the archive to.").Short('o').Required().String()
+	timeout := backupCmd.Flag("timeout", "How long to wait for a running node to write the archive.").Default("1m").Duration()
+
+	restoreCmd := app.Command("restore", "Replace the node's run directory with the contents of an archive, once it is verified and found to belong to the node and its genesis.  The node must be stopped.  The replaced contents are kept alongside the run directory.")
+	restoreNodeConfig, restoreRunDir := nodeFlags(restoreCmd)
+	input := restoreCmd.Arg("archive", "The archive to restore, as written by backup.").Required().ExistingFile()
+
+	fsckCmd := app.Command("fsck", "Check that the node's WAL entries are continuous and readable, and that every request they reference is in the request store.  The node must be stopped.")
+	fsckNodeConfig, fsckRunDir := nodeFlags(fsckCmd)
+	repair := fsckCmd.Flag("repair", "Truncate the WAL back to the last stable checkpoint before the first problem, having copied it aside, so that the node changes to a new epoch, and transfers its state to its latest snapshot, if later.  This discards the node's record of the messages it sent since the checkpoint, so once restarted it may contradict them, equivocating, and must be counted as faulty.  Requires --acceptEquivocationRisk.").Default("false").Bool()
+	acceptRisk := fsckCmd.Flag("acceptEquivocationRisk", "Accept that a repaired node may equivocate, so counts towards the faults the network tolerates, and that no more nodes than that may be repaired.").Default("false").Bool()
+
+	command, err := app.Parse(argsString)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &args{
+		command: command,
+	}
+
+	switch command {
+	case backupCmd.FullCommand():
+		a.nodeConfig = *backupNodeConfig
+		a.runDir = *backupRunDir
+		a.backup = &backupArgs{
+			output:  *output,
+			timeout: *timeout,
+		}
+	case restoreCmd.FullCommand():
+		a.nodeConfig = *restoreNodeConfig
+		a.runDir = *restoreRunDir
+		a.restore = &restoreArgs{
+			input: *input,
+		}
+	case fsckCmd.FullCommand():
+		a.nodeConfig = *fsckNodeConfig
+		a.runDir = *fsckRunDir
+		a.fsck = &fsckArgs{
+			repair:     *repair,
+			acceptRisk: *acceptRisk,
+		}
+	}
+
+	return a, nil
+}
+
+// node is the node whose run directory is backed up or restored, and the hash
+// of its genesis.
+type node struct {
+	config      *config.NodeConfig
+	genesisHash string
+	runDir      string
+}
+
+// loadNode reads the node config, which need not be valid on this host, as
+// neither its keys nor its addresses are used.
+func (a *args) loadNode() (*node, error) {
+	data, err := ioutil.ReadFile(a.nodeConfig)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not read node config")
+	}
+
+	nodeConfig, err := config.ReadNodeConfig(data)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not parse node config")
+	}
+
+	return &node{
+		config:      nodeConfig,
+		genesisHash: hex.EncodeToString(nodeConfig.Genesis().Hash()),
+		runDir:      a.runDir,
+	}, nil
+}
+
+func (a *args) run() error {
+	n, err := a.loadNode()
+	if err != nil {
+		return err
+	}
+
+	if n.config.Storage.Backend == "memory" {
+		return errors.Errorf("node %d keeps its state in memory, so has none on disk", n.config.ID)
+	}
+
+	switch {
+	case a.backup != nil:
+		return a.backup.run(n)
+	case a.restore != nil:
+		return a.restore.run(n)
+	case a.fsck != nil:
+		return a.fsck.run(os.Stdout, n)
+	}
+
+	return nil
+}
+
+func main() {
+	kingpin.Version("0.0.1")
+	args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("Error parsing arguments, %s, try --help", err)
+	}
+
+	if err := args.run(); err != nil {
+		kingpin.Fatalf("Error running %s, %s", args.command, err)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/pkg/errors"
+)
+
+type restoreArgs struct {
+	input string
+}
+
+// run extracts the archive into a directory beside the run directory, and
+// only once it has been verified moves the contents of the run directory
+// aside and the restored directory into its place.
+func (ra *restoreArgs) run(n *node) error {
+	lock, err := n.lock()
+	if err == errRunning {
+		return errors.Errorf("node %d is running, stop it before restoring its run directory", n.config.ID)
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	runDir := filepath.Clean(n.runDir)
+	restoreDir, err := ioutil.TempDir(filepath.Dir(runDir), "."+filepath.Base(runDir)+".restore-")
+	if err != nil {
+		return errors.WithMessage(err, "could not create directory to restore into")
+	}
+	defer os.RemoveAll(restoreDir)
+
+	f, err := os.Open(ra.input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := sample.ReadBackup(f, restoreDir)
+	if err != nil {
+		return errors.WithMessage(err, "could not verify archive")
+	}
+
+	if manifest.NodeID != n.config.ID {
+		return errors.Errorf("archive is of node %d, but the config is of node %d", manifest.NodeID, n.config.ID)
+	}
+	if manifest.GenesisHash != n.genesisHash {
+		return errors.Errorf("archive is of genesis %s, but the config's genesis is %s, if the network was modified via bootstrap the archive is of no use to it", manifest.GenesisHash, n.genesisHash)
+	}
+	if err := n.checkGenesis(restoreDir); err != nil {
+		return errors.WithMessage(err, "archive is inconsistent with its manifest")
+	}
+
+	var previous string
+	entries, err := ioutil.ReadDir(runDir)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return errors.WithMessage(err, "could not read run directory")
+	case len(entries) == 0:
+		if err := os.Remove(runDir); err != nil {
+			return err
+		}
+	default:
+		previous = fmt.Sprintf("%s.pre-restore-%s", runDir, time.Now().Format("20060102-150405"))
+		if err := os.Rename(runDir, previous); err != nil {
+			return errors.WithMessage(err, "could not move run directory aside")
+		}
+	}
+
+	if err := os.Rename(restoreDir, runDir); err != nil {
+		return errors.WithMessage(err, "could not move restored directory into place")
+	}
+
+	fmt.Printf("Restored %d files of node %d, backed up at %s, to %s\n", len(manifest.Files), manifest.NodeID, manifest.CreatedAt.Format(time.RFC3339), runDir)
+	if previous != "" {
+		fmt.Printf("The previous contents of the run directory were moved to %s\n", previous)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	sample "github.com/jyellick/mirbft-sample"
+	"github.com/pkg/errors"
+)
+
+var errRunning = errors.Errorf("node is running")
+
+// runDirLock holds the lock a running node holds on its request store, so
+// that the node cannot start while the run directory is read or replaced.
+type runDirLock struct {
+	dir *os.File
+}
+
+// lock takes the request store's lock, returning errRunning if the node
+// holds it.  If the request store does not exist, there is nothing to lock.
+func (n *node) lock() (*runDirLock, error) {
+	dir, err := os.Open(filepath.Join(n.runDir, sample.RequestStoreDir))
+	if os.IsNotExist(err) {
+		return &runDirLock{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not open request store")
+	}
+
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		dir.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errRunning
+		}
+		return nil, errors.WithMessage(err, "could not lock request store")
+	}
+
+	return &runDirLock{dir: dir}, nil
+}
+
+func (l *runDirLock) release() {
+	if l.dir != nil {
+		l.dir.Close()
+	}
+}
+
+// checkGenesis returns an error unless the genesis hash recorded in dir is
+// that of the node's genesis.
+func (n *node) checkGenesis(dir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, sample.GenesisHashFile))
+	if os.IsNotExist(err) {
+		return errors.Errorf("'%s' has no %s, the node has not been started from it", dir, sample.GenesisHashFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	if recorded := strings.TrimSpace(string(data)); recorded != n.genesisHash {
+		return errors.Errorf("'%s' was initialized with genesis %s, but the config's genesis is %s", dir, recorded, n.genesisHash)
+	}
+
+	return nil
+}
+
+// adminAddress returns the address at which to reach the node's admin API.
+// A node serving it on all interfaces is reached on the loopback interface.
+func (n *node) adminAddress() string {
+	host, port, err := net.SplitHostPort(n.config.AdminAddress)
+	if err != nil {
+		return n.config.AdminAddress
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"sync"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/pkg/errors"
+)
+
+// commitLogRetention is the number of most recently applied sequence numbers
+// the commit log retains for subscribers.
+const commitLogRetention = 100000
+
+// commitLog retains the entries applied at recent sequence numbers so that
+// they may be streamed to subscribed clients.  It is held only in memory, so
+// after a restart or a state transfer, it begins again from the next applied
+// sequence number.
+type commitLog struct {
+	mutex      sync.Mutex
+	firstSeqNo uint64
+	entries    []*pb.QEntry
+	updateC    chan struct{}
+}
+
+func newCommitLog() *commitLog {
+	return &commitLog{
+		updateC: make(chan struct{}),
+	}
+}
+
+func (cl *commitLog) append(entry *pb.QEntry) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	seqNo := entry.SeqNo
+	nextSeqNo := cl.firstSeqNo + uint64(len(cl.entries))
+	switch {
+	case len(cl.entries) > 0 && seqNo < nextSeqNo:
+		// Already retained, this is a replay after restart.
+		return
+	case len(cl.entries) == 0 || seqNo > nextSeqNo:
+		// We've skipped ahead, likely via state transfer, so the retained
+		// entries are no longer contiguous.
+		cl.firstSeqNo = seqNo
+		cl.entries = nil
+	}
+
+	cl.entries = append(cl.entries, entry)
+	if len(cl.entries) > commitLogRetention {
+		drop := len(cl.entries) - commitLogRetention
+		cl.entries = cl.entries[drop:]
+		cl.firstSeqNo += uint64(drop)
+	}
+
+	close(cl.updateC)
+	cl.updateC = make(chan struct{})
+}
+
+// get returns the entry applied at seqNo.  If seqNo has not yet been applied,
+// it returns a nil entry and a channel which is closed on the next append.
+func (cl *commitLog) get(seqNo uint64) (*pb.QEntry, <-chan struct{}, error) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	if len(cl.entries) == 0 || seqNo >= cl.firstSeqNo+uint64(len(cl.entries)) {
+		return nil, cl.updateC, nil
+	}
+
+	if seqNo < cl.firstSeqNo {
+		return nil, nil, errors.Errorf("seq_no=%d is not retained, the earliest retained is seq_no=%d", seqNo, cl.firstSeqNo)
+	}
+
+	return cl.entries[seqNo-cl.firstSeqNo], nil, nil
+}
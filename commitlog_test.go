@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"testing"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func qEntry(seqNo uint64, digest string) *pb.QEntry {
+	return &pb.QEntry{
+		SeqNo:  seqNo,
+		Digest: []byte(digest),
+	}
+}
+
+func TestCommitLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		appends []*pb.QEntry
+		seqNo   uint64
+		digest  string
+		err     string
+	}{
+		{
+			name:  "empty",
+			seqNo: 1,
+		},
+		{
+			name:    "retained",
+			appends: []*pb.QEntry{qEntry(1, "a"), qEntry(2, "b"), qEntry(3, "c")},
+			seqNo:   2,
+			digest:  "b",
+		},
+		{
+			name:    "not yet applied",
+			appends: []*pb.QEntry{qEntry(1, "a"), qEntry(2, "b")},
+			seqNo:   3,
+		},
+		{
+			name:    "replayed after restart",
+			appends: []*pb.QEntry{qEntry(1, "a"), qEntry(2, "b"), qEntry(3, "c"), qEntry(2, "replayed"), qEntry(3, "replayed")},
+			seqNo:   2,
+			digest:  "b",
+		},
+		{
+			name:    "replay continues",
+			appends: []*pb.QEntry{qEntry(1, "a"), qEntry(2, "b"), qEntry(2, "replayed"), qEntry(3, "c")},
+			seqNo:   3,
+			digest:  "c",
+		},
+		{
+			name:    "skipped ahead",
+			appends: []*pb.QEntry{qEntry(1, "a"), qEntry(2, "b"), qEntry(10, "j")},
+			seqNo:   10,
+			digest:  "j",
+		},
+		{
+			name:    "skipped over",
+			appends: []*pb.QEntry{qEntry(1, "a"), qEntry(2, "b"), qEntry(10, "j")},
+			seqNo:   2,
+			err:     "seq_no=2 is not retained, the earliest retained is seq_no=10",
+		},
+		{
+			name:    "first applied after start",
+			appends: []*pb.QEntry{qEntry(5, "e"), qEntry(6, "f")},
+			seqNo:   4,
+			err:     "seq_no=4 is not retained, the earliest retained is seq_no=5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := newCommitLog()
+			for _, entry := range tt.appends {
+				cl.append(entry)
+			}
+
+			entry, updateC, err := cl.get(tt.seqNo)
+			switch {
+			case tt.err != "":
+				assert.EqualError(t, err, tt.err)
+			case tt.digest == "":
+				require.NoError(t, err)
+				assert.Nil(t, entry)
+				assert.NotNil(t, updateC)
+			default:
+				require.NoError(t, err)
+				require.NotNil(t, entry)
+				assert.Equal(t, tt.digest, string(entry.Digest))
+			}
+		})
+	}
+}
+
+func TestCommitLogUpdate(t *testing.T) {
+	cl := newCommitLog()
+	cl.append(qEntry(1, "a"))
+
+	_, updateC, err := cl.get(2)
+	require.NoError(t, err)
+
+	cl.append(qEntry(1, "replayed"))
+	select {
+	case <-updateC:
+		t.Fatalf("update signaled for a replayed entry")
+	default:
+	}
+
+	cl.append(qEntry(2, "b"))
+	select {
+	case <-updateC:
+	default:
+		t.Fatalf("update not signaled for a new entry")
+	}
+}
+
+func TestCommitLogRetention(t *testing.T) {
+	cl := newCommitLog()
+	last := uint64(commitLogRetention + 10)
+	for seqNo := uint64(1); seqNo <= last; seqNo++ {
+		cl.append(qEntry(seqNo, ""))
+	}
+
+	_, _, err := cl.get(10)
+	assert.EqualError(t, err, "seq_no=10 is not retained, the earliest retained is seq_no=11")
+
+	entry, _, err := cl.get(11)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), entry.SeqNo)
+
+	entry, _, err = cl.get(last)
+	require.NoError(t, err)
+	assert.Equal(t, last, entry.SeqNo)
+}
@@ -16,23 +16,77 @@ import (
 )
 
 type NodeConfig struct {
-	ID            uint64       `yaml:"id"`
-	ListenAddress string       `yaml:"listen_address"`
-	PrivateKey    string       `yaml:"private_key"`
-	MirRuntime    MirRuntime   `yaml:"mir_runtime"`
-	MirBootstrap  MirBootstrap `yaml:"mir_bootstrap"`
-	Nodes         []Node       `yaml:"nodes"`
-	Clients       []Client     `yaml:"clients"`
+	// Version is the version of the config schema, see CurrentVersion.
+	Version uint64 `yaml:"version"`
+
+	ID uint64 `yaml:"id"`
+
+	// ListenAddress is the address, as host:port, at which peers and
+	// clients reach this node.  It must match this node's entry in Nodes.
+	ListenAddress string `yaml:"listen_address"`
+
+	// BindAddress is the local address, as host:port, on which the node
+	// listens, for instance [::]:5000, when it differs from ListenAddress
+	// such as behind NAT.  If empty, the node listens on all interfaces
+	// using the port of ListenAddress.  The host, if set, must be an IP.
+	BindAddress string `yaml:"bind_address,omitempty"`
+
+	// AdminAddress is the local address, as host:port, on which the node
+	// serves its admin API, reporting its status as JSON.  If empty, the
+	// admin API is not served.  As the admin API has no authentication,
+	// and may back up the node's state, it should be on localhost.
+	AdminAddress string `yaml:"admin_address,omitempty"`
+
+	PrivateKey string `yaml:"private_key,omitempty"`
+
+	// PrivateKeyFile is the path to a file containing the hex encoded
+	// private key, used in place of PrivateKey.  Relative paths are resolved
+	// against the directory containing the config file.
+	PrivateKeyFile string `yaml:"private_key_file,omitempty"`
+
+	// GenesisFile is the path to the genesis written by bootstrap.  If set,
+	// the mir_bootstrap, nodes, and clients of the config must match it.
+	// Relative paths are resolved against the directory containing the
+	// config file.
+	GenesisFile string `yaml:"genesis_file,omitempty"`
+
+	Logging      Logging      `yaml:"logging,omitempty"`
+	Health       Health       `yaml:"health,omitempty"`
+	Tracing      Tracing      `yaml:"tracing,omitempty"`
+	EventLog     EventLog     `yaml:"event_log,omitempty"`
+	Storage      Storage      `yaml:"storage,omitempty"`
+	Snapshots    Snapshots    `yaml:"snapshots,omitempty"`
+	MirRuntime   MirRuntime   `yaml:"mir_runtime"`
+	MirBootstrap MirBootstrap `yaml:"mir_bootstrap"`
+	Nodes        []Node       `yaml:"nodes"`
+	Clients      []Client     `yaml:"clients"`
 }
 
 type ClientConfig struct {
+	// Version is the version of the config schema, see CurrentVersion.
+	Version uint64 `yaml:"version"`
+
 	ID         uint64 `yaml:"id"`
-	PrivateKey string `yaml:"private_key"`
-	Nodes      []Node `yaml:"nodes"`
+	PrivateKey string `yaml:"private_key,omitempty"`
+
+	// PrivateKeyFile is the path to a file containing the hex encoded
+	// private key, used in place of PrivateKey.  Relative paths are resolved
+	// against the directory containing the config file.
+	PrivateKeyFile string `yaml:"private_key_file,omitempty"`
+
+	Logging Logging `yaml:"logging,omitempty"`
+	Tracing Tracing `yaml:"tracing,omitempty"`
+	Nodes   []Node  `yaml:"nodes"`
 }
 
 type Node struct {
-	ID        uint64 `yaml:"id"`
+	ID uint64 `yaml:"id"`
+
+	// Name is an optional human readable name for the node.
+	Name string `yaml:"name,omitempty"`
+
+	// Address is the host:port at which the node may be reached, the host
+	// may be an IPv4 or IPv6 address, or a DNS name.
 	Address   string `yaml:"address"`
 	PublicKey string `yaml:"public_key"`
 }
@@ -42,6 +96,116 @@ type Client struct {
 	PublicKey string `yaml:"public_key"`
 }
 
+// Logging configures where and what a node or client logs.  Levels are one
+// of debug, info, warn, or error.
+type Logging struct {
+	// Level is the level logged by components without a level of their
+	// own in Levels, by default info.
+	Level  string    `yaml:"level,omitempty"`
+	Levels LogLevels `yaml:"levels,omitempty"`
+
+	// Encoding is console, the default, or json.
+	Encoding string `yaml:"encoding,omitempty"`
+
+	// File is the path to log to in place of stderr.  Relative paths are
+	// resolved against the directory containing the config file.  The file
+	// is rotated once it exceeds MaxSizeMB, by default 100, keeping at most
+	// MaxBackups old files, no older than MaxAgeDays, or all of them if
+	// zero.
+	File       string `yaml:"file,omitempty"`
+	MaxSizeMB  uint32 `yaml:"max_size_mb,omitempty"`
+	MaxBackups uint32 `yaml:"max_backups,omitempty"`
+	MaxAgeDays uint32 `yaml:"max_age_days,omitempty"`
+
+	// Compress gzips rotated files.
+	Compress bool `yaml:"compress,omitempty"`
+}
+
+// LogLevels overrides the level logged by individual components.
+type LogLevels struct {
+	Mir       string `yaml:"mir,omitempty"`
+	Noise     string `yaml:"noise,omitempty"`
+	App       string `yaml:"app,omitempty"`
+	Transport string `yaml:"transport,omitempty"`
+}
+
+// Health configures the liveness and readiness reported by the admin API.
+type Health struct {
+	// StallTimeout is how long processing may go without handling an event,
+	// such as a tick or a message, before the node is reported as not live,
+	// by default 30s.
+	StallTimeout time.Duration `yaml:"stall_timeout,omitempty"`
+
+	// CommitWindow is how recently the node must have committed an entry to
+	// be reported as ready, by default 30s.  Idle nodes still commit empty
+	// entries on every heartbeat.
+	CommitWindow time.Duration `yaml:"commit_window,omitempty"`
+}
+
+// Tracing configures where the spans traced for each request are exported.
+// Tracing is disabled unless File or OTLPEndpoint is set.
+type Tracing struct {
+	// File is the path to which spans are written as JSON, one per line.
+	// Relative paths are resolved against the directory containing the
+	// config file.
+	File string `yaml:"file,omitempty"`
+
+	// OTLPEndpoint is the base URL of a collector accepting OTLP over HTTP,
+	// for instance http://localhost:4318, to which spans are posted.
+	OTLPEndpoint string `yaml:"otlp_endpoint,omitempty"`
+
+	// SampleRatio is the fraction of requests traced, by default all of
+	// them.  Nodes trace the requests sampled by the client.
+	SampleRatio float64 `yaml:"sample_ratio,omitempty"`
+}
+
+// EventLog configures the state machine event log, which the node records
+// when started with --eventLog.  The log is rotated into segments once it
+// exceeds MaxSizeMB, by default 100, or once it has been open for
+// RotateInterval, if set, keeping at most MaxSegments rotated segments, or
+// all of them if zero.
+type EventLog struct {
+	MaxSizeMB      uint32        `yaml:"max_size_mb,omitempty"`
+	RotateInterval time.Duration `yaml:"rotate_interval,omitempty"`
+	MaxSegments    uint32        `yaml:"max_segments,omitempty"`
+
+	// Compression trades CPU for the size of the log, and is one of none,
+	// speed, the default, or best.
+	Compression string `yaml:"compression,omitempty"`
+
+	// OnRestart is what becomes of the log written before the node last
+	// stopped, either append, the default, to continue writing to it, or
+	// rotate, to begin a new segment.
+	OnRestart string `yaml:"on_restart,omitempty"`
+}
+
+// Storage configures where the node persists its WAL and the requests it
+// receives.
+type Storage struct {
+	// Backend is disk, the default, which keeps them within the run
+	// directory, or memory, which keeps them only for as long as the node
+	// runs, for tests and benchmarks.  A node whose storage is in memory
+	// starts afresh each time, so it may not rejoin a running network.
+	Backend string `yaml:"backend,omitempty"`
+
+	// Sync is when the disk backend syncs its writes to disk, either
+	// always, the default, whenever Mir requires them to be durable,
+	// interval, at most once per SyncInterval (by default 100ms), or never,
+	// leaving it to the operating system.  Writes which are not synced may
+	// be lost if the host fails, so that the node may contradict what it
+	// told its peers, and only always is safe.
+	Sync         string        `yaml:"sync,omitempty"`
+	SyncInterval time.Duration `yaml:"sync_interval,omitempty"`
+}
+
+// Snapshots configures the snapshots of stable checkpoints which the node
+// persists within its run directory, unless Disabled or its storage is in
+// memory.  The latest Retain snapshots are kept, by default 5.
+type Snapshots struct {
+	Disabled bool   `yaml:"disabled,omitempty"`
+	Retain   uint32 `yaml:"retain,omitempty"`
+}
+
 // MirRuntime contains per Node instance fields which should be consistent
 // across honest nodes, but which may be tweaked after bootstrap.
 type MirRuntime struct {
@@ -58,33 +222,120 @@ type MirRuntime struct {
 type MirBootstrap struct {
 	NumberOfBuckets    uint32 `yaml:"number_of_buckets"`
 	ClientWindowSize   uint32 `yaml:"client_window_size"`
-	CheckpointInterval uint32 `yaml"checkpoint_interval"`
+	CheckpointInterval uint32 `yaml:"checkpoint_interval"`
 }
 
-func LoadNodeConfig(f io.Reader) (*NodeConfig, error) {
+func LoadNodeConfig(f io.Reader, opts ...LoadOpt) (*NodeConfig, error) {
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
 		return nil, errors.WithMessage(err, "error reading config")
 	}
 
+	lo := &loadOpts{}
+	for _, opt := range opts {
+		opt(lo)
+	}
+
+	data, err = lo.migrate(data, MigrateNodeConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	config := &NodeConfig{}
-	if err = yaml.Unmarshal(data, config); err != nil {
+	ve := &ValidationError{}
+	if err := decodeStrict(data, config, ve); err != nil {
+		return nil, err
+	}
+	lo.applyOverrides(config, ve)
+	config.PrivateKey = lo.resolveKeyFile(ve, config.PrivateKey, config.PrivateKeyFile)
+	config.Logging.File = lo.resolvePath(config.Logging.File)
+	config.Tracing.File = lo.resolvePath(config.Tracing.File)
+	config.validate(ve)
+	lo.checkGenesisFile(ve, config)
+	if err := ve.err(); err != nil {
 		return nil, err
 	}
 
 	return config, nil
 }
 
-func LoadClientConfig(f io.Reader) (*ClientConfig, error) {
+func LoadClientConfig(f io.Reader, opts ...LoadOpt) (*ClientConfig, error) {
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
 		return nil, errors.WithMessage(err, "error reading config")
 	}
 
+	lo := &loadOpts{}
+	for _, opt := range opts {
+		opt(lo)
+	}
+
+	data, err = lo.migrate(data, MigrateClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	config := &ClientConfig{}
-	if err = yaml.Unmarshal(data, config); err != nil {
+	ve := &ValidationError{}
+	if err := decodeStrict(data, config, ve); err != nil {
+		return nil, err
+	}
+	lo.applyOverrides(config, ve)
+	config.PrivateKey = lo.resolveKeyFile(ve, config.PrivateKey, config.PrivateKeyFile)
+	config.Logging.File = lo.resolvePath(config.Logging.File)
+	config.Tracing.File = lo.resolvePath(config.Tracing.File)
+	config.validate(ve)
+	if err := ve.err(); err != nil {
 		return nil, err
 	}
 
 	return config, nil
 }
+
+// ReadNodeConfig decodes a node config, upgrading it to the current version,
+// without applying overrides, resolving its key file, or validating it, for
+// tools which edit configs in place.
+func ReadNodeConfig(data []byte) (*NodeConfig, error) {
+	m, err := MigrateNodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &NodeConfig{}
+	if err := yaml.UnmarshalStrict(m.Data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ReadClientConfig decodes a client config, upgrading it to the current
+// version, without applying overrides, resolving its key file, or validating
+// it, for tools which edit configs in place.
+func ReadClientConfig(data []byte) (*ClientConfig, error) {
+	m, err := MigrateClientConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ClientConfig{}
+	if err := yaml.UnmarshalStrict(m.Data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// decodeStrict unmarshals the YAML into config, rejecting unknown or
+// duplicated fields.  Field problems are recorded in ve so that they are
+// reported alongside any semantic problems, while malformed YAML is returned
+// as an error.
+func decodeStrict(data []byte, config interface{}, ve *ValidationError) error {
+	err := yaml.UnmarshalStrict(data, config)
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		ve.Problems = append(ve.Problems, typeErr.Errors...)
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not parse config")
+	}
+	return nil
+}
@@ -7,12 +7,475 @@ SPDX-License-Identifier: Apache-2.0
 package config
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/perlin-network/noise"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
-func TestLoadConfig(t *testing.T) {
-	config, err := LoadConfig("config.yaml")
-	assert.NoError(t, err)
+func testNodeConfig(t *testing.T) *NodeConfig {
+	nc := &NodeConfig{
+		Version: CurrentVersion,
+		MirRuntime: MirRuntime{
+			TickInterval:         time.Second,
+			HeartbeatTicks:       1,
+			SuspectTicks:         4,
+			NewEpochTimeoutTicks: 8,
+			BatchSize:            20,
+			BufferSize:           5 * 1024 * 1024,
+		},
+		MirBootstrap: MirBootstrap{
+			NumberOfBuckets:    1,
+			ClientWindowSize:   100,
+			CheckpointInterval: 20,
+		},
+	}
+
+	for i := 0; i < 4; i++ {
+		pubKey, privKey, err := noise.GenerateKeys(nil)
+		require.NoError(t, err)
+		nc.Nodes = append(nc.Nodes, Node{
+			ID:        uint64(i),
+			Address:   fmt.Sprintf("127.0.0.1:%d", 5000+i),
+			PublicKey: pubKey.String(),
+		})
+		if i == 0 {
+			nc.ListenAddress = nc.Nodes[0].Address
+			nc.PrivateKey = privKey.String()
+		}
+	}
+
+	pubKey, _, err := noise.GenerateKeys(nil)
+	require.NoError(t, err)
+	nc.Clients = []Client{{ID: 0, PublicKey: pubKey.String()}}
+
+	return nc
+}
+
+func loadNodeConfig(t *testing.T, nc *NodeConfig) (*NodeConfig, error) {
+	data, err := yaml.Marshal(nc)
+	require.NoError(t, err)
+	return LoadNodeConfig(bytes.NewReader(data))
+}
+
+func TestLoadNodeConfig(t *testing.T) {
+	nc := testNodeConfig(t)
+	loaded, err := loadNodeConfig(t, nc)
+	require.NoError(t, err)
+	assert.Equal(t, nc, loaded)
+}
+
+func TestLoadNodeConfigUnknownField(t *testing.T) {
+	data, err := yaml.Marshal(testNodeConfig(t))
+	require.NoError(t, err)
+	data = append(data, []byte("listen_adress: 127.0.0.1:5000\n")...)
+
+	_, err = LoadNodeConfig(bytes.NewReader(data))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "field listen_adress not found")
+}
+
+func TestLoadNodeConfigMigration(t *testing.T) {
+	nc := testNodeConfig(t)
+	current, err := yaml.Marshal(nc)
+	require.NoError(t, err)
+
+	unversioned := bytes.Replace(current, []byte("version: 1\n"), nil, 1)
+	legacy := bytes.Replace(unversioned, []byte("checkpoint_interval:"), []byte("checkpointinterval:"), 1)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		warnings []string
+		err      string
+	}{
+		{
+			name: "current",
+			data: current,
+		},
+		{
+			name:     "unversioned",
+			data:     unversioned,
+			warnings: []string{"config is version 0, it was upgraded in memory to version 1, run 'bootstrap migrate' to upgrade it in place"},
+		},
+		{
+			name:     "legacy checkpoint interval",
+			data:     legacy,
+			warnings: []string{"config is version 0, it was upgraded in memory to version 1 (renamed mir_bootstrap.checkpointinterval to mir_bootstrap.checkpoint_interval), run 'bootstrap migrate' to upgrade it in place"},
+		},
+		{
+			// Both spellings at once is ambiguous, and so rejected.
+			name: "both checkpoint intervals",
+			data: bytes.Replace(legacy, []byte("checkpointinterval: 20"), []byte("checkpointinterval: 20\n  checkpoint_interval: 20"), 1),
+			err:  "field checkpointinterval not found",
+		},
+		{
+			name: "newer version",
+			data: bytes.Replace(current, []byte("version: 1"), []byte("version: 2"), 1),
+			err:  "config is version 2, but this build understands versions up to 1",
+		},
+		{
+			name: "malformed version",
+			data: bytes.Replace(current, []byte("version: 1"), []byte("version: one"), 1),
+			err:  "config version 'one' is not a non-negative integer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var warnings []string
+			loaded, err := LoadNodeConfig(
+				bytes.NewReader(tt.data),
+				WithWarnings(func(msg string) { warnings = append(warnings, msg) }),
+			)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, nc, loaded)
+			assert.Equal(t, tt.warnings, warnings)
+		})
+	}
+}
+
+func TestMigrateNodeConfig(t *testing.T) {
+	require.Len(t, migrations, CurrentVersion, "each version needs a migration from the one before")
+
+	nc := testNodeConfig(t)
+	nc.Version = 0
+	data, err := yaml.Marshal(nc)
+	require.NoError(t, err)
+	data = bytes.Replace(data, []byte("checkpoint_interval:"), []byte("checkpointinterval:"), 1)
+
+	m, err := MigrateNodeConfig(data)
+	require.NoError(t, err)
+	assert.True(t, m.Outdated())
+	assert.Equal(t, uint64(0), m.FromVersion)
+	assert.Equal(t, []string{"renamed mir_bootstrap.checkpointinterval to mir_bootstrap.checkpoint_interval"}, m.Changes)
+
+	// Migrating the result again is a no-op.
+	again, err := MigrateNodeConfig(m.Data)
+	require.NoError(t, err)
+	assert.False(t, again.Outdated())
+	assert.Equal(t, m.Data, again.Data)
+	assert.Empty(t, again.Changes)
+
+	migrated, err := ReadNodeConfig(m.Data)
+	require.NoError(t, err)
+	nc.Version = CurrentVersion
+	assert.Equal(t, nc, migrated)
+}
+
+func TestLoadNodeConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(nc *NodeConfig)
+		problem string
+	}{
+		{
+			name:    "duplicate node id",
+			mutate:  func(nc *NodeConfig) { nc.Nodes[2].ID = 1 },
+			problem: "nodes[2]: id 1 is already used by nodes[1]",
+		},
+		{
+			name:    "duplicate client id",
+			mutate:  func(nc *NodeConfig) { nc.Clients = append(nc.Clients, nc.Clients[0]) },
+			problem: "clients[1]: id 0 is already used by clients[0]",
+		},
+		{
+			name:    "short public key",
+			mutate:  func(nc *NodeConfig) { nc.Nodes[1].PublicKey = "abcd" },
+			problem: "nodes[1].public_key: must be 32 bytes, but is 2",
+		},
+		{
+			name:    "non-hex private key",
+			mutate:  func(nc *NodeConfig) { nc.PrivateKey = "xyz" },
+			problem: "private_key: is not valid hex",
+		},
+		{
+			name:    "mismatched private key",
+			mutate:  func(nc *NodeConfig) { nc.ID, nc.ListenAddress = 1, nc.Nodes[1].Address },
+			problem: "private_key: does not correspond to the public key of node 1",
+		},
+		{
+			name:    "listen address not in nodes",
+			mutate:  func(nc *NodeConfig) { nc.ListenAddress = "127.0.0.1:6000" },
+			problem: "listen_address: '127.0.0.1:6000' does not match",
+		},
+		{
+			name:    "admin address without port",
+			mutate:  func(nc *NodeConfig) { nc.AdminAddress = "127.0.0.1" },
+			problem: "admin_address: '127.0.0.1' is not a valid host:port",
+		},
+		{
+			name:    "negative commit window",
+			mutate:  func(nc *NodeConfig) { nc.Health.CommitWindow = -time.Second },
+			problem: "health.commit_window: must not be negative",
+		},
+		{
+			name:    "otlp endpoint without scheme",
+			mutate:  func(nc *NodeConfig) { nc.Tracing.OTLPEndpoint = "localhost:4318" },
+			problem: "tracing.otlp_endpoint: 'localhost:4318' must be an http or https URL",
+		},
+		{
+			name:    "unknown event log compression",
+			mutate:  func(nc *NodeConfig) { nc.EventLog.Compression = "fast" },
+			problem: "event_log.compression: 'fast' must be one of none, speed, or best",
+		},
+		{
+			name:    "unknown sync policy",
+			mutate:  func(nc *NodeConfig) { nc.Storage.Sync = "sometimes" },
+			problem: "storage.sync: 'sometimes' must be one of always, interval, or never",
+		},
+		{
+			name:    "unknown log level",
+			mutate:  func(nc *NodeConfig) { nc.Logging.Levels.Noise = "verbose" },
+			problem: "logging.levels.noise: 'verbose' must be one of debug, info, warn, or error",
+		},
+		{
+			name:    "unknown log encoding",
+			mutate:  func(nc *NodeConfig) { nc.Logging.Encoding = "text" },
+			problem: "logging.encoding: 'text' must be console or json",
+		},
+		{
+			name:    "too few nodes",
+			mutate:  func(nc *NodeConfig) { nc.Nodes = nc.Nodes[:3] },
+			problem: "nodes: 3 nodes are configured",
+		},
+		{
+			name:    "zero tick interval",
+			mutate:  func(nc *NodeConfig) { nc.MirRuntime.TickInterval = 0 },
+			problem: "mir_runtime.tick_interval: must be greater than 0",
+		},
+		{
+			name:    "zero batch size",
+			mutate:  func(nc *NodeConfig) { nc.MirRuntime.BatchSize = 0 },
+			problem: "mir_runtime.batch_size: must be greater than 0",
+		},
+		{
+			name:    "zero checkpoint interval",
+			mutate:  func(nc *NodeConfig) { nc.MirBootstrap.CheckpointInterval = 0 },
+			problem: "mir_bootstrap.checkpoint_interval: must be between 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc := testNodeConfig(t)
+			tt.mutate(nc)
+			_, err := loadNodeConfig(t, nc)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.problem)
+		})
+	}
+}
+
+func TestLoadNodeConfigAggregatesProblems(t *testing.T) {
+	nc := testNodeConfig(t)
+	nc.MirRuntime.BatchSize = 0
+	nc.MirBootstrap.ClientWindowSize = 0
+	nc.Clients[0].PublicKey = ""
+
+	_, err := loadNodeConfig(t, nc)
+	require.Error(t, err)
+	ve, ok := err.(*ValidationError)
+	require.True(t, ok)
+	assert.Len(t, ve.Problems, 3)
+}
+
+func TestLoadClientConfig(t *testing.T) {
+	nc := testNodeConfig(t)
+	cc := &ClientConfig{
+		Version:    CurrentVersion,
+		ID:         0,
+		PrivateKey: nc.PrivateKey,
+		Nodes:      nc.Nodes,
+	}
+
+	data, err := yaml.Marshal(cc)
+	require.NoError(t, err)
+	loaded, err := LoadClientConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, cc, loaded)
+
+	cc.PrivateKey = strings.Repeat("00", 10)
+	cc.Nodes = nil
+	data, err = yaml.Marshal(cc)
+	require.NoError(t, err)
+	_, err = LoadClientConfig(bytes.NewReader(data))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "private_key: must be 64 bytes, but is 10")
+	assert.Contains(t, err.Error(), "nodes: at least one node is required")
+}
+
+func TestLoadNodeConfigOverrides(t *testing.T) {
+	nc := testNodeConfig(t)
+	keyDir, err := ioutil.TempDir("", "config-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(keyDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(keyDir, "node.key"), []byte(nc.PrivateKey+"\n"), 0600))
+
+	expected := *nc
+	expected.PrivateKeyFile = "node.key"
+	expected.MirRuntime.BatchSize = 50
+	expected.MirRuntime.TickInterval = 500 * time.Millisecond
+	expected.Tracing.SampleRatio = 0.25
+
+	nc.PrivateKey = ""
+	nc.PrivateKeyFile = "node.key"
+	data, err := yaml.Marshal(nc)
+	require.NoError(t, err)
+
+	os.Setenv("MIRSAMPLE_TEST_MIR_RUNTIME_BATCH_SIZE", "40")
+	os.Setenv("MIRSAMPLE_TEST_MIR_RUNTIME_TICK_INTERVAL", "500ms")
+	defer os.Unsetenv("MIRSAMPLE_TEST_MIR_RUNTIME_BATCH_SIZE")
+	defer os.Unsetenv("MIRSAMPLE_TEST_MIR_RUNTIME_TICK_INTERVAL")
+
+	loaded, err := LoadNodeConfig(
+		bytes.NewReader(data),
+		WithEnv("MIRSAMPLE_TEST_"),
+		WithSets([]string{"mir_runtime.batch_size=50", "tracing.sample_ratio=0.25"}),
+		WithBaseDir(keyDir),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, &expected, loaded)
+	assert.Equal(t, "REDACTED", loaded.Redacted().PrivateKey)
+	assert.Equal(t, expected.PrivateKey, loaded.PrivateKey)
+
+	_, err = LoadNodeConfig(
+		bytes.NewReader(data),
+		WithSets([]string{"tracing.sample_ratio=half"}),
+		WithBaseDir(keyDir),
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tracing.sample_ratio: 'half' is not a valid float64")
+}
+
+func TestLoadNodeConfigEncryptedKey(t *testing.T) {
+	nc := testNodeConfig(t)
+	keyDir, err := ioutil.TempDir("", "config-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(keyDir)
+	require.NoError(t, WriteKeyFile(filepath.Join(keyDir, "node.key"), nc.PrivateKey, []byte("passphrase")))
+
+	expected := *nc
+	expected.PrivateKeyFile = "node.key"
+
+	nc.PrivateKey = ""
+	nc.PrivateKeyFile = "node.key"
+	data, err := yaml.Marshal(nc)
+	require.NoError(t, err)
+
+	load := func(passphrase string) (*NodeConfig, error) {
+		return LoadNodeConfig(
+			bytes.NewReader(data),
+			WithBaseDir(keyDir),
+			WithPassphrase(func() ([]byte, error) { return []byte(passphrase), nil }),
+		)
+	}
+
+	loaded, err := load("passphrase")
+	require.NoError(t, err)
+	assert.Equal(t, &expected, loaded)
+
+	_, err = load("wrong")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the passphrase may be incorrect")
+}
+
+func TestNodeConfigDiff(t *testing.T) {
+	nc := testNodeConfig(t)
+	other := *nc
+	other.Nodes = append([]Node(nil), nc.Nodes...)
+	other.MirRuntime.BatchSize = 50
+	other.Nodes[1].Address = "127.0.0.1:6001"
+	other.Clients = append(other.Clients, Client{ID: 1, PublicKey: nc.Clients[0].PublicKey})
+
+	assert.Empty(t, nc.Diff(nc))
+	assert.Equal(t, []Change{
+		{Key: "clients.1.id", New: "1"},
+		{Key: "clients.1.public_key", New: nc.Clients[0].PublicKey},
+		{Key: "mir_runtime.batch_size", Old: "20", New: "50"},
+		{Key: "nodes.1.address", Old: "127.0.0.1:5001", New: "127.0.0.1:6001"},
+	}, nc.Diff(&other))
+}
+
+func TestLoadNodeConfigGenesisFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(nc *NodeConfig, path string)
+		problem string
+	}{
+		{
+			name:   "matches",
+			mutate: func(nc *NodeConfig, path string) {},
+		},
+		{
+			name: "addresses may differ",
+			mutate: func(nc *NodeConfig, path string) {
+				nc.Nodes[1].Address = "10.0.0.1:5001"
+			},
+		},
+		{
+			name: "bootstrap parameters differ",
+			mutate: func(nc *NodeConfig, path string) {
+				nc.MirBootstrap.ClientWindowSize = 50
+			},
+			problem: "the mir_bootstrap of",
+		},
+		{
+			name: "clients differ",
+			mutate: func(nc *NodeConfig, path string) {
+				nc.Clients = append(nc.Clients, Client{ID: 1, PublicKey: nc.Clients[0].PublicKey})
+			},
+			problem: "the clients of",
+		},
+		{
+			name: "modified",
+			mutate: func(nc *NodeConfig, path string) {
+				data, err := ioutil.ReadFile(path)
+				require.NoError(t, err)
+				data = bytes.Replace(data, []byte("client_window_size: 100"), []byte("client_window_size: 50"), 1)
+				require.NoError(t, ioutil.WriteFile(path, data, 0644))
+				nc.MirBootstrap.ClientWindowSize = 50
+			},
+			problem: "has been modified",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "config-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			nc := testNodeConfig(t)
+			path := filepath.Join(dir, "genesis.yaml")
+			require.NoError(t, WriteGenesisFile(path, nc.Genesis()))
+
+			nc.GenesisFile = "genesis.yaml"
+			tt.mutate(nc, path)
+			data, err := yaml.Marshal(nc)
+			require.NoError(t, err)
+
+			_, err = LoadNodeConfig(bytes.NewReader(data), WithBaseDir(dir))
+			if tt.problem == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.problem)
+		})
+	}
 }
@@ -0,0 +1,63 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change describes a field whose value differs between two configs.  Old or
+// New is empty if the field, such as a list entry, exists in only one.
+type Change struct {
+	Key string
+	Old string
+	New string
+}
+
+func (c Change) String() string {
+	if c.Key == "private_key" {
+		return fmt.Sprintf("%s: changed", c.Key)
+	}
+	return fmt.Sprintf("%s: '%s' -> '%s'", c.Key, c.Old, c.New)
+}
+
+// Diff returns the changes between two node configs, ordered by key.
+func (nc *NodeConfig) Diff(other *NodeConfig) []Change {
+	return diff(nc, other)
+}
+
+func diff(a, b interface{}) []Change {
+	aValues := fieldValues(reflect.ValueOf(a).Elem())
+	bValues := fieldValues(reflect.ValueOf(b).Elem())
+
+	var changes []Change
+	for key, aValue := range aValues {
+		if bValue, ok := bValues[key]; !ok || aValue != bValue {
+			changes = append(changes, Change{Key: key, Old: aValue, New: bValue})
+		}
+	}
+	for key, bValue := range bValues {
+		if _, ok := aValues[key]; !ok {
+			changes = append(changes, Change{Key: key, New: bValue})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// fieldValues records the formatted value of every field within v, by key.
+func fieldValues(v reflect.Value) map[string]string {
+	values := map[string]string{}
+	for _, key := range fieldKeys(v, "") {
+		field, _ := lookupField(v, key)
+		values[key] = fmt.Sprint(field.Interface())
+	}
+	return values
+}
@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Genesis describes the initial state of the network, which every node must
+// agree on.  It deliberately excludes addresses and names, which may differ
+// between nodes and change over time without affecting the state.
+type Genesis struct {
+	MirBootstrap MirBootstrap  `yaml:"mir_bootstrap" json:"mir_bootstrap"`
+	Nodes        []GenesisNode `yaml:"nodes" json:"nodes"`
+	Clients      []Client      `yaml:"clients" json:"clients"`
+}
+
+type GenesisNode struct {
+	ID        uint64 `yaml:"id" json:"id"`
+	PublicKey string `yaml:"public_key" json:"public_key"`
+}
+
+// genesisFile is the on disk form of the genesis, along with its hash so
+// that operators may compare genesis files at a glance.
+type genesisFile struct {
+	Genesis `yaml:",inline"`
+	Hash    string `yaml:"hash"`
+}
+
+// Genesis returns the genesis described by the node config.
+func (nc *NodeConfig) Genesis() *Genesis {
+	return NewGenesis(nc.MirBootstrap, nc.Nodes, nc.Clients)
+}
+
+// NewGenesis returns the genesis of a network with the given parameters,
+// nodes, and clients.
+func NewGenesis(mirBootstrap MirBootstrap, nodes []Node, clients []Client) *Genesis {
+	g := &Genesis{
+		MirBootstrap: mirBootstrap,
+	}
+
+	for _, node := range nodes {
+		g.Nodes = append(g.Nodes, GenesisNode{
+			ID:        node.ID,
+			PublicKey: strings.ToLower(node.PublicKey),
+		})
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+
+	for _, client := range clients {
+		g.Clients = append(g.Clients, Client{
+			ID:        client.ID,
+			PublicKey: strings.ToLower(client.PublicKey),
+		})
+	}
+	sort.Slice(g.Clients, func(i, j int) bool { return g.Clients[i].ID < g.Clients[j].ID })
+
+	return g
+}
+
+// Hash returns the SHA-256 hash of the canonical encoding of the genesis.
+func (g *Genesis) Hash() []byte {
+	data, err := json.Marshal(g)
+	if err != nil {
+		panic(errors.WithMessage(err, "could not encode genesis"))
+	}
+
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// differences returns the sections of the genesis which differ from other.
+func (g *Genesis) differences(other *Genesis) []string {
+	var sections []string
+	if g.MirBootstrap != other.MirBootstrap {
+		sections = append(sections, "mir_bootstrap")
+	}
+	if !reflect.DeepEqual(g.Nodes, other.Nodes) {
+		sections = append(sections, "nodes")
+	}
+	if !reflect.DeepEqual(g.Clients, other.Clients) {
+		sections = append(sections, "clients")
+	}
+	return sections
+}
+
+// WriteGenesisFile writes the genesis, along with its hash, to path.
+func WriteGenesisFile(path string, g *Genesis) error {
+	out, err := yaml.Marshal(&genesisFile{
+		Genesis: *g,
+		Hash:    hex.EncodeToString(g.Hash()),
+	})
+	if err != nil {
+		return errors.WithMessage(err, "could not marshal genesis to yaml")
+	}
+
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// LoadGenesisFile reads the genesis at path, and checks that it matches the
+// hash recorded alongside it.
+func LoadGenesisFile(path string) (*Genesis, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gf := &genesisFile{}
+	if err := yaml.UnmarshalStrict(data, gf); err != nil {
+		return nil, errors.WithMessagef(err, "could not parse genesis '%s'", path)
+	}
+
+	if hash := hex.EncodeToString(gf.Genesis.Hash()); hash != gf.Hash {
+		return nil, errors.Errorf("genesis '%s' has been modified, its contents hash to %s but it records %s", path, hash, gf.Hash)
+	}
+
+	return &gf.Genesis, nil
+}
+
+// checkGenesisFile verifies that the genesis file referenced by the config,
+// if any, describes the same network as the config itself.
+func (lo *loadOpts) checkGenesisFile(ve *ValidationError, nc *NodeConfig) {
+	if nc.GenesisFile == "" {
+		return
+	}
+
+	path := nc.GenesisFile
+	if !filepath.IsAbs(path) && lo.baseDir != "" {
+		path = filepath.Join(lo.baseDir, path)
+	}
+
+	g, err := LoadGenesisFile(path)
+	if err != nil {
+		ve.addf("genesis_file: %s", err)
+		return
+	}
+
+	if sections := nc.Genesis().differences(g); len(sections) > 0 {
+		ve.addf("genesis_file: the %s of '%s' differ from the config", strings.Join(sections, ", "), path)
+	}
+}
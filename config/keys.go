@@ -0,0 +1,254 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// PassphraseEnv is the environment variable from which the passphrase
+	// for encrypted key files is read, before falling back to a prompt.
+	PassphraseEnv = "MIRSAMPLE_KEY_PASSPHRASE"
+
+	encryptedKeyType = "MIRSAMPLE ENCRYPTED PRIVATE KEY"
+
+	// The scrypt parameters recommended for interactive logins as of 2017.
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// WithPassphrase supplies the passphrase used to decrypt an encrypted key
+// file.  It is only invoked if the key file is encrypted.
+func WithPassphrase(passphrase func() ([]byte, error)) LoadOpt {
+	return func(lo *loadOpts) {
+		lo.passphrase = passphrase
+	}
+}
+
+// resolveKeyFile returns the key stored in the file at path, decrypting it
+// if necessary, or key itself if no path is given.
+func (lo *loadOpts) resolveKeyFile(ve *ValidationError, key, path string) string {
+	if path == "" {
+		return key
+	}
+
+	if key != "" {
+		ve.addf("private_key_file: only one of private_key and private_key_file may be set")
+		return key
+	}
+
+	if !filepath.IsAbs(path) && lo.baseDir != "" {
+		path = filepath.Join(lo.baseDir, path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		ve.addf("private_key_file: could not read key, %s", err)
+		return ""
+	}
+
+	if !IsEncryptedKey(data) {
+		return strings.TrimSpace(string(data))
+	}
+
+	if lo.passphrase == nil {
+		ve.addf("private_key_file: '%s' is encrypted, but no passphrase is available", path)
+		return ""
+	}
+
+	passphrase, err := lo.passphrase()
+	if err != nil {
+		ve.addf("private_key_file: %s", err)
+		return ""
+	}
+
+	key, err = DecryptKey(data, passphrase)
+	if err != nil {
+		ve.addf("private_key_file: could not decrypt '%s', %s", path, err)
+		return ""
+	}
+
+	return key
+}
+
+// WriteKeyFile writes the hex encoded private key to path, readable only by
+// its owner.  If passphrase is non-empty, the key is encrypted with it.
+func WriteKeyFile(path, key string, passphrase []byte) error {
+	data := []byte(key + "\n")
+	if len(passphrase) > 0 {
+		var err error
+		data, err = EncryptKey(key, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// EncryptKey encrypts the hex encoded private key with a key derived from
+// the passphrase via scrypt, returning it PEM encoded.
+func EncryptKey(key string, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	var nonce [24]byte
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.WithMessage(err, "could not generate salt")
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, errors.WithMessage(err, "could not generate nonce")
+	}
+
+	secret, err := deriveSecret(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type: encryptedKeyType,
+		Headers: map[string]string{
+			"KDF":   "scrypt",
+			"Salt":  hex.EncodeToString(salt),
+			"N":     strconv.Itoa(scryptN),
+			"R":     strconv.Itoa(scryptR),
+			"P":     strconv.Itoa(scryptP),
+			"Nonce": hex.EncodeToString(nonce[:]),
+		},
+		Bytes: secretbox.Seal(nil, []byte(key), &nonce, secret),
+	}), nil
+}
+
+// IsEncryptedKey reports whether the key file contents were produced by
+// EncryptKey.
+func IsEncryptedKey(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN "+encryptedKeyType))
+}
+
+// DecryptKey reverses EncryptKey, returning the hex encoded private key.
+func DecryptKey(data, passphrase []byte) (string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != encryptedKeyType {
+		return "", errors.Errorf("not an encrypted key")
+	}
+
+	if block.Headers["KDF"] != "scrypt" {
+		return "", errors.Errorf("unsupported key derivation function '%s'", block.Headers["KDF"])
+	}
+
+	var params [3]int
+	for i, name := range []string{"N", "R", "P"} {
+		var err error
+		params[i], err = strconv.Atoi(block.Headers[name])
+		if err != nil {
+			return "", errors.Errorf("invalid scrypt parameter %s", name)
+		}
+	}
+
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return "", errors.Errorf("invalid salt")
+	}
+
+	var nonce [24]byte
+	nonceBytes, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil || len(nonceBytes) != len(nonce) {
+		return "", errors.Errorf("invalid nonce")
+	}
+	copy(nonce[:], nonceBytes)
+
+	secret, err := deriveSecret(passphrase, salt, params[0], params[1], params[2])
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := secretbox.Open(nil, block.Bytes, &nonce, secret)
+	if !ok {
+		return "", errors.Errorf("decryption failed, the passphrase may be incorrect")
+	}
+
+	return string(key), nil
+}
+
+func deriveSecret(passphrase, salt []byte, n, r, p int) (*[32]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not derive key from passphrase")
+	}
+
+	var secret [32]byte
+	copy(secret[:], derived)
+	return &secret, nil
+}
+
+// ReadPassphrase returns the passphrase from the environment variable named
+// by PassphraseEnv, or else prompts for it on the terminal.
+func ReadPassphrase() ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+
+	return promptPassphrase("Enter passphrase for private key: ")
+}
+
+// ReadNewPassphrase is like ReadPassphrase, but when prompting asks for the
+// passphrase twice to guard against typos.
+func ReadNewPassphrase() ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+
+	passphrase, err := promptPassphrase("Enter passphrase to encrypt private keys: ")
+	if err != nil {
+		return nil, err
+	}
+
+	confirm, err := promptPassphrase("Confirm passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(passphrase, confirm) {
+		return nil, errors.Errorf("passphrases do not match")
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.Errorf("passphrase must not be empty")
+	}
+
+	return passphrase, nil
+}
+
+func promptPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.Errorf("no passphrase available, set %s or run from a terminal", PassphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not read passphrase")
+	}
+
+	return passphrase, nil
+}
@@ -0,0 +1,221 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// CurrentVersion is the version of the config schema understood by this
+// build, and written by bootstrap.  Configs without a version predate
+// versioning and are treated as version 0.
+const CurrentVersion = 1
+
+// migrateFunc upgrades a config document by one version, returning the
+// upgraded document and a description of each change made.
+type migrateFunc func(doc yaml.MapSlice) (yaml.MapSlice, []string)
+
+// migrations upgrade node and client configs from the version of their index
+// to the next, a nil func leaves that kind of config unchanged.
+var migrations = []struct {
+	node   migrateFunc
+	client migrateFunc
+}{
+	// 0 to 1: earlier versions of bootstrap spelt checkpoint_interval as
+	// checkpointinterval.
+	{node: renameKey("mir_bootstrap", "checkpointinterval", "checkpoint_interval")},
+}
+
+// Migration is the result of upgrading a config to the current version.
+type Migration struct {
+	// FromVersion is the version of the config before it was upgraded.
+	FromVersion uint64
+
+	// Changes describes each change made, besides setting the version.
+	Changes []string
+
+	// Data is the upgraded config, or the original config if it was
+	// already current.
+	Data []byte
+}
+
+// Outdated returns true if the config was upgraded.
+func (m *Migration) Outdated() bool {
+	return m.FromVersion < CurrentVersion
+}
+
+// MigrateNodeConfig upgrades a node config to the current version, and
+// checks that the result decodes.  Comments are not preserved.
+func MigrateNodeConfig(data []byte) (*Migration, error) {
+	m, err := migrate(data, func(i int) migrateFunc { return migrations[i].node })
+	if err != nil {
+		return nil, err
+	}
+	return m, checkDecodes(m.Data, &NodeConfig{})
+}
+
+// MigrateClientConfig upgrades a client config to the current version, and
+// checks that the result decodes.  Comments are not preserved.
+func MigrateClientConfig(data []byte) (*Migration, error) {
+	m, err := migrate(data, func(i int) migrateFunc { return migrations[i].client })
+	if err != nil {
+		return nil, err
+	}
+	return m, checkDecodes(m.Data, &ClientConfig{})
+}
+
+func migrate(data []byte, step func(i int) migrateFunc) (*Migration, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.WithMessage(err, "could not parse config")
+	}
+
+	version, err := readVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > CurrentVersion {
+		return nil, errors.Errorf("config is version %d, but this build understands versions up to %d", version, CurrentVersion)
+	}
+
+	m := &Migration{
+		FromVersion: version,
+		Data:        data,
+	}
+
+	if !m.Outdated() {
+		return m, nil
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		if f := step(int(v)); f != nil {
+			var changes []string
+			doc, changes = f(doc)
+			m.Changes = append(m.Changes, changes...)
+		}
+	}
+
+	m.Data, err = yaml.Marshal(setVersion(doc, CurrentVersion))
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not marshal migrated config")
+	}
+
+	return m, nil
+}
+
+func checkDecodes(data []byte, config interface{}) error {
+	ve := &ValidationError{}
+	if err := decodeStrict(data, config, ve); err != nil {
+		return err
+	}
+	return ve.err()
+}
+
+func readVersion(doc yaml.MapSlice) (uint64, error) {
+	for _, item := range doc {
+		if item.Key != "version" {
+			continue
+		}
+
+		version, ok := item.Value.(int)
+		if !ok || version < 0 {
+			return 0, errors.Errorf("config version '%v' is not a non-negative integer", item.Value)
+		}
+		return uint64(version), nil
+	}
+
+	return 0, nil
+}
+
+// setVersion sets the version of the document, placing it first if absent.
+func setVersion(doc yaml.MapSlice, version uint64) yaml.MapSlice {
+	for i := range doc {
+		if doc[i].Key == "version" {
+			doc[i].Value = version
+			return doc
+		}
+	}
+
+	return append(yaml.MapSlice{{Key: "version", Value: version}}, doc...)
+}
+
+// renameKey returns a migration renaming the key old, under the top level
+// key parent, to current.
+func renameKey(parent, old, current string) migrateFunc {
+	return func(doc yaml.MapSlice) (yaml.MapSlice, []string) {
+		var changes []string
+		for _, item := range doc {
+			if item.Key != parent {
+				continue
+			}
+
+			children, ok := item.Value.(yaml.MapSlice)
+			if !ok || hasKey(children, current) {
+				// Left for strict decoding to reject.
+				continue
+			}
+
+			for i := range children {
+				if children[i].Key == old {
+					children[i].Key = current
+					changes = append(changes, fmt.Sprintf("renamed %s.%s to %s.%s", parent, old, parent, current))
+				}
+			}
+		}
+		return doc, changes
+	}
+}
+
+func hasKey(m yaml.MapSlice, key string) bool {
+	for _, item := range m {
+		if item.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// WithWarnings supplies the function to report outdated config contents
+// to, by default warnings are printed to stderr.
+func WithWarnings(warn func(msg string)) LoadOpt {
+	return func(lo *loadOpts) {
+		lo.warn = warn
+	}
+}
+
+func (lo *loadOpts) warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if lo.warn == nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+		return
+	}
+	lo.warn(msg)
+}
+
+// migrate upgrades the config in memory, warning if it was outdated.
+func (lo *loadOpts) migrate(data []byte, migrate func([]byte) (*Migration, error)) ([]byte, error) {
+	m, err := migrate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Outdated() {
+		changes := ""
+		if len(m.Changes) > 0 {
+			changes = " (" + strings.Join(m.Changes, ", ") + ")"
+		}
+		lo.warnf("config is version %d, it was upgraded in memory to version %d%s, run 'bootstrap migrate' to upgrade it in place", m.FromVersion, CurrentVersion, changes)
+	}
+
+	return m.Data, nil
+}
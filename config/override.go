@@ -0,0 +1,245 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// NodeEnvPrefix is the prefix of environment variables overriding node
+	// config fields, e.g. MIRSAMPLE_MIR_RUNTIME_BATCH_SIZE.
+	NodeEnvPrefix = "MIRSAMPLE_"
+
+	// ClientEnvPrefix is the prefix of environment variables overriding
+	// client config fields, e.g. MIRSAMPLE_CLIENT_PRIVATE_KEY_FILE.  It
+	// differs from the node prefix so that a node and client may share an
+	// environment.
+	ClientEnvPrefix = "MIRSAMPLE_CLIENT_"
+
+	redacted = "REDACTED"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type loadOpts struct {
+	envPrefix  string
+	sets       []string
+	baseDir    string
+	passphrase func() ([]byte, error)
+	warn       func(msg string)
+}
+
+// LoadOpt modifies how a config is assembled from the YAML file and the
+// layers of overrides on top of it.
+type LoadOpt func(*loadOpts)
+
+// WithEnv causes each field to be overridden by the environment variable
+// named by the prefix followed by the field's key, upper cased, and with
+// dots replaced by underscores.  For instance with the prefix "MIRSAMPLE_",
+// MIRSAMPLE_NODES_1_ADDRESS overrides the key nodes.1.address.
+func WithEnv(prefix string) LoadOpt {
+	return func(lo *loadOpts) {
+		lo.envPrefix = prefix
+	}
+}
+
+// WithSets overrides fields with values of the form key=value, where the key
+// is the dot separated path of YAML field names and list indices to the
+// field, such as mir_runtime.batch_size.  Sets are applied after, and so take
+// precedence over, environment variables.
+func WithSets(sets []string) LoadOpt {
+	return func(lo *loadOpts) {
+		lo.sets = sets
+	}
+}
+
+// WithBaseDir causes relative key and genesis file paths to be resolved
+// against dir, typically the directory containing the config file, rather
+// than the working directory.
+func WithBaseDir(dir string) LoadOpt {
+	return func(lo *loadOpts) {
+		lo.baseDir = dir
+	}
+}
+
+// resolvePath resolves a relative path against the base directory, if any.
+func (lo *loadOpts) resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) || lo.baseDir == "" {
+		return path
+	}
+	return filepath.Join(lo.baseDir, path)
+}
+
+// applyOverrides applies the environment and then the sets to config, which
+// must be a pointer to a struct, recording any problems in ve.
+func (lo *loadOpts) applyOverrides(config interface{}, ve *ValidationError) {
+	if lo.envPrefix != "" {
+		for _, key := range fieldKeys(reflect.ValueOf(config).Elem(), "") {
+			name := lo.envPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+
+			if err := setField(config, key, value); err != nil {
+				ve.addf("%s: %s", name, err)
+			}
+		}
+	}
+
+	for _, set := range lo.sets {
+		parts := strings.SplitN(set, "=", 2)
+		if len(parts) != 2 {
+			ve.addf("--set '%s': must be of the form key=value", set)
+			continue
+		}
+
+		if err := setField(config, parts[0], parts[1]); err != nil {
+			ve.addf("--set %s: %s", parts[0], err)
+		}
+	}
+}
+
+// fieldKeys returns the key of every settable field within v.
+func fieldKeys(v reflect.Value, prefix string) []string {
+	switch {
+	case v.Type() == durationType:
+		return []string{prefix}
+	case v.Kind() == reflect.Struct:
+		var keys []string
+		for i := 0; i < v.NumField(); i++ {
+			name := yamlName(v.Type().Field(i))
+			if name == "" {
+				continue
+			}
+			keys = append(keys, fieldKeys(v.Field(i), joinKey(prefix, name))...)
+		}
+		return keys
+	case v.Kind() == reflect.Slice:
+		var keys []string
+		for i := 0; i < v.Len(); i++ {
+			keys = append(keys, fieldKeys(v.Index(i), joinKey(prefix, strconv.Itoa(i)))...)
+		}
+		return keys
+	default:
+		return []string{prefix}
+	}
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func yamlName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// lookupField returns the field within v addressed by key.
+func lookupField(v reflect.Value, key string) (reflect.Value, error) {
+	for _, part := range strings.Split(key, ".") {
+		switch {
+		case v.Kind() == reflect.Struct && v.Type() != durationType:
+			found := false
+			for i := 0; i < v.NumField(); i++ {
+				if yamlName(v.Type().Field(i)) == part {
+					v = v.Field(i)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return reflect.Value{}, errors.Errorf("unknown field '%s'", part)
+			}
+		case v.Kind() == reflect.Slice:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= v.Len() {
+				return reflect.Value{}, errors.Errorf("'%s' is not an index of the %d configured entries", part, v.Len())
+			}
+			v = v.Index(i)
+		default:
+			return reflect.Value{}, errors.Errorf("unknown field '%s'", part)
+		}
+	}
+
+	return v, nil
+}
+
+// setField parses value into the field of config addressed by key.
+func setField(config interface{}, key, value string) error {
+	v, err := lookupField(reflect.ValueOf(config).Elem(), key)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Errorf("'%s' is not a valid bool", value)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Uint32, v.Kind() == reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return errors.Errorf("'%s' is not a valid %s", value, v.Type())
+		}
+		v.SetUint(u)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.Errorf("'%s' is not a valid %s", value, v.Type())
+		}
+		v.SetFloat(f)
+	default:
+		return errors.Errorf("is a %s and cannot be set directly, set its fields instead", v.Kind())
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the config which is safe to print, with the
+// private key removed.
+func (nc *NodeConfig) Redacted() *NodeConfig {
+	c := *nc
+	if c.PrivateKey != "" {
+		c.PrivateKey = redacted
+	}
+	return &c
+}
+
+// Redacted returns a copy of the config which is safe to print, with the
+// private key removed.
+func (cc *ClientConfig) Redacted() *ClientConfig {
+	c := *cc
+	if c.PrivateKey != "" {
+		c.PrivateKey = redacted
+	}
+	return &c
+}
@@ -0,0 +1,358 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/perlin-network/noise"
+	"github.com/pkg/errors"
+)
+
+// ValidationError collects every problem found while loading a config, so
+// that they may all be fixed at once rather than one per attempt.
+type ValidationError struct {
+	Problems []string
+}
+
+func (ve *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config, %d problem(s):\n  %s", len(ve.Problems), strings.Join(ve.Problems, "\n  "))
+}
+
+func (ve *ValidationError) addf(format string, args ...interface{}) {
+	ve.Problems = append(ve.Problems, fmt.Sprintf(format, args...))
+}
+
+// err returns the ValidationError if any problems were found, and nil otherwise.
+func (ve *ValidationError) err() error {
+	if len(ve.Problems) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// Validate checks that the node config describes a network which can be
+// started, returning a *ValidationError describing each problem found.
+func (nc *NodeConfig) Validate() error {
+	ve := &ValidationError{}
+	nc.validate(ve)
+	return ve.err()
+}
+
+func (nc *NodeConfig) validate(ve *ValidationError) {
+	pubKeys := validateNodes(ve, nc.Nodes)
+
+	f := (len(nc.Nodes) - 1) / 3
+	if f < 1 {
+		ve.addf("nodes: %d nodes are configured, but at least 4 (3f+1 for f=1) are required", len(nc.Nodes))
+	}
+
+	// The network state is derived assuming the nodes and clients are
+	// numbered contiguously from zero, see initialNetworkState.
+	var self *Node
+	for i, node := range nc.Nodes {
+		if node.ID != uint64(i) {
+			ve.addf("nodes[%d]: id %d is out of order, node ids must be assigned contiguously from 0", i, node.ID)
+		}
+		if node.ID == nc.ID && self == nil {
+			self = &nc.Nodes[i]
+		}
+	}
+
+	privKey, ok := validatePrivateKey(ve, nc.PrivateKey, nc.PrivateKeyFile)
+
+	switch {
+	case self == nil:
+		ve.addf("id: node %d is not present in nodes", nc.ID)
+	default:
+		if nc.ListenAddress != "" && nc.ListenAddress != self.Address {
+			ve.addf("listen_address: '%s' does not match the address '%s' of node %d in nodes", nc.ListenAddress, self.Address, nc.ID)
+		}
+
+		if pubKey, found := pubKeys[self.ID]; ok && found && !bytes.Equal(pubKey, publicKeyOf(privKey)) {
+			ve.addf("private_key: does not correspond to the public key of node %d in nodes", nc.ID)
+		}
+	}
+
+	if nc.ListenAddress == "" {
+		ve.addf("listen_address: must be set")
+	}
+
+	if nc.BindAddress != "" {
+		host, err := splitAddress(nc.BindAddress)
+		switch {
+		case err != nil:
+			ve.addf("bind_address: %s", err)
+		case host != "" && net.ParseIP(host) == nil:
+			ve.addf("bind_address: host '%s' must be an IP address", host)
+		}
+	}
+
+	if nc.AdminAddress != "" {
+		if _, err := splitAddress(nc.AdminAddress); err != nil {
+			ve.addf("admin_address: %s", err)
+		}
+	}
+
+	clientIDs := map[uint64]int{}
+	for i, client := range nc.Clients {
+		if prev, ok := clientIDs[client.ID]; ok {
+			ve.addf("clients[%d]: id %d is already used by clients[%d]", i, client.ID, prev)
+		} else {
+			clientIDs[client.ID] = i
+		}
+		if client.ID != uint64(i) {
+			ve.addf("clients[%d]: id %d is out of order, client ids must be assigned contiguously from 0", i, client.ID)
+		}
+		validatePublicKey(ve, fmt.Sprintf("clients[%d].public_key", i), client.PublicKey)
+	}
+
+	nc.Logging.validate(ve)
+	nc.Health.validate(ve)
+	nc.Tracing.validate(ve)
+	nc.EventLog.validate(ve)
+	nc.Storage.validate(ve)
+	nc.MirRuntime.validate(ve)
+	nc.MirBootstrap.validate(ve)
+}
+
+func (l *Logging) validate(ve *ValidationError) {
+	levels := []struct {
+		key   string
+		level string
+	}{
+		{key: "logging.level", level: l.Level},
+		{key: "logging.levels.mir", level: l.Levels.Mir},
+		{key: "logging.levels.noise", level: l.Levels.Noise},
+		{key: "logging.levels.app", level: l.Levels.App},
+		{key: "logging.levels.transport", level: l.Levels.Transport},
+	}
+
+	for _, level := range levels {
+		switch level.level {
+		case "", "debug", "info", "warn", "error":
+		default:
+			ve.addf("%s: '%s' must be one of debug, info, warn, or error", level.key, level.level)
+		}
+	}
+
+	switch l.Encoding {
+	case "", "console", "json":
+	default:
+		ve.addf("logging.encoding: '%s' must be console or json", l.Encoding)
+	}
+}
+
+func (h *Health) validate(ve *ValidationError) {
+	if h.StallTimeout < 0 {
+		ve.addf("health.stall_timeout: must not be negative")
+	}
+	if h.CommitWindow < 0 {
+		ve.addf("health.commit_window: must not be negative")
+	}
+}
+
+func (t *Tracing) validate(ve *ValidationError) {
+	if t.OTLPEndpoint != "" {
+		u, err := url.Parse(t.OTLPEndpoint)
+		switch {
+		case err != nil:
+			ve.addf("tracing.otlp_endpoint: %s", err)
+		case u.Scheme != "http" && u.Scheme != "https", u.Host == "":
+			ve.addf("tracing.otlp_endpoint: '%s' must be an http or https URL", t.OTLPEndpoint)
+		}
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		ve.addf("tracing.sample_ratio: must be between 0 and 1")
+	}
+}
+
+func (el *EventLog) validate(ve *ValidationError) {
+	if el.RotateInterval < 0 {
+		ve.addf("event_log.rotate_interval: must not be negative")
+	}
+
+	switch el.Compression {
+	case "", "none", "speed", "best":
+	default:
+		ve.addf("event_log.compression: '%s' must be one of none, speed, or best", el.Compression)
+	}
+
+	switch el.OnRestart {
+	case "", "append", "rotate":
+	default:
+		ve.addf("event_log.on_restart: '%s' must be append or rotate", el.OnRestart)
+	}
+}
+
+func (st *Storage) validate(ve *ValidationError) {
+	switch st.Backend {
+	case "", "disk", "memory":
+	default:
+		ve.addf("storage.backend: '%s' must be disk or memory", st.Backend)
+	}
+
+	switch st.Sync {
+	case "", "always", "interval", "never":
+	default:
+		ve.addf("storage.sync: '%s' must be one of always, interval, or never", st.Sync)
+	}
+
+	if st.SyncInterval < 0 {
+		ve.addf("storage.sync_interval: must not be negative")
+	}
+}
+
+func (mr *MirRuntime) validate(ve *ValidationError) {
+	if mr.TickInterval <= 0 {
+		ve.addf("mir_runtime.tick_interval: must be greater than 0")
+	}
+	if mr.HeartbeatTicks == 0 {
+		ve.addf("mir_runtime.heartbeat_ticks: must be greater than 0")
+	}
+	if mr.SuspectTicks <= mr.HeartbeatTicks {
+		ve.addf("mir_runtime.suspect_ticks: must be greater than heartbeat_ticks (%d)", mr.HeartbeatTicks)
+	}
+	if mr.NewEpochTimeoutTicks <= 1 {
+		ve.addf("mir_runtime.epoch_change_timeout_ticks: must be greater than 1")
+	}
+	if mr.BatchSize == 0 {
+		ve.addf("mir_runtime.batch_size: must be greater than 0")
+	}
+	if mr.BufferSize == 0 {
+		ve.addf("mir_runtime.buffer_size: must be greater than 0")
+	}
+}
+
+func (mb *MirBootstrap) validate(ve *ValidationError) {
+	// Mir stores these as int32 in the network state.
+	if mb.NumberOfBuckets == 0 || mb.NumberOfBuckets > math.MaxInt32 {
+		ve.addf("mir_bootstrap.number_of_buckets: must be between 1 and %d", math.MaxInt32)
+	}
+	if mb.CheckpointInterval == 0 || mb.CheckpointInterval > math.MaxInt32 {
+		ve.addf("mir_bootstrap.checkpoint_interval: must be between 1 and %d", math.MaxInt32)
+	}
+	if mb.ClientWindowSize == 0 {
+		ve.addf("mir_bootstrap.client_window_size: must be greater than 0")
+	}
+}
+
+// Validate checks that the client config may be used to connect to the
+// network, returning a *ValidationError describing each problem found.
+func (cc *ClientConfig) Validate() error {
+	ve := &ValidationError{}
+	cc.validate(ve)
+	return ve.err()
+}
+
+func (cc *ClientConfig) validate(ve *ValidationError) {
+	validatePrivateKey(ve, cc.PrivateKey, cc.PrivateKeyFile)
+	cc.Logging.validate(ve)
+	cc.Tracing.validate(ve)
+	validateNodes(ve, cc.Nodes)
+	if len(cc.Nodes) == 0 {
+		ve.addf("nodes: at least one node is required")
+	}
+}
+
+// validateNodes checks the entries of a node list, returning the decoded
+// public key for each node whose key is valid.
+func validateNodes(ve *ValidationError, nodes []Node) map[uint64][]byte {
+	pubKeys := map[uint64][]byte{}
+	ids := map[uint64]int{}
+	addresses := map[string]int{}
+	names := map[string]int{}
+	for i, node := range nodes {
+		if prev, ok := ids[node.ID]; ok {
+			ve.addf("nodes[%d]: id %d is already used by nodes[%d]", i, node.ID, prev)
+		} else {
+			ids[node.ID] = i
+		}
+
+		if node.Name != "" {
+			if prev, ok := names[node.Name]; ok {
+				ve.addf("nodes[%d].name: '%s' is already used by nodes[%d]", i, node.Name, prev)
+			} else {
+				names[node.Name] = i
+			}
+		}
+
+		if _, err := splitAddress(node.Address); err != nil {
+			ve.addf("nodes[%d].address: %s", i, err)
+		} else if prev, ok := addresses[node.Address]; ok {
+			ve.addf("nodes[%d].address: '%s' is already used by nodes[%d]", i, node.Address, prev)
+		} else {
+			addresses[node.Address] = i
+		}
+
+		if pubKey, ok := validatePublicKey(ve, fmt.Sprintf("nodes[%d].public_key", i), node.PublicKey); ok {
+			pubKeys[node.ID] = pubKey
+		}
+	}
+	return pubKeys
+}
+
+// splitAddress checks that the address is of the form host:port, with an
+// IPv6 host enclosed in brackets, returning the host.
+func splitAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", errors.Errorf("'%s' is not a valid host:port, %s", address, err)
+	}
+
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return "", errors.Errorf("'%s' does not have a valid port", address)
+	}
+
+	return host, nil
+}
+
+func validatePublicKey(ve *ValidationError, field, key string) ([]byte, bool) {
+	return validateHexKey(ve, field, key, noise.SizePublicKey)
+}
+
+func validatePrivateKey(ve *ValidationError, key, keyFile string) ([]byte, bool) {
+	if key == "" && keyFile != "" {
+		// The problem with the key file was reported when resolving it.
+		return nil, false
+	}
+
+	if key == "" {
+		ve.addf("private_key: no private key is configured, set private_key or private_key_file")
+		return nil, false
+	}
+	return validateHexKey(ve, "private_key", key, noise.SizePrivateKey)
+}
+
+func validateHexKey(ve *ValidationError, field, key string, size int) ([]byte, bool) {
+	decoded, err := hex.DecodeString(key)
+	if err != nil {
+		ve.addf("%s: is not valid hex, %s", field, err)
+		return nil, false
+	}
+
+	if len(decoded) != size {
+		ve.addf("%s: must be %d bytes, but is %d", field, size, len(decoded))
+		return nil, false
+	}
+
+	return decoded, true
+}
+
+func publicKeyOf(privKey []byte) []byte {
+	var key noise.PrivateKey
+	copy(key[:], privKey)
+	pubKey := key.Public()
+	return pubKey[:]
+}
@@ -0,0 +1,309 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger-labs/mirbft/pkg/eventlog"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// EventLogFile is the name of the event log within a node's run directory.
+const EventLogFile = "eventlog.gz"
+
+// defaultEventLogMaxSizeMB is the size at which the event log is rotated if
+// the config does not specify one.
+const defaultEventLogMaxSizeMB = 100
+
+// segmentTimeFormat names each rotated segment by when it was rotated, so
+// that the segments sort in the order they were written.
+const segmentTimeFormat = "20060102T150405.000000000"
+
+// eventLog records every state machine event to a gzip stream at path,
+// rotating it into segments alongside path as configured.  Each event is
+// stamped with the Unix time in milliseconds at which it was recorded, so
+// that times remain comparable across segments and restarts.
+type eventLog struct {
+	// size is the size of the file, updated atomically as the recorder
+	// writes to it.
+	size int64
+
+	path   string
+	nodeID uint64
+	config config.EventLog
+
+	file     *os.File
+	openedAt time.Time
+	recorder *eventlog.Recorder
+}
+
+// openEventLog opens the event log at path, appending to it or first
+// rotating it out, as the config specifies.  A log left incomplete, as the
+// node did not stop cleanly, is always rotated out, as readers could not
+// read past the incomplete stream to any events appended.
+func openEventLog(logger *zap.SugaredLogger, path string, nodeID uint64, c config.EventLog) (*eventLog, error) {
+	el := &eventLog{
+		path:   path,
+		nodeID: nodeID,
+		config: c,
+	}
+
+	rotate := c.OnRestart == "rotate"
+	if !rotate {
+		if err := checkEventLog(path); err != nil {
+			logger.Warnw("Event log is incomplete, beginning a new segment rather than appending", "path", path, "error", err)
+			rotate = true
+		}
+	}
+
+	if rotate {
+		if err := el.rotateFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := el.open(); err != nil {
+		return nil, err
+	}
+
+	return el, nil
+}
+
+// open begins a new gzip member at the end of the file, which readers treat
+// as a continuation of any members already written.
+func (el *eventLog) open() error {
+	file, err := os.OpenFile(el.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.WithMessage(err, "could not open event log file")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.WithMessage(err, "could not stat event log file")
+	}
+
+	el.file = file
+	atomic.StoreInt64(&el.size, info.Size())
+	el.openedAt = time.Now()
+	el.recorder = eventlog.NewRecorder(
+		el.nodeID,
+		&countingWriter{writer: file, count: &el.size},
+		eventlog.CompressionLevelOpt(compressionLevel(el.config.Compression)),
+		eventlog.TimeSourceOpt(func() int64 {
+			return time.Now().UnixNano() / int64(time.Millisecond)
+		}),
+	)
+
+	return nil
+}
+
+func (el *eventLog) Intercept(event *state.Event) error {
+	if el.rotationDue() {
+		if err := el.rotate(); err != nil {
+			return errors.WithMessage(err, "could not rotate event log")
+		}
+	}
+
+	return el.recorder.Intercept(event)
+}
+
+func (el *eventLog) rotationDue() bool {
+	maxSize := int64(el.config.MaxSizeMB)
+	if maxSize == 0 {
+		maxSize = defaultEventLogMaxSizeMB
+	}
+
+	if atomic.LoadInt64(&el.size) >= maxSize*1024*1024 {
+		return true
+	}
+
+	return el.config.RotateInterval > 0 && time.Since(el.openedAt) >= el.config.RotateInterval
+}
+
+// rotate completes the current segment, and begins a new one.  Events are
+// only intercepted from the state machine's goroutine, so none are recorded
+// while the log rotates.
+func (el *eventLog) rotate() error {
+	if err := el.close(); err != nil {
+		return err
+	}
+
+	if err := el.rotateFile(); err != nil {
+		return err
+	}
+
+	return el.open()
+}
+
+// rotateFile renames the file at path to a new segment, if it is not empty,
+// and removes the oldest segments beyond those retained.
+func (el *eventLog) rotateFile() error {
+	info, err := os.Stat(el.path)
+	if os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not stat event log file")
+	}
+
+	ext := filepath.Ext(el.path)
+	segment := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(el.path, ext), time.Now().UTC().Format(segmentTimeFormat), ext)
+	if err := os.Rename(el.path, segment); err != nil {
+		return errors.WithMessage(err, "could not rename event log to segment")
+	}
+
+	if el.config.MaxSegments == 0 {
+		return nil
+	}
+
+	segments, err := rotatedSegments(el.path)
+	if err != nil {
+		return err
+	}
+
+	for len(segments) > int(el.config.MaxSegments) {
+		if err := os.Remove(segments[0]); err != nil {
+			return errors.WithMessage(err, "could not remove event log segment")
+		}
+		segments = segments[1:]
+	}
+
+	return nil
+}
+
+// close stops the recorder, which completes the gzip member, and closes the
+// file.
+func (el *eventLog) close() error {
+	err := el.recorder.Stop()
+	if closeErr := el.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not complete event log")
+	}
+	return nil
+}
+
+// flush completes the gzip member being written, so that the file may be
+// read in its entirety, and begins another.
+func (el *eventLog) flush() error {
+	openedAt := el.openedAt
+	if err := el.close(); err != nil {
+		return err
+	}
+	if err := el.open(); err != nil {
+		return err
+	}
+	el.openedAt = openedAt
+	return nil
+}
+
+func (el *eventLog) Close() error {
+	return el.close()
+}
+
+// checkEventLog reads through the gzip stream at path, if any, returning an
+// error if it is incomplete or corrupt.
+func checkEventLog(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+
+	gzReader, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(ioutil.Discard, gzReader)
+	return err
+}
+
+// EventLogSegments returns the files making up the event log at path, in the
+// order they were written: the rotated segments, oldest first, followed by
+// the file at path itself, if it exists.
+func EventLogSegments(path string) ([]string, error) {
+	segments, err := rotatedSegments(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		segments = append(segments, path)
+	}
+
+	return segments, nil
+}
+
+func rotatedSegments(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	segments, err := filepath.Glob(glob(strings.TrimSuffix(path, ext)) + "-*" + glob(ext))
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not list event log segments")
+	}
+
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// glob escapes the pattern characters within a path.
+func glob(path string) string {
+	replacer := strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+	return replacer.Replace(path)
+}
+
+func compressionLevel(compression string) int {
+	switch compression {
+	case "none":
+		return gzip.NoCompression
+	case "speed":
+		return gzip.BestSpeed
+	case "best":
+		return gzip.BestCompression
+	default:
+		return gzip.DefaultCompression
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	writer io.Writer
+	count  *int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	atomic.AddInt64(cw.count, int64(n))
+	return n, err
+}
@@ -0,0 +1,126 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/mirbft/pkg/eventlog"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestEventLogRestart(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.EventLog
+
+		// corrupt truncates the log written before the restart.
+		corrupt bool
+
+		// segments is the number of events within each segment.
+		segments []int
+	}{
+		{
+			name:     "append",
+			segments: []int{4},
+		},
+		{
+			name:     "rotate",
+			config:   config.EventLog{OnRestart: "rotate", Compression: "best"},
+			segments: []int{2, 2},
+		},
+		{
+			name:     "append after crash",
+			corrupt:  true,
+			segments: []int{0, 2},
+		},
+		{
+			name:     "rotate by interval",
+			config:   config.EventLog{RotateInterval: time.Nanosecond, MaxSegments: 2, Compression: "none"},
+			segments: []int{1, 1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "eventlog.gz")
+
+			for run := 0; run < 2; run++ {
+				el, err := openEventLog(zap.NewNop().Sugar(), path, 1, tt.config)
+				require.NoError(t, err)
+				for i := 0; i < 2; i++ {
+					require.NoError(t, el.Intercept(testEvent(uint64(run*2+i))))
+				}
+				require.NoError(t, el.Close())
+
+				if tt.corrupt && run == 0 {
+					info, err := os.Stat(path)
+					require.NoError(t, err)
+					require.NoError(t, os.Truncate(path, info.Size()-4))
+				}
+			}
+
+			segments, err := EventLogSegments(path)
+			require.NoError(t, err)
+			require.Len(t, segments, len(tt.segments))
+			assert.Equal(t, path, segments[len(segments)-1])
+
+			// The events read back in order, ending with the last.
+			var ids []uint64
+			for i, segment := range segments {
+				segmentIDs := readTestEvents(t, segment)
+				assert.Len(t, segmentIDs, tt.segments[i], segment)
+				ids = append(ids, segmentIDs...)
+			}
+			for i := 1; i < len(ids); i++ {
+				assert.Equal(t, ids[i-1]+1, ids[i])
+			}
+			assert.Equal(t, uint64(3), ids[len(ids)-1])
+		})
+	}
+}
+
+func testEvent(id uint64) *state.Event {
+	return &state.Event{
+		Type: &state.Event_Initialize{
+			Initialize: &state.EventInitialParameters{Id: id},
+		},
+	}
+}
+
+// readTestEvents returns the IDs of the events in a segment, or none if the
+// segment is incomplete.
+func readTestEvents(t *testing.T, path string) []uint64 {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	reader, err := eventlog.NewReader(f)
+	require.NoError(t, err)
+
+	var ids []uint64
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			return nil
+		}
+		assert.Equal(t, uint64(1), event.NodeId)
+		assert.InDelta(t, time.Now().UnixNano()/int64(time.Millisecond), event.Time, float64(time.Minute/time.Millisecond))
+		ids = append(ids, event.StateEvent.GetInitialize().Id)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"sync"
+
+	"github.com/hyperledger-labs/mirbft"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
+	"github.com/pkg/errors"
+)
+
+var errFenced = errors.Errorf("mir node has been replaced")
+
+// fence guards the WAL, request store, and application shared by successive
+// Mir nodes within the process.  When Mir processing is restarted to apply new
+// runtime parameters, the worker go routines of the previous node may briefly
+// outlive it, so its fence is closed before the next node recovers from the
+// WAL, ensuring those go routines can no longer modify any of them.
+type fence struct {
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// do invokes fn unless the fence has been closed.
+func (f *fence) do(fn func() error) error {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if f.closed {
+		return errFenced
+	}
+	return fn()
+}
+
+// close waits for any operations in progress, and prevents any further ones.
+func (f *fence) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+}
+
+// pause waits for any operations in progress, and holds off further ones
+// until resume is called.  It returns false, without pausing, if the fence
+// has been closed.
+func (f *fence) pause() bool {
+	f.mutex.Lock()
+	if f.closed {
+		f.mutex.Unlock()
+		return false
+	}
+	return true
+}
+
+// resume allows the operations held off by pause.
+func (f *fence) resume() {
+	f.mutex.Unlock()
+}
+
+// processorConfig wraps the components of the processor config in the fence.
+func (f *fence) processorConfig(pc *mirbft.ProcessorConfig) *mirbft.ProcessorConfig {
+	fenced := *pc
+	fenced.App = &fencedApp{fence: f, app: pc.App}
+	fenced.WAL = &fencedWAL{fence: f, wal: pc.WAL}
+	fenced.RequestStore = &fencedRequestStore{fence: f, reqStore: pc.RequestStore}
+	fenced.Interceptor = &fencedInterceptor{fence: f, interceptor: pc.Interceptor}
+	return &fenced
+}
+
+type fencedWAL struct {
+	fence *fence
+	wal   processor.WAL
+}
+
+func (fw *fencedWAL) Write(index uint64, entry *msgs.Persistent) error {
+	return fw.fence.do(func() error { return fw.wal.Write(index, entry) })
+}
+
+func (fw *fencedWAL) Truncate(index uint64) error {
+	return fw.fence.do(func() error { return fw.wal.Truncate(index) })
+}
+
+func (fw *fencedWAL) Sync() error {
+	return fw.fence.do(fw.wal.Sync)
+}
+
+func (fw *fencedWAL) LoadAll(forEach func(index uint64, p *msgs.Persistent)) error {
+	return fw.fence.do(func() error { return fw.wal.LoadAll(forEach) })
+}
+
+type fencedRequestStore struct {
+	fence    *fence
+	reqStore processor.RequestStore
+}
+
+func (frs *fencedRequestStore) GetAllocation(clientID, reqNo uint64) ([]byte, error) {
+	var digest []byte
+	err := frs.fence.do(func() error {
+		var err error
+		digest, err = frs.reqStore.GetAllocation(clientID, reqNo)
+		return err
+	})
+	return digest, err
+}
+
+func (frs *fencedRequestStore) PutAllocation(clientID, reqNo uint64, digest []byte) error {
+	return frs.fence.do(func() error { return frs.reqStore.PutAllocation(clientID, reqNo, digest) })
+}
+
+func (frs *fencedRequestStore) GetRequest(requestAck *msgs.RequestAck) ([]byte, error) {
+	var data []byte
+	err := frs.fence.do(func() error {
+		var err error
+		data, err = frs.reqStore.GetRequest(requestAck)
+		return err
+	})
+	return data, err
+}
+
+func (frs *fencedRequestStore) PutRequest(requestAck *msgs.RequestAck, data []byte) error {
+	return frs.fence.do(func() error { return frs.reqStore.PutRequest(requestAck, data) })
+}
+
+func (frs *fencedRequestStore) Sync() error {
+	return frs.fence.do(frs.reqStore.Sync)
+}
+
+type fencedApp struct {
+	fence *fence
+	app   processor.App
+}
+
+func (fa *fencedApp) Apply(entry *msgs.QEntry) error {
+	return fa.fence.do(func() error { return fa.app.Apply(entry) })
+}
+
+func (fa *fencedApp) Snap(networkConfig *msgs.NetworkState_Config, clients []*msgs.NetworkState_Client) ([]byte, []*msgs.Reconfiguration, error) {
+	var value []byte
+	var reconfigurations []*msgs.Reconfiguration
+	err := fa.fence.do(func() error {
+		var err error
+		value, reconfigurations, err = fa.app.Snap(networkConfig, clients)
+		return err
+	})
+	return value, reconfigurations, err
+}
+
+func (fa *fencedApp) TransferTo(seqNo uint64, snap []byte) (*msgs.NetworkState, error) {
+	var networkState *msgs.NetworkState
+	err := fa.fence.do(func() error {
+		var err error
+		networkState, err = fa.app.TransferTo(seqNo, snap)
+		return err
+	})
+	return networkState, err
+}
+
+type fencedInterceptor struct {
+	fence       *fence
+	interceptor processor.EventInterceptor
+}
+
+func (fi *fencedInterceptor) Intercept(event *state.Event) error {
+	return fi.fence.do(func() error { return fi.interceptor.Intercept(event) })
+}
@@ -0,0 +1,642 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// StorageProblem is an inconsistency found in the WAL or request store.
+type StorageProblem struct {
+	// Index is the index of the WAL entry concerned, or zero if the problem
+	// concerns no one entry.
+	Index uint64
+
+	// Location is the segment file and byte offset of the entry, if known.
+	Location string
+
+	Description string
+}
+
+func (p StorageProblem) String() string {
+	var where []string
+	if p.Index != 0 {
+		where = append(where, fmt.Sprintf("WAL index %d", p.Index))
+	}
+	if p.Location != "" {
+		where = append(where, p.Location)
+	}
+	if len(where) == 0 {
+		return p.Description
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(where, ", "), p.Description)
+}
+
+// StorageReport is the outcome of checking the WAL and request store.
+type StorageReport struct {
+	// FirstIndex and LastIndex are those of the WAL entries read.
+	FirstIndex uint64
+	LastIndex  uint64
+
+	Checkpoints int
+
+	// Requests is the number of requests referenced by the entries, each of
+	// which was looked up in the request store.
+	Requests int
+
+	Problems []StorageProblem
+
+	// LastGood is the index of the last entry before the first problem, or
+	// zero if there is none.
+	LastGood uint64
+
+	// RepairIndex is the index of the last entry a repair keeps, that of the
+	// last stable checkpoint at or before LastGood, along with any epoch
+	// entries directly following it, and RepairSeqNo is the checkpoint's
+	// sequence number.  A checkpoint is known to be stable if it is that
+	// at sequence number zero, or the node kept a snapshot of it.
+	// RepairIndex is zero if there is no such checkpoint, or the entries up
+	// to it record no epoch for the node to resume.
+	RepairIndex uint64
+	RepairSeqNo uint64
+
+	// RepairEpoch is the epoch after any the entries read record, to which a
+	// repaired node changes rather than resume the epoch it was in.
+	RepairEpoch uint64
+
+	// Transfer is the latest snapshot the node kept, if it is of a later
+	// checkpoint than that kept by a repair, to which the repaired node
+	// then transfers its state.
+	Transfer *Snapshot
+
+	// walDir and ends, the positions just past each entry read by index
+	// less the first index, are known only if the WAL was read from its
+	// segment files.
+	walDir string
+	ends   []walPosition
+}
+
+// walPosition is a byte offset within a WAL segment file.
+type walPosition struct {
+	segment string
+	offset  int64
+}
+
+// Entries returns the number of WAL entries read.
+func (r *StorageReport) Entries() int {
+	if r.FirstIndex == 0 {
+		return 0
+	}
+	return int(r.LastIndex - r.FirstIndex + 1)
+}
+
+// Err returns an error describing the first few problems, or nil if there
+// are none.
+func (r *StorageReport) Err() error {
+	const shown = 3
+	switch {
+	case len(r.Problems) == 0:
+		return nil
+	case len(r.Problems) <= shown:
+		return errors.Errorf("storage is inconsistent, %s", joinProblems(r.Problems))
+	default:
+		return errors.Errorf("storage is inconsistent, %s, and %d more problems", joinProblems(r.Problems[:shown]), len(r.Problems)-shown)
+	}
+}
+
+func joinProblems(problems []StorageProblem) string {
+	descriptions := make([]string, len(problems))
+	for i, p := range problems {
+		descriptions[i] = p.String()
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// storageChecker checks each WAL entry it is given in turn, and then the
+// requests the entries reference.
+type storageChecker struct {
+	report *StorageReport
+
+	// stopped is set once an entry cannot be read, after which no more
+	// are checked.
+	stopped bool
+
+	lastCheckpoint uint64
+	qEntries       []*pb.Persistent
+	qIndices       []uint64
+
+	// checkpoints are the checkpoint entries read, epochIndex is the index
+	// of the first entry which records an epoch, and epoch the latest epoch
+	// recorded.
+	checkpoints []walCheckpoint
+	epochIndex  uint64
+	epoch       uint64
+}
+
+// walCheckpoint is a checkpoint entry of the WAL.  Last is the index of the
+// last of the epoch entries directly following it, which record no batch nor
+// vote beyond the checkpoint.
+type walCheckpoint struct {
+	index uint64
+	last  uint64
+	seqNo uint64
+	value []byte
+}
+
+func newStorageChecker() *storageChecker {
+	return &storageChecker{report: &StorageReport{}}
+}
+
+func (sc *storageChecker) problem(index uint64, location, format string, args ...interface{}) {
+	sc.report.Problems = append(sc.report.Problems, StorageProblem{
+		Index:       index,
+		Location:    location,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// stop records a problem which prevents any later entries being read.
+func (sc *storageChecker) stop(index uint64, location, format string, args ...interface{}) {
+	sc.problem(index, location, format, args...)
+	sc.stopped = true
+}
+
+func (sc *storageChecker) entry(index uint64, location string, entry *pb.Persistent) {
+	if sc.stopped {
+		return
+	}
+
+	r := sc.report
+	if r.FirstIndex == 0 {
+		r.FirstIndex = index
+		if entry.GetCEntry() == nil {
+			sc.problem(index, location, "the first entry is %s, but it must be a checkpoint", entryType(entry))
+		}
+	} else if index != r.LastIndex+1 {
+		sc.stop(index, location, "entry follows index %d, so entries %d to %d are missing", r.LastIndex, r.LastIndex+1, index-1)
+		return
+	}
+	r.LastIndex = index
+
+	switch t := entry.Type.(type) {
+	case nil:
+		sc.problem(index, location, "entry has no type, it may be corrupt")
+	case *pb.Persistent_CEntry:
+		if t.CEntry.SeqNo < sc.lastCheckpoint {
+			sc.problem(index, location, "checkpoint at seq_no %d follows that at seq_no %d", t.CEntry.SeqNo, sc.lastCheckpoint)
+		}
+		if t.CEntry.NetworkState == nil {
+			sc.problem(index, location, "checkpoint at seq_no %d has no network state", t.CEntry.SeqNo)
+		}
+		sc.lastCheckpoint = t.CEntry.SeqNo
+		r.Checkpoints++
+		sc.checkpoints = append(sc.checkpoints, walCheckpoint{
+			index: index,
+			last:  index,
+			seqNo: t.CEntry.SeqNo,
+			value: t.CEntry.CheckpointValue,
+		})
+	case *pb.Persistent_QEntry:
+		sc.qEntries = append(sc.qEntries, entry)
+		sc.qIndices = append(sc.qIndices, index)
+	case *pb.Persistent_NEntry:
+		sc.recordEpoch(index, t.NEntry.GetEpochConfig().GetNumber())
+	case *pb.Persistent_FEntry:
+		sc.recordEpoch(index, t.FEntry.GetEndsEpochConfig().GetNumber())
+	case *pb.Persistent_ECEntry:
+		sc.followCheckpoint(index)
+		sc.sawEpoch(t.ECEntry.EpochNumber)
+	case *pb.Persistent_Suspect:
+		sc.followCheckpoint(index)
+		sc.sawEpoch(t.Suspect.Epoch)
+	}
+}
+
+// recordEpoch records an entry which begins or ends an epoch, from which Mir
+// learns the epoch config as the node restarts.
+func (sc *storageChecker) recordEpoch(index, epoch uint64) {
+	if sc.epochIndex == 0 {
+		sc.epochIndex = index
+	}
+	sc.followCheckpoint(index)
+	sc.sawEpoch(epoch)
+}
+
+func (sc *storageChecker) sawEpoch(epoch uint64) {
+	if epoch > sc.epoch {
+		sc.epoch = epoch
+	}
+}
+
+// followCheckpoint records an epoch entry, which a repair keeps along with
+// the checkpoint it directly follows.
+func (sc *storageChecker) followCheckpoint(index uint64) {
+	if n := len(sc.checkpoints); n > 0 && sc.checkpoints[n-1].last == index-1 {
+		sc.checkpoints[n-1].last = index
+	}
+}
+
+// checkRequests looks up each request referenced by the entries checked in
+// the request store.
+func (sc *storageChecker) checkRequests(reqStore processor.RequestStore) {
+	for i, entry := range sc.qEntries {
+		qEntry := entry.GetQEntry()
+		for _, ack := range qEntry.Requests {
+			sc.report.Requests++
+			data, err := reqStore.GetRequest(ack)
+			switch {
+			case err != nil:
+				sc.problem(sc.qIndices[i], "", "could not read request client_id=%d req_no=%d of the batch at seq_no %d: %s", ack.ClientId, ack.ReqNo, qEntry.SeqNo, err)
+			case data == nil:
+				sc.problem(sc.qIndices[i], "", "request client_id=%d req_no=%d digest=%x of the batch at seq_no %d is not in the request store", ack.ClientId, ack.ReqNo, ack.Digest, qEntry.SeqNo)
+			}
+		}
+	}
+}
+
+// finish computes the last good index, and returns the report.
+func (sc *storageChecker) finish() *StorageReport {
+	r := sc.report
+	r.LastGood = r.LastIndex
+	for _, p := range r.Problems {
+		if p.Index != 0 && p.Index <= r.LastGood {
+			r.LastGood = p.Index - 1
+		}
+	}
+	if r.LastGood < r.FirstIndex {
+		r.LastGood = 0
+	}
+
+	return r
+}
+
+// planRepair finds the last stable checkpoint at or before the last good
+// entry, and the latest snapshot of the node within snapshotDir, once the
+// report is finished.  A snapshot which cannot be read is not taken as
+// evidence that its checkpoint is stable.
+func (sc *storageChecker) planRepair(snapshotDir string) error {
+	paths, err := listSnapshots(snapshotDir)
+	if err != nil {
+		return errors.WithMessage(err, "could not list snapshots")
+	}
+
+	snapshots := map[uint64]*Snapshot{}
+	var latest *Snapshot
+	for _, path := range paths {
+		snapshot, err := readSnapshot(path)
+		if err != nil {
+			continue
+		}
+		snapshots[snapshot.SeqNo] = snapshot
+		latest = snapshot
+	}
+
+	r := sc.report
+	for i := len(sc.checkpoints) - 1; i >= 0; i-- {
+		c := sc.checkpoints[i]
+		if c.index > r.LastGood {
+			continue
+		}
+		if snapshot, ok := snapshots[c.seqNo]; c.seqNo != 0 && (!ok || !bytes.Equal(snapshot.Value, c.value)) {
+			continue
+		}
+
+		last := c.last
+		if last > r.LastGood {
+			last = r.LastGood
+		}
+		if sc.epochIndex == 0 || sc.epochIndex > last {
+			// Mir cannot resume a node without an epoch.
+			return nil
+		}
+
+		r.RepairIndex = last
+		r.RepairSeqNo = c.seqNo
+		r.RepairEpoch = sc.epoch + 1
+		if latest != nil && latest.SeqNo > c.seqNo {
+			r.Transfer = latest
+		}
+		return nil
+	}
+
+	return nil
+}
+
+func entryType(entry *pb.Persistent) string {
+	if entry.Type == nil {
+		return "untyped"
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", entry.Type), "*msgs.Persistent_")
+}
+
+// VerifyStorage checks that the entries of the WAL are continuous, that the
+// first is a checkpoint, and that every request referenced by a batch in the
+// WAL, whether committed or in flight, is in the request store.
+func VerifyStorage(wal processor.WAL, reqStore processor.RequestStore) (*StorageReport, error) {
+	sc := newStorageChecker()
+	err := wal.LoadAll(func(index uint64, entry *pb.Persistent) {
+		sc.entry(index, "", entry)
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not read WAL")
+	}
+
+	sc.checkRequests(reqStore)
+	return sc.finish(), nil
+}
+
+// CheckStorage checks the disk WAL and request store within runDir, as
+// VerifyStorage does, but reads the WAL from its segment files, so that
+// entries which cannot be read are located precisely, and a WAL which cannot
+// be opened is checked as far as possible.  The node must not be running.
+func CheckStorage(runDir string) (*StorageReport, error) {
+	sc := newStorageChecker()
+	sc.report.walDir = filepath.Join(runDir, WALDir)
+	if err := sc.scanWAL(sc.report.walDir); err != nil {
+		return nil, err
+	}
+
+	sc.checkRequestStore(filepath.Join(runDir, RequestStoreDir))
+
+	report := sc.finish()
+	if err := sc.planRepair(filepath.Join(runDir, SnapshotDir)); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkRequestStore looks up the requests the entries reference in the disk
+// request store at path.
+func (sc *storageChecker) checkRequestStore(path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if sc.report.FirstIndex != 0 {
+			sc.problem(0, RequestStoreDir, "request store is missing")
+		}
+		return
+	}
+
+	reqStore, err := OpenDiskRequestStore(path, SyncPolicy{Mode: SyncNever})
+	if err != nil {
+		// The requests cannot be looked up, and truncating the WAL
+		// cannot remedy this.
+		sc.problem(0, RequestStoreDir, "could not open request store: %s", err)
+		return
+	}
+	defer reqStore.Close()
+
+	sc.checkRequests(reqStore)
+}
+
+// walSegment is a segment file of the WAL, named by the index of its first
+// entry.
+type walSegment struct {
+	name  string
+	index uint64
+}
+
+// scanWAL reads the entries of each segment of the WAL in dir, which is
+// written by the tidwall/wal package underlying the disk WAL, each entry as
+// its length as a uvarint followed by the entry.
+func (sc *storageChecker) scanWAL(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not read WAL directory")
+	}
+
+	var segments []walSegment
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || len(name) < 20 {
+			continue
+		}
+		index, err := strconv.ParseUint(name[:20], 10, 64)
+		if err != nil || index == 0 {
+			continue
+		}
+		if len(name) != 20 {
+			// The WAL completes an interrupted truncation when it is
+			// next opened.
+			sc.stop(0, filepath.Join(WALDir, name), "segment is left from an interrupted truncation, start the node to complete it")
+			return nil
+		}
+		segments = append(segments, walSegment{name: name, index: index})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].index < segments[j].index })
+
+	for i, segment := range segments {
+		if i > 0 && segment.index != sc.report.LastIndex+1 {
+			sc.stop(sc.report.LastIndex+1, filepath.Join(WALDir, segment.name), "segment begins at index %d, but index %d follows the previous segment", segment.index, sc.report.LastIndex+1)
+			return nil
+		}
+		if err := sc.scanSegment(dir, segment); err != nil {
+			return err
+		}
+		if sc.stopped {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (sc *storageChecker) scanSegment(dir string, segment walSegment) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, segment.name))
+	if err != nil {
+		return errors.WithMessagef(err, "could not read WAL segment %s", segment.name)
+	}
+
+	path := filepath.Join(WALDir, segment.name)
+	var offset int64
+	for index := segment.index; len(data) > 0; index++ {
+		location := fmt.Sprintf("%s at offset %d", path, offset)
+
+		size, n := binary.Uvarint(data)
+		if n <= 0 {
+			sc.stop(index, location, "entry length is corrupt")
+			return nil
+		}
+		if uint64(len(data)-n) < size {
+			sc.stop(index, location, "entry is incomplete, %d of its %d bytes were written", len(data)-n, size)
+			return nil
+		}
+
+		entry := &pb.Persistent{}
+		if err := proto.Unmarshal(data[n:n+int(size)], entry); err != nil {
+			sc.stop(index, location, "entry could not be decoded: %s", err)
+			return nil
+		}
+
+		sc.entry(index, location, entry)
+		if sc.stopped {
+			return nil
+		}
+
+		data = data[n+int(size):]
+		offset += int64(n) + int64(size)
+		sc.report.ends = append(sc.report.ends, walPosition{segment: segment.name, offset: offset})
+	}
+
+	return nil
+}
+
+// RepairWAL truncates the WAL back to the stable checkpoint of a report
+// returned by CheckStorage, first copying the WAL aside within runDir, and
+// returns the path of the copy.  It then records an epoch change to the
+// report's repair epoch, so that the node does not resume the epoch whose
+// messages it no longer knows it sent, but rejoins at a new epoch, fetching
+// from its peers the state it lacks.  If the report holds a snapshot of a
+// later stable checkpoint, a state transfer to it is also recorded, which the
+// node completes as it restarts, as it would had it crashed during the
+// transfer.
+//
+// Truncation discards the node's record of the messages it sent beyond the
+// checkpoint, including any in epochs after those read, so once restarted it
+// may send messages which contradict them, that is equivocate, and the node
+// must be counted towards the faults the network tolerates.  So, RepairWAL
+// refuses unless acceptRisk is true.  The node must not be running.
+func RepairWAL(runDir string, report *StorageReport, acceptRisk bool) (string, error) {
+	if report.walDir == "" {
+		return "", errors.Errorf("the report was not produced from the WAL's segment files")
+	}
+	if len(report.Problems) == 0 {
+		return "", errors.Errorf("there is nothing to repair")
+	}
+	for _, p := range report.Problems {
+		if p.Index == 0 {
+			return "", errors.Errorf("%s, which truncating the WAL cannot repair, restore the run directory from a backup", p)
+		}
+	}
+	if report.RepairIndex == 0 {
+		return "", errors.Errorf("no stable checkpoint precedes the first problem, so none could be kept, restore the run directory from a backup")
+	}
+	if !acceptRisk {
+		return "", errors.Errorf("truncating the WAL discards the record of the messages the node sent, so the node may equivocate once restarted, and the risk must be accepted")
+	}
+
+	end := report.ends[report.RepairIndex-report.FirstIndex]
+
+	aside := filepath.Join(runDir, fmt.Sprintf("%s.pre-repair-%s", WALDir, time.Now().Format("20060102-150405")))
+	if err := copyDir(report.walDir, aside); err != nil {
+		return "", errors.WithMessage(err, "could not copy WAL aside")
+	}
+
+	infos, err := ioutil.ReadDir(report.walDir)
+	if err != nil {
+		return "", err
+	}
+	for _, info := range infos {
+		// The segments are named by their zero padded first index, so
+		// those after the one to truncate sort after its name.
+		if info.Name() > end.segment {
+			if err := os.Remove(filepath.Join(report.walDir, info.Name())); err != nil {
+				return "", errors.WithMessage(err, "could not remove WAL segment")
+			}
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(report.walDir, end.segment), os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := f.Truncate(end.offset); err != nil {
+		return "", errors.WithMessage(err, "could not truncate WAL segment")
+	}
+
+	appended := []*pb.Persistent{{Type: &pb.Persistent_ECEntry{ECEntry: &pb.ECEntry{
+		EpochNumber: report.RepairEpoch,
+	}}}}
+	if report.Transfer != nil {
+		appended = append(appended, &pb.Persistent{Type: &pb.Persistent_TEntry{TEntry: &pb.TEntry{
+			SeqNo: report.Transfer.SeqNo,
+			Value: report.Transfer.Value,
+		}}})
+	}
+	offset := end.offset
+	for _, entry := range appended {
+		data, err := proto.Marshal(entry)
+		if err != nil {
+			return "", errors.WithMessage(err, "could not marshal WAL entry")
+		}
+		buf := make([]byte, binary.MaxVarintLen64+len(data))
+		n := binary.PutUvarint(buf, uint64(len(data)))
+		n += copy(buf[n:], data)
+		if _, err := f.WriteAt(buf[:n], offset); err != nil {
+			return "", errors.WithMessage(err, "could not append to WAL segment")
+		}
+		offset += int64(n)
+	}
+
+	if err := f.Sync(); err != nil {
+		return "", errors.WithMessage(err, "could not sync WAL segment")
+	}
+
+	return aside, nil
+}
+
+// copyDir copies the regular files of the directory src to a new directory
+// dst.
+func copyDir(src, dst string) error {
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dst, 0700); err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, info.Name()), filepath.Join(dst, info.Name()), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
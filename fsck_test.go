@@ -0,0 +1,235 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStorage(t *testing.T) {
+	ack := func(reqNo uint64) *pb.RequestAck {
+		return &pb.RequestAck{ClientId: 1, ReqNo: reqNo, Digest: []byte{byte(reqNo)}}
+	}
+	cEntry := func(seqNo uint64) *pb.Persistent {
+		return loadCheckpoint(seqNo).GetLoadPersistedEntry().Entry
+	}
+	qEntry := func(seqNo uint64, acks ...*pb.RequestAck) *pb.Persistent {
+		return &pb.Persistent{Type: &pb.Persistent_QEntry{QEntry: &pb.QEntry{SeqNo: seqNo, Requests: acks}}}
+	}
+	nEntry := func(seqNo uint64) *pb.Persistent {
+		return &pb.Persistent{Type: &pb.Persistent_NEntry{NEntry: &pb.NEntry{SeqNo: seqNo, EpochConfig: &pb.EpochConfig{Number: 1}}}}
+	}
+	// genesis is the start of a new node's WAL, the initial checkpoint and
+	// the entries which end epoch zero and begin epoch one.
+	genesis := []*pb.Persistent{
+		cEntry(0),
+		{Type: &pb.Persistent_FEntry{FEntry: &pb.FEntry{EndsEpochConfig: &pb.EpochConfig{Number: 0}}}},
+		{Type: &pb.Persistent_ECEntry{ECEntry: &pb.ECEntry{EpochNumber: 1}}},
+		nEntry(1),
+	}
+	entries := func(entries ...*pb.Persistent) []*pb.Persistent {
+		return append(append([]*pb.Persistent{}, genesis...), entries...)
+	}
+	snapshot := func(seqNo uint64, value string) *Snapshot {
+		result := checkpointResult(seqNo).GetCheckpointResult()
+		return &Snapshot{SeqNo: seqNo, Value: []byte(value), NetworkState: result.NetworkState, Certificate: []uint64{0, 1, 2}}
+	}
+	segment := filepath.Join(WALDir, "00000000000000000001")
+
+	tests := []struct {
+		name        string
+		entries     []*pb.Persistent
+		requests    []*pb.RequestAck
+		snapshots   []*Snapshot
+		corrupt     func(t *testing.T, runDir string)
+		problems    []string
+		lastGood    uint64
+		repairIndex uint64
+		transfer    uint64
+	}{
+		{
+			name:        "consistent",
+			entries:     entries(qEntry(1, ack(1)), qEntry(2, ack(2))),
+			requests:    []*pb.RequestAck{ack(1), ack(2)},
+			lastGood:    6,
+			repairIndex: 4,
+		},
+		{
+			name:        "missing request",
+			entries:     entries(qEntry(1, ack(1)), qEntry(2, ack(2)), qEntry(3, ack(3))),
+			requests:    []*pb.RequestAck{ack(1), ack(3)},
+			problems:    []string{"WAL index 6: request client_id=1 req_no=2 digest=02 of the batch at seq_no 2 is not in the request store"},
+			lastGood:    5,
+			repairIndex: 4,
+		},
+		{
+			name:     "incomplete entry",
+			entries:  entries(qEntry(1, ack(1))),
+			requests: []*pb.RequestAck{ack(1)},
+			corrupt: func(t *testing.T, runDir string) {
+				f, err := os.OpenFile(filepath.Join(runDir, segment), os.O_WRONLY|os.O_APPEND, 0)
+				require.NoError(t, err)
+				defer f.Close()
+				_, err = f.Write([]byte{20, 1, 2})
+				require.NoError(t, err)
+			},
+			problems:    []string{"WAL index 6, " + segment + " at offset 59: entry is incomplete, 2 of its 20 bytes were written"},
+			lastGood:    5,
+			repairIndex: 4,
+		},
+		{
+			name:     "no checkpoint",
+			entries:  []*pb.Persistent{qEntry(1, ack(1))},
+			requests: []*pb.RequestAck{ack(1)},
+			problems: []string{"WAL index 1, " + segment + " at offset 0: the first entry is QEntry, but it must be a checkpoint"},
+		},
+		{
+			name:     "no epoch",
+			entries:  []*pb.Persistent{cEntry(0), qEntry(1, ack(1))},
+			problems: []string{"WAL index 2: request client_id=1 req_no=1 digest=01 of the batch at seq_no 1 is not in the request store"},
+			lastGood: 1,
+		},
+		{
+			name:        "later checkpoint not stable",
+			entries:     entries(qEntry(20, ack(1)), cEntry(20), nEntry(41), qEntry(21, ack(2))),
+			requests:    []*pb.RequestAck{ack(1)},
+			problems:    []string{"WAL index 8: request client_id=1 req_no=2 digest=02 of the batch at seq_no 21 is not in the request store"},
+			lastGood:    7,
+			repairIndex: 4,
+		},
+		{
+			name:        "later checkpoint stable",
+			entries:     entries(qEntry(20, ack(1)), cEntry(20), nEntry(41), qEntry(21, ack(2))),
+			requests:    []*pb.RequestAck{ack(1)},
+			snapshots:   []*Snapshot{snapshot(20, "value-20")},
+			problems:    []string{"WAL index 8: request client_id=1 req_no=2 digest=02 of the batch at seq_no 21 is not in the request store"},
+			lastGood:    7,
+			repairIndex: 7,
+		},
+		{
+			name:        "state transfer",
+			entries:     entries(qEntry(20, ack(1)), cEntry(20), nEntry(41), qEntry(21, ack(2))),
+			requests:    []*pb.RequestAck{ack(1)},
+			snapshots:   []*Snapshot{snapshot(20, "value-20"), snapshot(40, "value-40")},
+			problems:    []string{"WAL index 8: request client_id=1 req_no=2 digest=02 of the batch at seq_no 21 is not in the request store"},
+			lastGood:    7,
+			repairIndex: 7,
+			transfer:    40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runDir := t.TempDir()
+			wal, err := OpenDiskWAL(filepath.Join(runDir, WALDir), SyncPolicy{Mode: SyncAlways})
+			require.NoError(t, err)
+			for i, entry := range tt.entries {
+				require.NoError(t, wal.Write(uint64(i+1), entry))
+			}
+			require.NoError(t, wal.Close())
+
+			reqStore, err := OpenDiskRequestStore(filepath.Join(runDir, RequestStoreDir), SyncPolicy{Mode: SyncAlways})
+			require.NoError(t, err)
+			for _, ack := range tt.requests {
+				require.NoError(t, reqStore.PutRequest(ack, []byte("data")))
+			}
+			require.NoError(t, reqStore.Close())
+
+			for _, snapshot := range tt.snapshots {
+				require.NoError(t, writeSnapshot(filepath.Join(runDir, SnapshotDir), snapshot))
+			}
+
+			if tt.corrupt != nil {
+				tt.corrupt(t, runDir)
+			}
+
+			report, err := CheckStorage(runDir)
+			require.NoError(t, err)
+			var problems []string
+			for _, p := range report.Problems {
+				problems = append(problems, p.String())
+			}
+			assert.Equal(t, tt.problems, problems)
+			assert.Equal(t, tt.lastGood, report.LastGood)
+			assert.Equal(t, tt.repairIndex, report.RepairIndex)
+			if tt.transfer == 0 {
+				assert.Nil(t, report.Transfer)
+			} else if assert.NotNil(t, report.Transfer) {
+				assert.Equal(t, tt.transfer, report.Transfer.SeqNo)
+			}
+
+			_, err = RepairWAL(runDir, report, true)
+			switch {
+			case tt.problems == nil:
+				assert.EqualError(t, err, "there is nothing to repair")
+				return
+			case tt.repairIndex == 0:
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			report, err = CheckStorage(runDir)
+			require.NoError(t, err)
+			assert.Empty(t, report.Problems)
+
+			wal, err = OpenDiskWAL(filepath.Join(runDir, WALDir), SyncPolicy{Mode: SyncAlways})
+			require.NoError(t, err, "the repaired WAL can be opened")
+			defer wal.Close()
+			var appended []*pb.Persistent
+			require.NoError(t, wal.LoadAll(func(index uint64, entry *pb.Persistent) {
+				if index > tt.repairIndex {
+					appended = append(appended, entry)
+				}
+			}))
+			require.NotEmpty(t, appended)
+			assert.Equal(t, uint64(2), appended[0].GetECEntry().GetEpochNumber(), "the node changes from the epoch it was in")
+			if tt.transfer == 0 {
+				assert.Len(t, appended, 1)
+				return
+			}
+			require.Len(t, appended, 2)
+			assert.Equal(t, tt.transfer, appended[1].GetTEntry().GetSeqNo())
+			assert.Equal(t, []byte(fmt.Sprintf("value-%d", tt.transfer)), appended[1].GetTEntry().GetValue())
+		})
+	}
+}
+
+func TestRepairWALRefusesByDefault(t *testing.T) {
+	runDir := t.TempDir()
+	wal, err := OpenDiskWAL(filepath.Join(runDir, WALDir), SyncPolicy{Mode: SyncAlways})
+	require.NoError(t, err)
+	entry := loadCheckpoint(0).GetLoadPersistedEntry().Entry
+	nEntry := &pb.Persistent{Type: &pb.Persistent_NEntry{NEntry: &pb.NEntry{SeqNo: 1, EpochConfig: &pb.EpochConfig{Number: 1}}}}
+	qEntry := &pb.Persistent{Type: &pb.Persistent_QEntry{QEntry: &pb.QEntry{SeqNo: 1, Requests: []*pb.RequestAck{{ClientId: 1, ReqNo: 1}}}}}
+	for i, entry := range []*pb.Persistent{entry, nEntry, qEntry} {
+		require.NoError(t, wal.Write(uint64(i+1), entry))
+	}
+	require.NoError(t, wal.Close())
+	reqStore, err := OpenDiskRequestStore(filepath.Join(runDir, RequestStoreDir), SyncPolicy{Mode: SyncAlways})
+	require.NoError(t, err)
+	require.NoError(t, reqStore.Close())
+
+	report, err := CheckStorage(runDir)
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	require.Equal(t, uint64(2), report.RepairIndex)
+
+	_, err = RepairWAL(runDir, report, false)
+	assert.EqualError(t, err, "truncating the WAL discards the record of the messages the node sent, so the node may equivocate once restarted, and the risk must be accepted")
+
+	report, err = CheckStorage(runDir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), report.LastIndex, "the WAL is left as it was")
+}
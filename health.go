@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jyellick/mirbft-sample/network"
+)
+
+const (
+	// defaultStallTimeout is how long processing may go without handling
+	// an event before the node is not live, if the config sets no timeout.
+	defaultStallTimeout = 30 * time.Second
+
+	// defaultCommitWindow is how recently the node must have committed to
+	// be ready, if the config sets no window.
+	defaultCommitWindow = 30 * time.Second
+)
+
+// Health is the outcome of the checks making up the node's liveness or its
+// readiness, which is healthy only if every check passes.
+type Health struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+// HealthCheck is the outcome of a single check, described by its detail.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Detail  string `json:"detail"`
+}
+
+func (h *Health) check(name string, healthy bool, format string, args ...interface{}) {
+	h.Checks = append(h.Checks, HealthCheck{
+		Name:    name,
+		Healthy: healthy,
+		Detail:  fmt.Sprintf(format, args...),
+	})
+	h.Healthy = h.Healthy && healthy
+}
+
+// Liveness reports whether processing is handling events, as it does at least
+// every tick while the node is running, so that a supervisor may restart a
+// node which has stalled.  As for Status, it requires an admin_address.
+func (s *Server) Liveness() *Health {
+	s.mutex.Lock()
+	tracker := s.status
+	stallTimeout := s.NodeConfig.Health.StallTimeout
+	s.mutex.Unlock()
+
+	if stallTimeout == 0 {
+		stallTimeout = defaultStallTimeout
+	}
+
+	h := &Health{Healthy: true}
+	if tracker == nil {
+		h.check("processing", false, "the node is not running")
+		return h
+	}
+
+	since := tracker.sinceLastEvent()
+	h.check("processing", since <= stallTimeout, "last handled an event %s ago, stalled after %s", since.Round(time.Millisecond), stallTimeout)
+	return h
+}
+
+// Readiness reports whether the node is taking part in consensus: whether its
+// transport is listening, it is connected to enough peers to form a quorum,
+// and it has recently committed an entry.
+func (s *Server) Readiness() *Health {
+	s.mutex.Lock()
+	t, app := s.transport, s.app
+	commitWindow := s.NodeConfig.Health.CommitWindow
+	nodes := len(s.NodeConfig.Nodes)
+	s.mutex.Unlock()
+
+	if commitWindow == 0 {
+		commitWindow = defaultCommitWindow
+	}
+
+	if t == nil {
+		h := &Health{Healthy: true}
+		h.check("transport", false, "the node is not running")
+		return h
+	}
+
+	return readiness(t, app, nodes, commitWindow)
+}
+
+// peerTransport is the part of the transport on which readiness depends.
+type peerTransport interface {
+	Listening() bool
+	Peers() []network.PeerStatus
+}
+
+// readiness checks the transport and application of a running network of the
+// given number of nodes.
+func readiness(t peerTransport, app *application, nodes int, commitWindow time.Duration) *Health {
+	h := &Health{Healthy: true}
+	if t.Listening() {
+		h.check("transport", true, "listening")
+	} else {
+		h.check("transport", false, "not listening")
+	}
+
+	// Together with this node, the connected peers must number at least
+	// the 2f+1 nodes of a quorum.
+	required := nodes - (nodes-1)/3 - 1
+	connected := ConnectedPeers(t.Peers())
+	h.check("peers", connected >= required, "%d of %d peers connected, %d required", connected, nodes-1, required)
+
+	if since, ok := app.sinceLastApplied(); ok {
+		h.check("commits", since <= commitWindow, "last committed seq_no=%d %s ago, required within %s", app.lastApplied(), since.Round(time.Millisecond), commitWindow)
+	} else {
+		h.check("commits", false, "nothing committed since starting")
+	}
+
+	return h
+}
+
+// ConnectedPeers counts the peers with which consensus messages may be
+// exchanged in both directions.
+func ConnectedPeers(peers []network.PeerStatus) int {
+	connected := 0
+	for _, peer := range peers {
+		if peer.Introduced && peer.Verified {
+			connected++
+		}
+	}
+	return connected
+}
@@ -0,0 +1,192 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTransport struct {
+	listening bool
+	peers     []network.PeerStatus
+}
+
+func (t *fakeTransport) Listening() bool { return t.listening }
+
+func (t *fakeTransport) Peers() []network.PeerStatus { return t.peers }
+
+// healthChecks summarizes the checks which make up a health by whether each
+// passed.
+func healthChecks(h *Health) map[string]bool {
+	checks := map[string]bool{}
+	for _, check := range h.Checks {
+		checks[check.Name] = check.Healthy
+	}
+	return checks
+}
+
+func TestLiveness(t *testing.T) {
+	tests := []struct {
+		name         string
+		tracker      *statusTracker
+		stallTimeout time.Duration
+		healthy      bool
+	}{
+		{
+			name: "not running",
+		},
+		{
+			name:    "recent event",
+			tracker: &statusTracker{lastEventAt: time.Now()},
+			healthy: true,
+		},
+		{
+			name:    "stalled beyond the default timeout",
+			tracker: &statusTracker{lastEventAt: time.Now().Add(-time.Minute)},
+		},
+		{
+			name:         "within the configured timeout",
+			tracker:      &statusTracker{lastEventAt: time.Now().Add(-time.Minute)},
+			stallTimeout: 2 * time.Minute,
+			healthy:      true,
+		},
+		{
+			name:         "stalled beyond the configured timeout",
+			tracker:      &statusTracker{lastEventAt: time.Now().Add(-time.Second)},
+			stallTimeout: time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				NodeConfig: &config.NodeConfig{Health: config.Health{StallTimeout: tt.stallTimeout}},
+				status:     tt.tracker,
+			}
+			h := s.Liveness()
+			assert.Equal(t, tt.healthy, h.Healthy)
+			assert.Equal(t, map[string]bool{"processing": tt.healthy}, healthChecks(h))
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	peers := func(connected ...bool) []network.PeerStatus {
+		var peers []network.PeerStatus
+		for i, c := range connected {
+			peers = append(peers, network.PeerStatus{ID: uint64(i + 1), Introduced: c, Verified: c})
+		}
+		return peers
+	}
+
+	tests := []struct {
+		name         string
+		transport    *fakeTransport
+		nodes        int
+		app          *application
+		commitWindow time.Duration
+		checks       map[string]bool
+	}{
+		{
+			name:      "ready",
+			transport: &fakeTransport{listening: true, peers: peers(true, true, true)},
+			nodes:     4,
+			app:       &application{lastSeqNo: 5, lastAppliedAt: time.Now()},
+			checks:    map[string]bool{"transport": true, "peers": true, "commits": true},
+		},
+		{
+			name:      "not listening",
+			transport: &fakeTransport{peers: peers(true, true, true)},
+			nodes:     4,
+			app:       &application{lastSeqNo: 5, lastAppliedAt: time.Now()},
+			checks:    map[string]bool{"transport": false, "peers": true, "commits": true},
+		},
+		{
+			name:      "quorum of peers",
+			transport: &fakeTransport{listening: true, peers: peers(true, false, true)},
+			nodes:     4,
+			app:       &application{lastSeqNo: 5, lastAppliedAt: time.Now()},
+			checks:    map[string]bool{"transport": true, "peers": true, "commits": true},
+		},
+		{
+			name:      "too few peers",
+			transport: &fakeTransport{listening: true, peers: peers(true, false, false)},
+			nodes:     4,
+			app:       &application{lastSeqNo: 5, lastAppliedAt: time.Now()},
+			checks:    map[string]bool{"transport": true, "peers": false, "commits": true},
+		},
+		{
+			name: "introduced but not verified",
+			transport: &fakeTransport{listening: true, peers: []network.PeerStatus{
+				{ID: 1, Introduced: true, Verified: true},
+				{ID: 2, Introduced: true},
+				{ID: 3, Verified: true},
+			}},
+			nodes:  4,
+			app:    &application{lastSeqNo: 5, lastAppliedAt: time.Now()},
+			checks: map[string]bool{"transport": true, "peers": false, "commits": true},
+		},
+		{
+			name:      "single node",
+			transport: &fakeTransport{listening: true},
+			nodes:     1,
+			app:       &application{lastSeqNo: 5, lastAppliedAt: time.Now()},
+			checks:    map[string]bool{"transport": true, "peers": true, "commits": true},
+		},
+		{
+			name:      "nothing committed",
+			transport: &fakeTransport{listening: true, peers: peers(true, true, true)},
+			nodes:     4,
+			app:       &application{},
+			checks:    map[string]bool{"transport": true, "peers": true, "commits": false},
+		},
+		{
+			name:      "no recent commit",
+			transport: &fakeTransport{listening: true, peers: peers(true, true, true)},
+			nodes:     4,
+			app:       &application{lastSeqNo: 5, lastAppliedAt: time.Now().Add(-time.Minute)},
+			checks:    map[string]bool{"transport": true, "peers": true, "commits": false},
+		},
+		{
+			name:         "commit within the configured window",
+			transport:    &fakeTransport{listening: true, peers: peers(true, true, true)},
+			nodes:        4,
+			app:          &application{lastSeqNo: 5, lastAppliedAt: time.Now().Add(-time.Minute)},
+			commitWindow: 2 * time.Minute,
+			checks:       map[string]bool{"transport": true, "peers": true, "commits": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commitWindow := tt.commitWindow
+			if commitWindow == 0 {
+				commitWindow = defaultCommitWindow
+			}
+			h := readiness(tt.transport, tt.app, tt.nodes, commitWindow)
+
+			healthy := true
+			for _, ok := range tt.checks {
+				healthy = healthy && ok
+			}
+			assert.Equal(t, healthy, h.Healthy)
+			assert.Equal(t, tt.checks, healthChecks(h))
+		})
+	}
+}
+
+func TestReadinessNotRunning(t *testing.T) {
+	s := &Server{NodeConfig: &config.NodeConfig{}}
+	h := s.Readiness()
+	assert.False(t, h.Healthy)
+	assert.Equal(t, []HealthCheck{{Name: "transport", Detail: "the node is not running"}}, h.Checks)
+}
@@ -0,0 +1,256 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jyellick/mirbft-sample/network"
+)
+
+// delivery tracks the progress of sending a single request to a single node.
+type delivery struct {
+	acked    bool
+	pending  bool // queued for, or in the process of, sending
+	attempts int
+	lastSent time.Time
+	lastErr  error
+}
+
+type inflightRequest struct {
+	request    *network.Propose
+	deliveries map[uint64]*delivery
+}
+
+type dispatch struct {
+	nodeID uint64
+	reqNo  uint64
+}
+
+// undelivered describes a request which could not be delivered to enough nodes.
+type undelivered struct {
+	reqNo  uint64
+	acks   int
+	errors map[uint64]error
+}
+
+// inflightTracker remembers every request the client has submitted until each
+// node has either acknowledged it, or the client has exhausted its attempts to
+// deliver it to that node.
+type inflightTracker struct {
+	timeout     time.Duration
+	maxAttempts int
+
+	mutex    sync.Mutex
+	requests map[uint64]*inflightRequest
+}
+
+func newInflightTracker(timeout time.Duration, maxAttempts int) *inflightTracker {
+	return &inflightTracker{
+		timeout:     timeout,
+		maxAttempts: maxAttempts,
+		requests:    map[uint64]*inflightRequest{},
+	}
+}
+
+// add begins tracking a request which must be delivered to each of the given nodes.
+func (it *inflightTracker) add(request *network.Propose, nodeIDs []uint64) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	ir := &inflightRequest{
+		request:    request,
+		deliveries: map[uint64]*delivery{},
+	}
+	for _, nodeID := range nodeIDs {
+		ir.deliveries[nodeID] = &delivery{}
+	}
+	it.requests[request.ReqNo] = ir
+}
+
+// due returns the deliveries which have never been attempted, or whose
+// last attempt went unacknowledged for longer than the timeout.  The
+// returned deliveries are marked pending until acked or failed.
+func (it *inflightTracker) due(now time.Time) []dispatch {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	var result []dispatch
+	for reqNo, ir := range it.requests {
+		for nodeID, d := range ir.deliveries {
+			if d.acked || d.pending || d.attempts >= it.maxAttempts {
+				continue
+			}
+
+			if !d.lastSent.IsZero() && now.Sub(d.lastSent) < it.timeout {
+				continue
+			}
+
+			d.pending = true
+			result = append(result, dispatch{
+				nodeID: nodeID,
+				reqNo:  reqNo,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].reqNo < result[j].reqNo
+	})
+
+	return result
+}
+
+// sent records an attempt to deliver the request to the node and returns
+// the request to send.
+func (it *inflightTracker) sent(nodeID, reqNo uint64, now time.Time) *network.Propose {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	ir := it.requests[reqNo]
+	d := ir.deliveries[nodeID]
+	d.attempts++
+	d.lastSent = now
+	return ir.request
+}
+
+// ack records that the node acknowledged the request, returning true if the
+// request is now settled with every node.
+func (it *inflightTracker) ack(nodeID, reqNo uint64) bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	ir := it.requests[reqNo]
+	d := ir.deliveries[nodeID]
+	d.acked = true
+	d.pending = false
+	d.lastErr = nil
+	return it.requestSettled(ir)
+}
+
+// fail records a failed attempt to deliver the request to the node,
+// returning true if the request is now settled with every node.
+func (it *inflightTracker) fail(nodeID, reqNo uint64, err error) bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	ir := it.requests[reqNo]
+	d := ir.deliveries[nodeID]
+	d.pending = false
+	d.lastErr = err
+	return it.requestSettled(ir)
+}
+
+// requeue returns a delivery to be sent again once the timeout elapses,
+// without counting the attempt made, for instance because the node had not
+// yet advanced its window far enough to accept the request.
+func (it *inflightTracker) requeue(nodeID, reqNo uint64, err error) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	d := it.requests[reqNo].deliveries[nodeID]
+	d.pending = false
+	d.lastErr = err
+	d.attempts--
+}
+
+// unqueue returns a delivery which could not be queued to be sent, so that
+// it is due again straight away.
+func (it *inflightTracker) unqueue(nodeID, reqNo uint64) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	it.requests[reqNo].deliveries[nodeID].pending = false
+}
+
+// quorumAcked returns true once every request has been acknowledged by at
+// least quorum nodes.
+func (it *inflightTracker) quorumAcked(quorum int) bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	for _, ir := range it.requests {
+		acks := 0
+		for _, d := range ir.deliveries {
+			if d.acked {
+				acks++
+			}
+		}
+
+		if acks < quorum {
+			return false
+		}
+	}
+
+	return true
+}
+
+// settled returns true once every delivery has been acknowledged or
+// has exhausted its attempts.
+func (it *inflightTracker) settled() bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	for _, ir := range it.requests {
+		if !it.requestSettled(ir) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestSettled returns true once every delivery of the request has been
+// acknowledged or has exhausted its attempts.
+func (it *inflightTracker) requestSettled(ir *inflightRequest) bool {
+	for _, d := range ir.deliveries {
+		if d.acked {
+			continue
+		}
+
+		if d.pending || d.attempts < it.maxAttempts {
+			return false
+		}
+	}
+
+	return true
+}
+
+// undelivered returns, in reqNo order, the requests which were not
+// acknowledged by every node, along with the last error from each node
+// which did not acknowledge it.
+func (it *inflightTracker) undelivered() []*undelivered {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	var result []*undelivered
+	for reqNo, ir := range it.requests {
+		u := &undelivered{
+			reqNo:  reqNo,
+			errors: map[uint64]error{},
+		}
+		for nodeID, d := range ir.deliveries {
+			if d.acked {
+				u.acks++
+				continue
+			}
+			u.errors[nodeID] = d.lastErr
+		}
+
+		if len(u.errors) > 0 {
+			result = append(result, u)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].reqNo < result[j].reqNo
+	})
+
+	return result
+}
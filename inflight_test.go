@@ -0,0 +1,268 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testStart = time.Unix(1000, 0)
+
+func newTestTracker(reqNos ...uint64) *inflightTracker {
+	it := newInflightTracker(time.Second, 2)
+	for _, reqNo := range reqNos {
+		it.add(&network.Propose{ReqNo: reqNo}, []uint64{0, 1})
+	}
+	return it
+}
+
+func TestInflightTrackerDue(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(it *inflightTracker)
+		now     time.Time
+		due     []dispatch
+	}{
+		{
+			name:    "never attempted",
+			prepare: func(it *inflightTracker) {},
+			now:     testStart,
+			due:     []dispatch{{0, 1}, {1, 1}, {0, 2}, {1, 2}},
+		},
+		{
+			name: "pending",
+			prepare: func(it *inflightTracker) {
+				it.due(testStart)
+			},
+			now: testStart,
+		},
+		{
+			name: "acked",
+			prepare: func(it *inflightTracker) {
+				it.due(testStart)
+				it.sent(0, 1, testStart)
+				it.ack(0, 1)
+				it.fail(1, 1, errors.New("timeout"))
+				it.fail(0, 2, errors.New("timeout"))
+				it.fail(1, 2, errors.New("timeout"))
+			},
+			now: testStart.Add(time.Second),
+			due: []dispatch{{1, 1}, {0, 2}, {1, 2}},
+		},
+		{
+			name: "within timeout",
+			prepare: func(it *inflightTracker) {
+				it.due(testStart)
+				it.sent(0, 1, testStart)
+				it.fail(0, 1, errors.New("timeout"))
+			},
+			now: testStart.Add(time.Second / 2),
+		},
+		{
+			name: "attempts exhausted",
+			prepare: func(it *inflightTracker) {
+				for i := 0; i < 2; i++ {
+					now := testStart.Add(time.Duration(i) * time.Second)
+					it.due(now)
+					it.sent(0, 1, now)
+					it.fail(0, 1, errors.New("timeout"))
+				}
+			},
+			now: testStart.Add(time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := newTestTracker(1, 2)
+			tt.prepare(it)
+			due := it.due(tt.now)
+			assert.ElementsMatch(t, tt.due, due)
+		})
+	}
+}
+
+func TestInflightTrackerSettled(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(it *inflightTracker)
+		settled bool
+	}{
+		{
+			name:    "nothing tracked",
+			settled: true,
+		},
+		{
+			name: "never attempted",
+			prepare: func(it *inflightTracker) {
+				it.add(&network.Propose{ReqNo: 1}, []uint64{0, 1})
+			},
+			settled: false,
+		},
+		{
+			name: "all acked",
+			prepare: func(it *inflightTracker) {
+				it.add(&network.Propose{ReqNo: 1}, []uint64{0, 1})
+				it.due(testStart)
+				it.ack(0, 1)
+				it.ack(1, 1)
+			},
+			settled: true,
+		},
+		{
+			name: "pending",
+			prepare: func(it *inflightTracker) {
+				it.add(&network.Propose{ReqNo: 1}, []uint64{0, 1})
+				it.due(testStart)
+				it.ack(0, 1)
+			},
+			settled: false,
+		},
+		{
+			name: "attempts remaining",
+			prepare: func(it *inflightTracker) {
+				it.add(&network.Propose{ReqNo: 1}, []uint64{0, 1})
+				it.due(testStart)
+				it.ack(0, 1)
+				it.sent(1, 1, testStart)
+				it.fail(1, 1, errors.New("timeout"))
+			},
+			settled: false,
+		},
+		{
+			name: "attempts exhausted",
+			prepare: func(it *inflightTracker) {
+				it.add(&network.Propose{ReqNo: 1}, []uint64{0, 1})
+				it.due(testStart)
+				it.ack(0, 1)
+				for i := 0; i < 2; i++ {
+					it.sent(1, 1, testStart)
+					it.fail(1, 1, errors.New("timeout"))
+				}
+			},
+			settled: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := newTestTracker()
+			if tt.prepare != nil {
+				tt.prepare(it)
+			}
+			assert.Equal(t, tt.settled, it.settled())
+		})
+	}
+}
+
+func TestInflightTrackerUndelivered(t *testing.T) {
+	errTimeout := errors.New("timeout")
+
+	it := newTestTracker(3, 1, 2)
+	it.due(testStart)
+	it.ack(0, 1)
+	it.ack(1, 1)
+	it.ack(0, 2)
+	it.fail(1, 2, errTimeout)
+	it.fail(0, 3, errTimeout)
+	it.fail(1, 3, errTimeout)
+
+	assert.Equal(t, []*undelivered{
+		{
+			reqNo:  2,
+			acks:   1,
+			errors: map[uint64]error{1: errTimeout},
+		},
+		{
+			reqNo:  3,
+			acks:   0,
+			errors: map[uint64]error{0: errTimeout, 1: errTimeout},
+		},
+	}, it.undelivered())
+}
+
+func TestInflightTrackerQuorumAcked(t *testing.T) {
+	tests := []struct {
+		name        string
+		acks        []dispatch
+		quorumAcked bool
+	}{
+		{
+			name:        "none acked",
+			quorumAcked: false,
+		},
+		{
+			name:        "one request short",
+			acks:        []dispatch{{0, 1}, {1, 1}, {0, 2}},
+			quorumAcked: false,
+		},
+		{
+			name:        "each by a quorum",
+			acks:        []dispatch{{0, 1}, {1, 1}, {1, 2}, {2, 2}},
+			quorumAcked: true,
+		},
+		{
+			name:        "all acked",
+			acks:        []dispatch{{0, 1}, {1, 1}, {2, 1}, {0, 2}, {1, 2}, {2, 2}},
+			quorumAcked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := newInflightTracker(time.Second, 2)
+			for _, reqNo := range []uint64{1, 2} {
+				it.add(&network.Propose{ReqNo: reqNo}, []uint64{0, 1, 2})
+			}
+			it.due(testStart)
+			for _, ack := range tt.acks {
+				it.ack(ack.nodeID, ack.reqNo)
+			}
+			assert.Equal(t, tt.quorumAcked, it.quorumAcked(2))
+		})
+	}
+}
+
+func TestInflightTrackerRequeue(t *testing.T) {
+	errOutside := errors.New("outside_window")
+
+	it := newTestTracker(1)
+	it.ack(1, 1)
+
+	// Rejections from a node whose window lags never exhaust the attempts.
+	for i := 0; i < 5; i++ {
+		now := testStart.Add(time.Duration(i) * time.Second)
+		assert.Equal(t, []dispatch{{0, 1}}, it.due(now), "attempt %d", i)
+		it.sent(0, 1, now)
+		it.requeue(0, 1, errOutside)
+
+		assert.Empty(t, it.due(now.Add(time.Second/2)), "retried within the timeout")
+		assert.False(t, it.settled())
+	}
+
+	u := it.undelivered()
+	assert.Len(t, u, 1)
+	assert.Equal(t, errOutside, u[0].errors[0])
+}
+
+func TestInflightTrackerUnqueue(t *testing.T) {
+	it := newTestTracker(1)
+	it.ack(1, 1)
+
+	assert.Equal(t, []dispatch{{0, 1}}, it.due(testStart))
+	assert.Empty(t, it.due(testStart), "pending until sent")
+
+	// A delivery which could not be queued is due again, without an attempt.
+	it.unqueue(0, 1)
+	assert.Equal(t, []dispatch{{0, 1}}, it.due(testStart))
+	assert.False(t, it.settled())
+}
@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"io"
+	"os"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// The components whose levels may be configured individually.
+const (
+	Mir       = "mir"
+	Noise     = "noise"
+	App       = "app"
+	Transport = "transport"
+)
+
+// defaultMaxSizeMB is the size at which a log file is rotated if the config
+// does not specify one.
+const defaultMaxSizeMB = 100
+
+// Loggers hands out a logger for each component, all writing to the same
+// destination, while logging at levels which may be changed at runtime.
+type Loggers struct {
+	encoder zapcore.Encoder
+	sink    zapcore.WriteSyncer
+	closer  io.Closer
+	fields  []zap.Field
+	levels  map[string]zap.AtomicLevel
+}
+
+// New builds the loggers described by the config, each of which includes the
+// given fields in every entry.
+func New(c *config.Logging, fields ...zap.Field) (*Loggers, error) {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	l := &Loggers{
+		fields: fields,
+		levels: map[string]zap.AtomicLevel{},
+	}
+
+	switch c.Encoding {
+	case "json":
+		l.encoder = zapcore.NewJSONEncoder(encoderConfig)
+	default:
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		l.encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	}
+
+	if c.File == "" {
+		l.sink = zapcore.Lock(os.Stderr)
+	} else {
+		maxSize := int(c.MaxSizeMB)
+		if maxSize == 0 {
+			maxSize = defaultMaxSizeMB
+		}
+
+		file := &lumberjack.Logger{
+			Filename:   c.File,
+			MaxSize:    maxSize,
+			MaxBackups: int(c.MaxBackups),
+			MaxAge:     int(c.MaxAgeDays),
+			Compress:   c.Compress,
+		}
+		l.sink = zapcore.AddSync(file)
+		l.closer = file
+	}
+
+	for _, component := range []string{Mir, Noise, App, Transport} {
+		l.levels[component] = zap.NewAtomicLevel()
+	}
+
+	if err := l.SetLevels(c); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// redactedFields are the keys of fields which are dropped from every entry,
+// as noise logs the node's private key, and each session key.
+var redactedFields = map[string]struct{}{
+	"private_key": {},
+	"session_key": {},
+}
+
+// redactCore drops the redacted fields before they reach the wrapped core.
+type redactCore struct {
+	zapcore.Core
+}
+
+func (r redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{Core: r.Core.With(redact(fields))}
+}
+
+func (r redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if r.Enabled(entry.Level) {
+		return checked.AddCore(entry, r)
+	}
+	return checked
+}
+
+func (r redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return r.Core.Write(entry, redact(fields))
+}
+
+func redact(fields []zapcore.Field) []zapcore.Field {
+	result := make([]zapcore.Field, 0, len(fields))
+	for _, field := range fields {
+		if _, ok := redactedFields[field.Key]; !ok {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// Named returns the logger of the given component.
+func (l *Loggers) Named(component string) *zap.SugaredLogger {
+	level, ok := l.levels[component]
+	if !ok {
+		panic(errors.Errorf("unknown logging component '%s'", component))
+	}
+
+	core := redactCore{Core: zapcore.NewCore(l.encoder, l.sink, level)}
+	return zap.New(core, zap.AddCaller()).Named(component).With(l.fields...).Sugar()
+}
+
+// SetLevels applies the levels from the config to each component.
+func (l *Loggers) SetLevels(c *config.Logging) error {
+	levels := map[string]string{
+		Mir:       c.Levels.Mir,
+		Noise:     c.Levels.Noise,
+		App:       c.Levels.App,
+		Transport: c.Levels.Transport,
+	}
+
+	for component, name := range levels {
+		if name == "" {
+			name = c.Level
+		}
+		if name == "" {
+			name = "info"
+		}
+
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return errors.WithMessagef(err, "invalid level for %s", component)
+		}
+		l.levels[component].SetLevel(level)
+	}
+
+	return nil
+}
+
+// Close flushes any buffered entries, and closes the log file, if any.
+func (l *Loggers) Close() error {
+	l.sink.Sync()
+	if l.closer != nil {
+		return l.closer.Close()
+	}
+	return nil
+}
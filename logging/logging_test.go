@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLoggersLevels(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.Logging
+
+		// logged lists the components whose debug and info entries are
+		// expected, in the order they are logged.
+		logged []string
+	}{
+		{
+			name:   "default info",
+			logged: []string{"mir info", "noise info", "app info", "transport info"},
+		},
+		{
+			name:   "debug",
+			config: config.Logging{Level: "debug"},
+			logged: []string{"mir debug", "mir info", "noise debug", "noise info", "app debug", "app info", "transport debug", "transport info"},
+		},
+		{
+			name: "per component",
+			config: config.Logging{
+				Level: "warn",
+				Levels: config.LogLevels{
+					Mir: "debug",
+					App: "info",
+				},
+			},
+			logged: []string{"mir debug", "mir info", "app info"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Encoding = "json"
+			tt.config.File = filepath.Join(t.TempDir(), "node.log")
+
+			loggers, err := New(&tt.config, zap.Uint64("node_id", 3))
+			require.NoError(t, err)
+
+			for _, component := range []string{Mir, Noise, App, Transport} {
+				logger := loggers.Named(component)
+				logger.Debugw(component+" debug", "seq_no", 1)
+				logger.Infow(component+" info", "seq_no", 1, "private_key", "secret")
+			}
+			require.NoError(t, loggers.Close())
+
+			f, err := os.Open(tt.config.File)
+			require.NoError(t, err)
+			defer f.Close()
+
+			var logged []string
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				entry := map[string]interface{}{}
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+				assert.Equal(t, float64(3), entry["node_id"])
+				assert.Equal(t, float64(1), entry["seq_no"])
+				assert.NotContains(t, entry, "private_key")
+				logged = append(logged, entry["msg"].(string))
+			}
+			assert.Equal(t, tt.logged, logged)
+		})
+	}
+}
+
+func TestLoggersSetLevels(t *testing.T) {
+	c := &config.Logging{File: filepath.Join(t.TempDir(), "node.log")}
+	loggers, err := New(c)
+	require.NoError(t, err)
+	defer loggers.Close()
+
+	logger := loggers.Named(Noise)
+	assert.False(t, logger.Desugar().Core().Enabled(zap.DebugLevel))
+
+	require.NoError(t, loggers.SetLevels(&config.Logging{Levels: config.LogLevels{Noise: "debug"}}))
+	assert.True(t, logger.Desugar().Core().Enabled(zap.DebugLevel))
+	assert.False(t, loggers.Named(App).Desugar().Core().Enabled(zap.DebugLevel))
+
+	assert.Error(t, loggers.SetLevels(&config.Logging{Level: "verbose"}))
+}
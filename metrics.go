@@ -0,0 +1,233 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsNamespace prefixes the name of every metric the node exports.
+const metricsNamespace = network.MetricsNamespace
+
+// metrics are the Prometheus metrics describing the node's progress.
+type metrics struct {
+	committedSeqNo  prometheus.Gauge
+	requestsApplied prometheus.Counter
+	batchSize       prometheus.Histogram
+	checkpointSeqNo prometheus.Gauge
+	checkpoints     prometheus.Counter
+	snapshotSeqNo   prometheus.Gauge
+	epoch           prometheus.Gauge
+	epochChanges    prometheus.Counter
+	clientProposals *prometheus.CounterVec
+	walEntries      prometheus.Gauge
+	walWrites       prometheus.Counter
+	walTruncations  prometheus.Counter
+}
+
+func newMetrics(registerer prometheus.Registerer, wal WAL, reqStore RequestStore) *metrics {
+	m := &metrics{
+		committedSeqNo: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "committed_seq_no",
+			Help:      "The sequence number of the last entry applied.",
+		}),
+		requestsApplied: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_applied_total",
+			Help:      "The number of requests applied since the node started.",
+		}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "batch_size_requests",
+			Help:      "The number of requests in each entry applied.",
+			Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500},
+		}),
+		checkpointSeqNo: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "checkpoint_seq_no",
+			Help:      "The sequence number of the last checkpoint persisted.",
+		}),
+		checkpoints: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "checkpoints_total",
+			Help:      "The number of checkpoints persisted since the node started.",
+		}),
+		snapshotSeqNo: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshot_seq_no",
+			Help:      "The sequence number of the last stable checkpoint snapshot persisted.",
+		}),
+		epoch: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "epoch",
+			Help:      "The number of the last epoch the node entered.",
+		}),
+		epochChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "epoch_changes_total",
+			Help:      "The number of epoch changes the node has begun since it started.",
+		}),
+		clientProposals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "client_proposals_total",
+			Help:      "The number of requests proposed by clients, by result, either accepted or the error code replied.",
+		}, []string{"result"}),
+		walEntries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "wal_entries",
+			Help:      "The number of entries in the WAL.",
+		}),
+		walWrites: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "wal_writes_total",
+			Help:      "The number of entries written to the WAL since the node started.",
+		}),
+		walTruncations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "wal_truncations_total",
+			Help:      "The number of times the WAL has been truncated since the node started.",
+		}),
+	}
+
+	registerer.MustRegister(
+		m.committedSeqNo,
+		m.requestsApplied,
+		m.batchSize,
+		m.checkpointSeqNo,
+		m.checkpoints,
+		m.snapshotSeqNo,
+		m.epoch,
+		m.epochChanges,
+		m.clientProposals,
+		m.walEntries,
+		m.walWrites,
+		m.walTruncations,
+	)
+
+	// The size of each store is reported if it is known.
+	if s, ok := wal.(sizer); ok {
+		registerer.MustRegister(sizeGauge("wal_size_bytes", "The size of the WAL, on disk or in memory.", s))
+	}
+	if s, ok := reqStore.(sizer); ok {
+		registerer.MustRegister(sizeGauge("request_store_size_bytes", "The size of the request store, on disk or in memory.", s))
+	}
+
+	return m
+}
+
+// sizeGauge reports the size of the store when scraped.
+func sizeGauge(name, help string, store sizer) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      name,
+		Help:      help,
+	}, func() float64 {
+		return float64(store.Size())
+	})
+}
+
+func (m *metrics) applied(entry *pb.QEntry) {
+	m.committedSeqNo.Set(float64(entry.SeqNo))
+	m.requestsApplied.Add(float64(len(entry.Requests)))
+	m.batchSize.Observe(float64(len(entry.Requests)))
+}
+
+// proposed records the result of a client's proposal.
+func (m *metrics) proposed(err error) {
+	result := "accepted"
+	if err != nil {
+		result = string(network.ErrorInternal)
+		if clientErr, ok := errors.Cause(err).(*network.ClientError); ok {
+			result = string(clientErr.Code)
+		}
+	}
+	m.clientProposals.WithLabelValues(result).Inc()
+}
+
+// persisted tracks the checkpoint and epoch progress recorded by an entry
+// in the WAL.  Entries loaded when recovering restore the gauges, but are not
+// counted again.
+func (m *metrics) persisted(p *pb.Persistent, written bool) {
+	switch t := p.Type.(type) {
+	case *pb.Persistent_CEntry:
+		m.checkpointSeqNo.Set(float64(t.CEntry.SeqNo))
+		if written {
+			m.checkpoints.Inc()
+		}
+	case *pb.Persistent_NEntry:
+		m.epoch.Set(float64(t.NEntry.EpochConfig.Number))
+	case *pb.Persistent_ECEntry:
+		if written {
+			m.epochChanges.Inc()
+		}
+	}
+}
+
+func (m *metrics) snapshotPersisted(seqNo uint64) {
+	m.snapshotSeqNo.Set(float64(seqNo))
+}
+
+// meteredWAL observes the entries written to and loaded from the WAL.
+type meteredWAL struct {
+	metrics *metrics
+	wal     processor.WAL
+
+	// first and last are the indices of the oldest and newest entries.
+	first, last uint64
+}
+
+func (mw *meteredWAL) Write(index uint64, entry *pb.Persistent) error {
+	err := mw.wal.Write(index, entry)
+	if err != nil {
+		return err
+	}
+
+	mw.metrics.walWrites.Inc()
+	mw.metrics.persisted(entry, true)
+	if mw.first == 0 {
+		mw.first = index
+	}
+	mw.last = index
+	mw.metrics.walEntries.Set(float64(mw.last - mw.first + 1))
+	return nil
+}
+
+func (mw *meteredWAL) Truncate(index uint64) error {
+	err := mw.wal.Truncate(index)
+	if err != nil {
+		return err
+	}
+
+	mw.metrics.walTruncations.Inc()
+	if index > mw.first {
+		mw.first = index
+	}
+	mw.metrics.walEntries.Set(float64(mw.last - mw.first + 1))
+	return nil
+}
+
+func (mw *meteredWAL) Sync() error {
+	return mw.wal.Sync()
+}
+
+func (mw *meteredWAL) LoadAll(forEach func(index uint64, p *pb.Persistent)) error {
+	mw.first, mw.last = 0, 0
+	return mw.wal.LoadAll(func(index uint64, p *pb.Persistent) {
+		mw.metrics.persisted(p, false)
+		if mw.first == 0 {
+			mw.first = index
+		}
+		mw.last = index
+		mw.metrics.walEntries.Set(float64(mw.last - mw.first + 1))
+		forEach(index, p)
+	})
+}
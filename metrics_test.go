@@ -0,0 +1,198 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/logging"
+	"github.com/perlin-network/noise"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// freePort returns a loopback address which was free to listen on.
+func freePort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// testNetwork generates the configs of a network of the given number of nodes
+// and one client, listening on the loopback interface.
+func testNetwork(t *testing.T, nodes int) ([]*config.NodeConfig, *config.ClientConfig) {
+	template := config.NodeConfig{
+		Version: config.CurrentVersion,
+		MirRuntime: config.MirRuntime{
+			TickInterval:         50 * time.Millisecond,
+			HeartbeatTicks:       2,
+			SuspectTicks:         20,
+			NewEpochTimeoutTicks: 40,
+			BatchSize:            20,
+			BufferSize:           5 * 1024 * 1024,
+		},
+		MirBootstrap: config.MirBootstrap{
+			NumberOfBuckets:    1,
+			ClientWindowSize:   100,
+			CheckpointInterval: 20,
+		},
+	}
+
+	var privKeys []string
+	for i := 0; i < nodes; i++ {
+		pubKey, privKey, err := noise.GenerateKeys(nil)
+		require.NoError(t, err)
+		template.Nodes = append(template.Nodes, config.Node{
+			ID:        uint64(i),
+			Address:   freePort(t),
+			PublicKey: pubKey.String(),
+		})
+		privKeys = append(privKeys, privKey.String())
+	}
+
+	pubKey, privKey, err := noise.GenerateKeys(nil)
+	require.NoError(t, err)
+	template.Clients = []config.Client{{ID: 0, PublicKey: pubKey.String()}}
+
+	var nodeConfigs []*config.NodeConfig
+	for i := range template.Nodes {
+		nc := template
+		nc.ID = uint64(i)
+		nc.ListenAddress = template.Nodes[i].Address
+		nc.PrivateKey = privKeys[i]
+		nodeConfigs = append(nodeConfigs, &nc)
+	}
+
+	return nodeConfigs, &config.ClientConfig{
+		Version:    config.CurrentVersion,
+		ID:         0,
+		PrivateKey: privKey.String(),
+		Nodes:      template.Nodes,
+	}
+}
+
+// peerCounter returns the value of a per peer counter of the transport.
+func peerCounter(t *testing.T, registry *prometheus.Registry, name string, peer uint64) float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if labelValue(metric, "peer") == strconv.FormatUint(peer, 10) {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestMetrics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a network")
+	}
+
+	const requestCount = 50
+	nodeConfigs, clientConfig := testNetwork(t, 4)
+	loggingConfig := &config.Logging{Level: "error"}
+
+	var servers []*Server
+	var registries []*prometheus.Registry
+	errC := make(chan error, len(nodeConfigs))
+	for _, nc := range nodeConfigs {
+		loggers, err := logging.New(loggingConfig)
+		require.NoError(t, err)
+		registry := prometheus.NewRegistry()
+		server := &Server{
+			Loggers:      loggers,
+			NodeConfig:   nc,
+			WAL:          NewMemWAL(),
+			RequestStore: NewMemRequestStore(),
+			Registerer:   registry,
+		}
+		servers = append(servers, server)
+		registries = append(registries, registry)
+	}
+	for _, server := range servers {
+		go func(server *Server) {
+			errC <- server.Run()
+		}(server)
+	}
+	defer func() {
+		for _, server := range servers {
+			server.Stop()
+		}
+	}()
+
+	// Clients are known to the nodes once they begin committing.
+	require.Eventually(t, func() bool {
+		for _, server := range servers {
+			if !server.Readiness().Healthy {
+				return false
+			}
+		}
+		return true
+	}, 20*time.Second, 50*time.Millisecond)
+
+	loggers, err := logging.New(loggingConfig)
+	require.NoError(t, err)
+	client := &Client{
+		Loggers:        loggers,
+		ClientConfig:   clientConfig,
+		RequestTimeout: 5 * time.Second,
+		MaxAttempts:    5,
+	}
+	require.NoError(t, client.Run(requestCount, 64))
+
+	for i, registry := range registries {
+		require.Eventually(t, func() bool {
+			return testutil.ToFloat64(servers[i].app.metrics.requestsApplied) == requestCount
+		}, 10*time.Second, 10*time.Millisecond, "node %d applies every request", i)
+
+		problems, err := testutil.GatherAndLint(registry)
+		require.NoError(t, err)
+		assert.Empty(t, problems, "node %d", i)
+
+		m := servers[i].app.metrics
+		assert.NotZero(t, testutil.ToFloat64(m.committedSeqNo), "node %d", i)
+		assert.Equal(t, float64(1), testutil.ToFloat64(m.epoch), "node %d", i)
+		assert.NotZero(t, testutil.ToFloat64(m.walWrites), "node %d", i)
+
+		for peer := range nodeConfigs {
+			if peer == i {
+				continue
+			}
+			assert.NotZero(t, peerCounter(t, registry, "mirsample_peer_sent_messages_total", uint64(peer)), "node %d to peer %d", i, peer)
+			assert.NotZero(t, peerCounter(t, registry, "mirsample_peer_received_messages_total", uint64(peer)), "node %d from peer %d", i, peer)
+			assert.NotZero(t, peerCounter(t, registry, "mirsample_peer_received_bytes_total", uint64(peer)), "node %d from peer %d", i, peer)
+		}
+	}
+
+	select {
+	case err := <-errC:
+		t.Fatalf("node stopped running: %v", err)
+	default:
+	}
+}
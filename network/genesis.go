@@ -0,0 +1,193 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/perlin-network/noise"
+	"github.com/pkg/errors"
+)
+
+const (
+	// helloTimeout bounds how long a node waits for a peer to answer its
+	// introduction, including dialing the peer.
+	helloTimeout = 5 * time.Second
+
+	// helloRetryInterval is how long a node waits before introducing
+	// itself again to a peer it could not reach or whose genesis differs,
+	// dropping the messages for that peer in the meantime.
+	helloRetryInterval = 5 * time.Second
+)
+
+// Hello is sent as a request by a node to each peer before any consensus
+// messages, over each new connection, and the peer replies with a Hello of
+// its own.  Each side refuses the other unless their genesis hashes match.
+type Hello struct {
+	GenesisHash []byte `json:"genesis_hash"`
+}
+
+// genesisCheck tracks which peers have confirmed that they share this node's
+// genesis, in each direction.
+type genesisCheck struct {
+	hash []byte
+
+	// introduced records the peers which have accepted our hello, and so
+	// may be sent consensus messages.
+	introduced map[uint64]bool
+
+	// retryAt records when to next attempt to introduce ourselves to peers
+	// which could not be reached or which have a different genesis.
+	retryAt map[uint64]time.Time
+
+	// verified records the peers whose hello matched our genesis, and so
+	// whose consensus messages are accepted.
+	verified map[uint64]bool
+}
+
+// introduce ensures that the peer has accepted our hello, sending it if this
+// is a new connection, and returns false if messages for the peer should be
+// dropped.
+func (t *ServerTransport) introduce(dest uint64, addr string) bool {
+	t.mutex.Lock()
+	introduced, retryAt := t.genesis.introduced[dest], t.genesis.retryAt[dest]
+	t.mutex.Unlock()
+
+	if introduced {
+		return true
+	}
+
+	if time.Now().Before(retryAt) {
+		return false
+	}
+
+	err := t.hello(addr)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err != nil {
+		t.logger.Warnw("Could not introduce ourselves to node, dropping messages for it", "peer", dest, "error", err)
+		t.genesis.retryAt[dest] = time.Now().Add(helloRetryInterval)
+		return false
+	}
+
+	t.genesis.introduced[dest] = true
+	return true
+}
+
+func (t *ServerTransport) hello(addr string) error {
+	data, err := json.Marshal(&Hello{GenesisHash: t.genesis.hash})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), helloTimeout)
+	defer cancel()
+
+	res, err := t.node.Request(ctx, addr, data)
+	if err != nil {
+		return err
+	}
+
+	reply := &Hello{}
+	if err := json.Unmarshal(res, reply); err != nil {
+		return errors.WithMessage(err, "could not decode hello")
+	}
+
+	if !bytes.Equal(reply.GenesisHash, t.genesis.hash) {
+		return errors.Errorf("its genesis hash %x does not match ours %x", reply.GenesisHash, t.genesis.hash)
+	}
+
+	return nil
+}
+
+// handleHello records whether the peer's genesis matches ours, and replies
+// with our own so that the peer may check it likewise.
+func (t *ServerTransport) handleHello(nodeID uint64, ctx *noise.HandlerContext) error {
+	hello := &Hello{}
+	if err := json.Unmarshal(ctx.Data(), hello); err != nil {
+		return errors.WithMessagef(err, "could not decode hello from node %d", nodeID)
+	}
+
+	match := bytes.Equal(hello.GenesisHash, t.genesis.hash)
+	if !match {
+		t.logger.Errorw("Refusing node, its genesis hash does not match ours", "peer", nodeID, "genesis_hash", hex.EncodeToString(hello.GenesisHash), "our_genesis_hash", hex.EncodeToString(t.genesis.hash))
+	}
+
+	t.mutex.Lock()
+	t.genesis.verified[nodeID] = match
+	t.mutex.Unlock()
+
+	data, err := json.Marshal(&Hello{GenesisHash: t.genesis.hash})
+	if err != nil {
+		return err
+	}
+
+	return ctx.Send(data)
+}
+
+func (t *ServerTransport) isVerified(nodeID uint64) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.genesis.verified[nodeID]
+}
+
+// peerDisconnected forgets that the peer accepted our hello, so that we
+// introduce ourselves again over the next connection.  Inbound and outbound
+// connections cannot be told apart here, so this may cause a needless hello.
+func (t *ServerTransport) peerDisconnected(client *noise.Client) {
+	nodeID, ok := t.pubkey2nodeid[client.ID().ID]
+	if !ok {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.genesis.introduced, nodeID)
+}
+
+// PeerStatus describes this node's connectivity with a peer.
+type PeerStatus struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+
+	// Introduced is whether the peer has accepted our hello over our
+	// current connection to it, so that it is sent consensus messages.
+	Introduced bool `json:"introduced"`
+
+	// Verified is whether the peer has introduced itself with a genesis
+	// matching ours, so that its consensus messages are accepted.
+	Verified bool `json:"verified"`
+}
+
+// Peers reports the connectivity with each peer, ordered by node ID.
+func (t *ServerTransport) Peers() []PeerStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var peers []PeerStatus
+	for id, addr := range t.id2addr {
+		if id == t.id {
+			continue
+		}
+		peers = append(peers, PeerStatus{
+			ID:         id,
+			Address:    addr,
+			Introduced: t.genesis.introduced[id],
+			Verified:   t.genesis.verified[id],
+		})
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
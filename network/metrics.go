@@ -0,0 +1,63 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package network
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsNamespace prefixes the name of every metric the node exports.
+const MetricsNamespace = "mirsample"
+
+// Metrics count the consensus traffic exchanged with each peer, labelled by
+// the peer's node ID.
+type Metrics struct {
+	bytesSent        *prometheus.CounterVec
+	messagesSent     *prometheus.CounterVec
+	sendFailures     *prometheus.CounterVec
+	bytesReceived    *prometheus.CounterVec
+	messagesReceived *prometheus.CounterVec
+}
+
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	counter := func(name, help string) *prometheus.CounterVec {
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: "peer",
+			Name:      name,
+			Help:      help,
+		}, []string{"peer"})
+		registerer.MustRegister(c)
+		return c
+	}
+
+	return &Metrics{
+		bytesSent:        counter("sent_bytes_total", "The number of bytes of consensus messages sent to the peer."),
+		messagesSent:     counter("sent_messages_total", "The number of consensus messages sent to the peer."),
+		sendFailures:     counter("send_failures_total", "The number of consensus messages which could not be sent to the peer, including those dropped while it is unreachable or its genesis differs."),
+		bytesReceived:    counter("received_bytes_total", "The number of bytes of consensus messages received from the peer."),
+		messagesReceived: counter("received_messages_total", "The number of consensus messages received from the peer."),
+	}
+}
+
+func (m *Metrics) sent(nodeID uint64, size int) {
+	peer := strconv.FormatUint(nodeID, 10)
+	m.bytesSent.WithLabelValues(peer).Add(float64(size))
+	m.messagesSent.WithLabelValues(peer).Inc()
+}
+
+func (m *Metrics) sendFailed(nodeID uint64) {
+	m.sendFailures.WithLabelValues(strconv.FormatUint(nodeID, 10)).Inc()
+}
+
+func (m *Metrics) received(nodeID uint64, size int) {
+	peer := strconv.FormatUint(nodeID, 10)
+	m.bytesReceived.WithLabelValues(peer).Add(float64(size))
+	m.messagesReceived.WithLabelValues(peer).Inc()
+}
@@ -0,0 +1,145 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ClientMsg is the envelope for every message a client sends to a node, and
+// for every message a node pushes to a subscribed client.  Exactly one of the
+// message fields should be set.
+type ClientMsg struct {
+	NextReqNo      *NextReqNo      `json:"next_req_no,omitempty"`
+	Propose        *Propose        `json:"propose,omitempty"`
+	Subscribe      *Subscribe      `json:"subscribe,omitempty"`
+	KeepAlive      *KeepAlive      `json:"keep_alive,omitempty"`
+	CommittedEntry *CommittedEntry `json:"committed_entry,omitempty"`
+
+	// TraceParent is the W3C trace context of the client's span sending the
+	// message, if traced, so that the node's spans join the client's trace.
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// ClientReply is the envelope for the response a node returns when a client
+// message is sent as a request.  Along with the field corresponding to the
+// request, the node includes the client's current window when known.  If the
+// request failed, Error is set.
+type ClientReply struct {
+	NextReqNo *NextReqNoReply `json:"next_req_no,omitempty"`
+	Window    *Window         `json:"window,omitempty"`
+	Error     *ClientError    `json:"error,omitempty"`
+}
+
+// Window is the range of request numbers, inclusive, which the node will
+// currently accept from a client.  It advances as the client's requests
+// commit and checkpoints are taken.
+type Window struct {
+	LowWatermark  uint64 `json:"low_watermark"`
+	HighWatermark uint64 `json:"high_watermark"`
+}
+
+type ErrorCode string
+
+const (
+	// ErrorOutsideWindow indicates that the request number is beyond the
+	// client's current window, the request may be resent once it advances.
+	ErrorOutsideWindow ErrorCode = "outside_window"
+
+	// ErrorBadRequest indicates that the message was malformed, or is not
+	// permitted for this client.
+	ErrorBadRequest ErrorCode = "bad_request"
+
+	// ErrorInternal indicates that the node failed to process an otherwise
+	// valid message.
+	ErrorInternal ErrorCode = "internal"
+)
+
+type ClientError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (ce *ClientError) Error() string {
+	return fmt.Sprintf("%s: %s", ce.Code, ce.Message)
+}
+
+// NextReqNo asks the node for the next request number it expects from the client.
+type NextReqNo struct{}
+
+type NextReqNoReply struct {
+	ReqNo uint64 `json:"req_no"`
+}
+
+// Propose asks the node to propose a request on behalf of the client.
+type Propose struct {
+	ClientID uint64 `json:"client_id"`
+	ReqNo    uint64 `json:"req_no"`
+	Data     []byte `json:"data"`
+}
+
+// Subscribe asks the node to push every entry it applies, beginning with
+// FromSeqNo, back to the client as CommittedEntry messages.  A client has at
+// most one subscription per node, so subscribing again replaces any prior
+// subscription.  Subscribe is sent one-way rather than as a request.  The
+// node ends the subscription once TimeoutMillis pass without the client
+// sending a KeepAlive, or if zero, once the node's maximum timeout passes.
+type Subscribe struct {
+	FromSeqNo     uint64 `json:"from_seq_no"`
+	IncludeData   bool   `json:"include_data"`
+	TimeoutMillis uint64 `json:"timeout_millis,omitempty"`
+}
+
+// KeepAlive renews the client's subscription with the node, it is sent
+// one-way, and is ignored if the client holds no subscription.
+type KeepAlive struct{}
+
+// CommittedEntry is pushed to subscribed clients for each applied sequence
+// number.  If the subscription cannot be served, for instance because the
+// requested sequence number is no longer retained, Error is set and the
+// subscription ends.
+type CommittedEntry struct {
+	SeqNo    uint64              `json:"seq_no"`
+	Requests []*CommittedRequest `json:"requests"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// CommittedRequest identifies a request within a committed entry.  Data is
+// only populated if the subscription asked for it.
+type CommittedRequest struct {
+	ClientID uint64 `json:"client_id"`
+	ReqNo    uint64 `json:"req_no"`
+	Digest   []byte `json:"digest"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+func MarshalClientMsg(msg *ClientMsg) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func UnmarshalClientMsg(data []byte) (*ClientMsg, error) {
+	msg := &ClientMsg{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, errors.WithMessage(err, "could not decode client message")
+	}
+	return msg, nil
+}
+
+func MarshalClientReply(reply *ClientReply) ([]byte, error) {
+	return json.Marshal(reply)
+}
+
+func UnmarshalClientReply(data []byte) (*ClientReply, error) {
+	reply := &ClientReply{}
+	if err := json.Unmarshal(data, reply); err != nil {
+		return nil, errors.WithMessage(err, "could not decode client reply")
+	}
+	return reply, nil
+}
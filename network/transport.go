@@ -9,12 +9,14 @@ package network
 import (
 	"context"
 	"encoding/hex"
-	"fmt"
 	"net"
 	"strconv"
+	"sync"
+	"time"
 
 	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
 	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/logging"
 	"github.com/perlin-network/noise"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -24,13 +26,16 @@ import (
 type ClientTransport struct {
 	logger *zap.SugaredLogger
 
-	id      uint64
-	id2addr map[uint64]string
+	id            uint64
+	id2addr       map[uint64]string
+	pubkey2nodeid map[noise.PublicKey]uint64
 
 	node *noise.Node
 }
 
-func NewClientTransport(logger *zap.SugaredLogger, config *config.ClientConfig) (*ClientTransport, error) {
+func NewClientTransport(loggers *logging.Loggers, config *config.ClientConfig) (*ClientTransport, error) {
+	logger := loggers.Named(logging.Transport)
+
 	id2addr := make(map[uint64]string)
 	pubkey2nodeid := make(map[noise.PublicKey]uint64)
 	for _, p := range config.Nodes {
@@ -44,7 +49,7 @@ func NewClientTransport(logger *zap.SugaredLogger, config *config.ClientConfig)
 
 		id2addr[p.ID] = p.Address
 		pubkey2nodeid[pubkey] = p.ID
-		fmt.Printf("Adding mapping from %x to node %d\n", pubkeyBytes, p.ID)
+		logger.Debugw("Adding mapping from public key to node", "public_key", p.PublicKey, "peer", p.ID)
 	}
 
 	key, err := hex.DecodeString(config.PrivateKey)
@@ -56,20 +61,41 @@ func NewClientTransport(logger *zap.SugaredLogger, config *config.ClientConfig)
 
 	node, err := noise.NewNode(
 		noise.WithNodePrivateKey(privkey),
-		noise.WithNodeLogger(logger.Named("noise").Desugar()),
+		noise.WithNodeLogger(loggers.Named(logging.Noise).Desugar()),
 	)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ClientTransport{
-		logger:  logger,
-		id:      config.ID,
-		id2addr: id2addr,
-		node:    node,
+		logger:        logger,
+		id:            config.ID,
+		id2addr:       id2addr,
+		pubkey2nodeid: pubkey2nodeid,
+		node:          node,
 	}, nil
 }
 
+// Handle registers a handler for messages which nodes push to this client,
+// it must be invoked before Start.
+func (t *ClientTransport) Handle(handler func(nodeID uint64, msg *ClientMsg)) {
+	t.node.Handle(func(ctx noise.HandlerContext) error {
+		nodeID, ok := t.pubkey2nodeid[ctx.ID().ID]
+		if !ok {
+			t.logger.Warnf("Unknown remote: %+v", ctx.ID())
+			return errors.Errorf("unknown node")
+		}
+
+		msg, err := UnmarshalClientMsg(ctx.Data())
+		if err != nil {
+			return err
+		}
+
+		handler(nodeID, msg)
+		return nil
+	})
+}
+
 func (t *ClientTransport) Start() error {
 	t.logger.Infof("Start listening on %s...", t.node.Addr())
 	return t.node.Listen()
@@ -80,17 +106,40 @@ func (t *ClientTransport) Close() {
 	t.node.Close()
 }
 
-func (t *ClientTransport) Request(dest uint64, data []byte) ([]byte, error) {
+// Request sends the message to the given node and blocks until the node
+// replies, or until the context ends.  If the node replies with an error,
+// the reply is returned along with its *ClientError.
+func (t *ClientTransport) Request(ctx context.Context, dest uint64, msg *ClientMsg) (*ClientReply, error) {
+	data, err := MarshalClientMsg(msg)
+	if err != nil {
+		panic("Failed to marshal outbound message")
+	}
+
 	addr, ok := t.id2addr[dest]
 	if !ok {
 		panic("Unknown remote")
 	}
 
-	return t.node.Request(context.TODO(), addr, data)
+	res, err := t.node.Request(ctx, addr, data)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := UnmarshalClientReply(res)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.Error != nil {
+		return reply, reply.Error
+	}
+
+	return reply, nil
 }
 
-func (t *ClientTransport) Send(dest uint64, msg *pb.Request) error {
-	data, err := proto.Marshal(msg)
+// Send delivers the message to the given node without waiting for a reply.
+func (t *ClientTransport) Send(ctx context.Context, dest uint64, msg *ClientMsg) error {
+	data, err := MarshalClientMsg(msg)
 	if err != nil {
 		panic("Failed to marshal outbound message")
 	}
@@ -100,7 +149,7 @@ func (t *ClientTransport) Send(dest uint64, msg *pb.Request) error {
 		panic("Unknown remote")
 	}
 
-	return t.node.Send(context.TODO(), addr, data)
+	return t.node.Send(ctx, addr, data)
 }
 
 type ServerTransport struct {
@@ -113,11 +162,28 @@ type ServerTransport struct {
 
 	nodeHandler Handler
 	node        *noise.Node
+	metrics     *Metrics
+
+	mutex     sync.Mutex
+	genesis   genesisCheck
+	listening bool
 }
 
+// Handler processes a message from a node or client.  If the message was sent
+// as a request, the returned data is sent back as the response.
 type Handler func(id uint64, data []byte) ([]byte, error)
 
-func NewServerTransport(logger *zap.SugaredLogger, config *config.NodeConfig) (*ServerTransport, error) {
+// Stream sends a message back over the connection on which a client message
+// arrived.  It may be retained and invoked any number of times, so long as the
+// client message was not sent as a request.
+type Stream func(data []byte) error
+
+// ClientHandler is a Handler which is additionally supplied a Stream to the client.
+type ClientHandler func(id uint64, data []byte, stream Stream) ([]byte, error)
+
+func NewServerTransport(loggers *logging.Loggers, config *config.NodeConfig, metrics *Metrics) (*ServerTransport, error) {
+	logger := loggers.Named(logging.Transport)
+
 	id2addr := make(map[uint64]string)
 	pubkey2nodeid := make(map[noise.PublicKey]uint64)
 	for _, p := range config.Nodes {
@@ -131,7 +197,7 @@ func NewServerTransport(logger *zap.SugaredLogger, config *config.NodeConfig) (*
 
 		id2addr[p.ID] = p.Address
 		pubkey2nodeid[pubkey] = p.ID
-		fmt.Printf("Adding mapping from %x to node %d\n", pubkeyBytes, p.ID)
+		logger.Debugw("Adding mapping from public key to node", "public_key", p.PublicKey, "peer", p.ID)
 	}
 
 	pubkey2clientid := make(map[noise.PublicKey]uint64)
@@ -145,7 +211,7 @@ func NewServerTransport(logger *zap.SugaredLogger, config *config.NodeConfig) (*
 		copy(pubkey[:], pubkeyBytes)
 
 		pubkey2clientid[pubkey] = c.ID
-		fmt.Printf("Adding mapping from %x to client %d\n", pubkeyBytes, c.ID)
+		logger.Debugw("Adding mapping from public key to client", "public_key", c.PublicKey, "client_id", c.ID)
 	}
 
 	key, err := hex.DecodeString(config.PrivateKey)
@@ -155,28 +221,16 @@ func NewServerTransport(logger *zap.SugaredLogger, config *config.NodeConfig) (*
 	var privkey noise.PrivateKey
 	copy(privkey[:], key)
 
-	addr, port, err := net.SplitHostPort(config.ListenAddress)
-	if err != nil {
-		return nil, err
-	}
-
-	ip := net.ParseIP(addr)
-
-	p, err := strconv.ParseInt(port, 0, 16)
+	bindHost, bindPort, err := bindAddress(config)
 	if err != nil {
 		return nil, err
 	}
 
-	id := noise.ID{
-		ID:   privkey.Public(),
-		Host: ip,
-		Port: uint16(p),
-	}
 	node, err := noise.NewNode(
 		noise.WithNodePrivateKey(privkey),
-		noise.WithNodeID(id),
-		noise.WithNodeLogger(logger.Named("noise").Desugar()),
-		noise.WithNodeBindPort(uint16(p)),
+		noise.WithNodeLogger(loggers.Named(logging.Noise).Desugar()),
+		noise.WithNodeBindHost(bindHost),
+		noise.WithNodeBindPort(bindPort),
 	)
 	if err != nil {
 		return nil, err
@@ -189,23 +243,70 @@ func NewServerTransport(logger *zap.SugaredLogger, config *config.NodeConfig) (*
 		pubkey2nodeid:   pubkey2nodeid,
 		pubkey2clientid: pubkey2clientid,
 		node:            node,
+		metrics:         metrics,
+		genesis: genesisCheck{
+			hash:       config.Genesis().Hash(),
+			introduced: map[uint64]bool{},
+			retryAt:    map[uint64]time.Time{},
+			verified:   map[uint64]bool{},
+		},
 	}, nil
 }
 
-func (t *ServerTransport) Handle(nodeHandler, clientHandler Handler) {
+// bindAddress returns the host and port the node should listen on.  When no
+// bind address is configured, the node listens on all interfaces using the
+// port of the address it advertises to peers and clients.
+func bindAddress(config *config.NodeConfig) (net.IP, uint16, error) {
+	address := config.BindAddress
+	if address == "" {
+		address = config.ListenAddress
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, 0, errors.Errorf("invalid port '%s'", port)
+	}
+
+	if config.BindAddress == "" || host == "" {
+		return nil, uint16(p), nil
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, errors.Errorf("bind host '%s' is not an IP address", host)
+	}
+
+	return ip, uint16(p), nil
+}
+
+func (t *ServerTransport) Handle(nodeHandler Handler, clientHandler ClientHandler) {
 	t.node.Handle(func(ctx noise.HandlerContext) error {
 		nodeID, ok := t.pubkey2nodeid[ctx.ID().ID]
 		if ok {
-			result, err := nodeHandler(nodeID, ctx.Data())
-			if err == nil && ctx.IsRequest() {
-				return ctx.Send(result)
+			// Nodes only send each other requests to introduce themselves.
+			if ctx.IsRequest() {
+				return t.handleHello(nodeID, &ctx)
 			}
+
+			if !t.isVerified(nodeID) {
+				// Closing the connection causes a correct peer to
+				// introduce itself again.
+				return errors.Errorf("node %d has not confirmed it shares our genesis", nodeID)
+			}
+
+			t.metrics.received(nodeID, len(ctx.Data()))
+			_, err := nodeHandler(nodeID, ctx.Data())
 			return err
 		}
 
 		clientID, ok := t.pubkey2clientid[ctx.ID().ID]
 		if ok {
-			result, err := clientHandler(clientID, ctx.Data())
+			result, err := clientHandler(clientID, ctx.Data(), ctx.Send)
 			if err == nil && ctx.IsRequest() {
 				return ctx.Send(result)
 			}
@@ -215,19 +316,41 @@ func (t *ServerTransport) Handle(nodeHandler, clientHandler Handler) {
 		t.logger.Warnf("Unknown remote: %+v", ctx.ID())
 		return errors.Errorf("unknown node or client")
 	})
+	t.node.Bind(noise.Protocol{
+		OnPeerDisconnected: t.peerDisconnected,
+	})
 	t.nodeHandler = nodeHandler
 }
 
 func (t *ServerTransport) Start() error {
 	t.logger.Infof("Start listening on %s...", t.node.Addr())
-	return t.node.Listen()
+	if err := t.node.Listen(); err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	t.listening = true
+	t.mutex.Unlock()
+	return nil
 }
 
 func (t *ServerTransport) Close() {
 	t.logger.Infof("Closing transport")
+
+	t.mutex.Lock()
+	t.listening = false
+	t.mutex.Unlock()
+
 	t.node.Close()
 }
 
+// Listening returns whether the transport has started, and not been closed.
+func (t *ServerTransport) Listening() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.listening
+}
+
 func (t *ServerTransport) Send(dest uint64, msg *pb.Msg) {
 	data, err := proto.Marshal(msg)
 	if err != nil {
@@ -245,8 +368,16 @@ func (t *ServerTransport) Send(dest uint64, msg *pb.Msg) {
 		panic("Unknown remote")
 	}
 
+	if !t.introduce(dest, addr) {
+		t.metrics.sendFailed(dest)
+		return
+	}
+
 	err = t.node.Send(context.TODO(), addr, data)
 	if err != nil {
-		t.logger.Warnf("Failed to send to %s: %s", addr, err)
+		t.metrics.sendFailed(dest)
+		t.logger.Warnw("Failed to send", "peer", dest, "address", addr, "error", err)
+		return
 	}
+	t.metrics.sent(dest, len(data))
 }
@@ -0,0 +1,233 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
+	"github.com/hyperledger-labs/mirbft/pkg/statemachine"
+	"github.com/jyellick/mirbft-sample/logging"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Replay rebuilds the consensus state of a node offline from the events it
+// recorded to its event log.  Each event is applied to a fresh Mir state
+// machine, and the commits, checkpoints, and state transfers the state
+// machine requests are executed against a fresh application, as the node's
+// processor executed them.  As both are deterministic, the replay reaches the
+// state the node was in after each event.
+type Replay struct {
+	loggers *logging.Loggers
+	machine *statemachine.StateMachine
+	app     *application
+
+	// events counts the events applied.
+	events uint64
+
+	// checkpoint is the latest checkpoint the state machine has loaded from
+	// the WAL since it was initialized.
+	checkpoint *msgs.CEntry
+
+	// snapshots holds the value of each checkpoint the replayed application
+	// has taken, until the value the node recorded is replayed.
+	snapshots map[uint64][]byte
+}
+
+// NewReplay creates a replay, whose application reads the data of each
+// request it applies from the request store, if not nil.  The sample
+// application only logs the length of the data, so its state may be rebuilt
+// without it.
+func NewReplay(loggers *logging.Loggers, reqStore RequestStore) *Replay {
+	return &Replay{
+		loggers: loggers,
+		app: &application{
+			logger:    loggers.Named(logging.App),
+			reqStore:  reqStore,
+			commitLog: newCommitLog(),
+			metrics:   newMetrics(prometheus.NewRegistry(), nil, nil),
+			traces:    newRequestTraces(nopTracer),
+		},
+		snapshots: map[uint64][]byte{},
+	}
+}
+
+// Apply applies the next event recorded by the node.  An error is returned if
+// the state machine fails to apply the event, or if the event records a
+// checkpoint value which differs from that taken by the replayed application,
+// as the replay has then diverged from the node.
+func (r *Replay) Apply(event *state.Event) error {
+	switch t := event.Type.(type) {
+	case *state.Event_Initialize:
+		// Processing began, or restarted, and the state machine begins
+		// afresh, while the application carries on.
+		r.machine = &statemachine.StateMachine{Logger: (*mirLogAdapter)(r.loggers.Named(logging.Mir))}
+		r.checkpoint = nil
+		r.snapshots = map[uint64][]byte{}
+	case *state.Event_LoadPersistedEntry:
+		if cEntry := t.LoadPersistedEntry.GetEntry().GetCEntry(); cEntry != nil {
+			r.checkpoint = cEntry
+		}
+	case *state.Event_CompleteInitialization:
+		if r.checkpoint != nil {
+			r.app.restore(r.checkpoint)
+		}
+	case *state.Event_CheckpointResult:
+		if err := r.verifyCheckpoint(t.CheckpointResult); err != nil {
+			return err
+		}
+	}
+
+	if r.machine == nil {
+		return errors.Errorf("the log does not begin with the node initializing, the segments recording it may have been removed")
+	}
+
+	actions, err := r.applyEvent(event)
+	if err != nil {
+		return err
+	}
+	r.events++
+
+	results, err := processor.ProcessAppActions(r.app, appActions(actions))
+	if err != nil {
+		return err
+	}
+
+	iter := results.Iterator()
+	for result := iter.Next(); result != nil; result = iter.Next() {
+		if cr := result.GetCheckpointResult(); cr != nil {
+			r.snapshots[cr.SeqNo] = cr.Value
+		}
+	}
+
+	return nil
+}
+
+func (r *Replay) applyEvent(event *state.Event) (actions *statemachine.ActionList, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = errors.Errorf("state machine failed to apply event: %v", rec)
+		}
+	}()
+
+	return r.machine.ApplyEvent(event), nil
+}
+
+// verifyCheckpoint compares the checkpoint value the node's application
+// took with that of the replayed application.
+func (r *Replay) verifyCheckpoint(result *state.EventCheckpointResult) error {
+	value, ok := r.snapshots[result.SeqNo]
+	if !ok {
+		// The checkpoint was requested before the log began.
+		return nil
+	}
+	delete(r.snapshots, result.SeqNo)
+
+	if !bytes.Equal(value, result.Value) {
+		return errors.Errorf("the node recorded a checkpoint value at seq_no %d which differs from that of the replayed application", result.SeqNo)
+	}
+
+	return nil
+}
+
+// Events returns the number of events applied.
+func (r *Replay) Events() uint64 {
+	return r.events
+}
+
+// LastApplied returns the sequence number of the last entry the application
+// applied.
+func (r *Replay) LastApplied() uint64 {
+	return r.app.lastApplied()
+}
+
+// Status reports the consensus state of the replayed node, as the node would
+// have reported it, including the complete status of the state machine if
+// full is set.
+func (r *Replay) Status(full bool) (status *Status, err error) {
+	if r.machine == nil {
+		return nil, errors.Errorf("no events have been replayed")
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = errors.Errorf("state machine failed to report its status: %v", rec)
+		}
+	}()
+
+	sm, err := r.machine.Status()
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not get state machine status")
+	}
+
+	status = newStatus(sm)
+	status.LastCommittedSeqNo = r.app.lastApplied()
+	if full {
+		status.StateMachine = sm
+	}
+
+	return status, nil
+}
+
+// CompareStatus returns the differences in consensus state between the status
+// of a replayed node and a status the node reported, ignoring its peers.
+func CompareStatus(replayed, reported *Status) []string {
+	var diffs []string
+	compare := func(field string, replayed, reported interface{}) {
+		if fmt.Sprint(replayed) != fmt.Sprint(reported) {
+			diffs = append(diffs, fmt.Sprintf("%s: replayed %v, reported %v", field, replayed, reported))
+		}
+	}
+
+	compare("node_id", replayed.NodeID, reported.NodeID)
+	compare("epoch", replayed.Epoch, reported.Epoch)
+	compare("epoch_state", replayed.EpochState, reported.EpochState)
+	compare("leaders", replayed.Leaders, reported.Leaders)
+	compare("last_committed_seq_no", replayed.LastCommittedSeqNo, reported.LastCommittedSeqNo)
+	compare("stable_checkpoint_seq_no", replayed.StableCheckpointSeqNo, reported.StableCheckpointSeqNo)
+	compare("low_watermark", replayed.LowWatermark, reported.LowWatermark)
+	compare("high_watermark", replayed.HighWatermark, reported.HighWatermark)
+	compare("clients", replayed.Clients, reported.Clients)
+
+	return diffs
+}
+
+// appActions selects the actions the processor executes against the
+// application.
+func appActions(actions *statemachine.ActionList) *statemachine.ActionList {
+	result := &statemachine.ActionList{}
+	iter := actions.Iterator()
+	for action := iter.Next(); action != nil; action = iter.Next() {
+		switch action.Type.(type) {
+		case *state.Action_Commit, *state.Action_Checkpoint, *state.Action_StateTransfer:
+			result.PushBack(action)
+		}
+	}
+	return result
+}
+
+// mirLogAdapter logs on behalf of the replayed state machine.
+type mirLogAdapter zap.SugaredLogger
+
+func (m *mirLogAdapter) Log(level statemachine.LogLevel, msg string, pairs ...interface{}) {
+	z := (*zap.SugaredLogger)(m)
+	switch level {
+	case statemachine.LevelDebug:
+		z.Debugw(msg, pairs...)
+	case statemachine.LevelInfo:
+		z.Infow(msg, pairs...)
+	case statemachine.LevelWarn:
+		z.Warnw(msg, pairs...)
+	case statemachine.LevelError:
+		z.Errorw(msg, pairs...)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"encoding/binary"
+	"testing"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestApplicationRestore(t *testing.T) {
+	checkpoint := func(seqNo, count uint64) *pb.CEntry {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, count)
+		return &pb.CEntry{
+			SeqNo:           seqNo,
+			CheckpointValue: value,
+			NetworkState: &pb.NetworkState{
+				Clients: []*pb.NetworkState_Client{{Id: 0, Width: 100, LowWatermark: count}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		lastSeqNo  uint64
+		lastCount  uint64
+		checkpoint *pb.CEntry
+		seqNo      uint64
+		count      uint64
+	}{
+		{
+			name:       "restarted process",
+			checkpoint: checkpoint(40, 300),
+			seqNo:      40,
+			count:      300,
+		},
+		{
+			name:       "genesis",
+			checkpoint: &pb.CEntry{CheckpointValue: []byte("genesis hash")},
+		},
+		{
+			name:       "restarted processing",
+			lastSeqNo:  57,
+			lastCount:  600,
+			checkpoint: checkpoint(40, 300),
+			seqNo:      57,
+			count:      600,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: zap.NewNop().Sugar(), lastSeqNo: tt.lastSeqNo, count: tt.lastCount}
+			app.restore(tt.checkpoint)
+			assert.Equal(t, tt.seqNo, app.lastApplied())
+			assert.Equal(t, tt.count, app.count)
+		})
+	}
+}
+
+func TestReplayRequiresInitialize(t *testing.T) {
+	loggers, err := logging.New(&config.Logging{Level: "error"})
+	require.NoError(t, err)
+	defer loggers.Close()
+
+	replay := NewReplay(loggers, nil)
+	err = replay.Apply(&state.Event{Type: &state.Event_TickElapsed{TickElapsed: &state.EventTickElapsed{}}})
+	assert.EqualError(t, err, "the log does not begin with the node initializing, the segments recording it may have been removed")
+
+	_, err = replay.Status(false)
+	assert.Error(t, err)
+}
+
+func TestCompareStatus(t *testing.T) {
+	replayed := &Status{
+		NodeID:             1,
+		Epoch:              2,
+		EpochState:         "in_progress",
+		Leaders:            []uint64{2},
+		LastCommittedSeqNo: 58,
+		Clients:            []ClientStatus{{ID: 0, LowWatermark: 300, HighWatermark: 5300}},
+	}
+
+	reported := *replayed
+	assert.Empty(t, CompareStatus(replayed, &reported))
+
+	reported.LastCommittedSeqNo = 60
+	reported.Leaders = []uint64{0, 1, 2, 3}
+	assert.Equal(t, []string{
+		"leaders: replayed [2], reported [0 1 2 3]",
+		"last_committed_seq_no: replayed 58, reported 60",
+	}, CompareStatus(replayed, &reported))
+}
@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
+)
+
+// resumeGate withholds ticks from a node recovering from its WAL until the
+// epoch it recovered in has resumed.  Until then, the state machine panics
+// on ticks, as it has no epoch change of its own to rebroadcast.  A node only
+// sends preprepares, prepares, and commits within an active epoch, so the
+// first of these it sends marks the epoch as resumed.
+type resumeGate struct {
+	link     processor.Link
+	once     sync.Once
+	resumedC chan struct{}
+}
+
+func newResumeGate(link processor.Link) *resumeGate {
+	return &resumeGate{
+		link:     link,
+		resumedC: make(chan struct{}),
+	}
+}
+
+func (rg *resumeGate) Send(dest uint64, msg *pb.Msg) {
+	switch msg.Type.(type) {
+	case *pb.Msg_Preprepare, *pb.Msg_Prepare, *pb.Msg_Commit:
+		rg.once.Do(func() { close(rg.resumedC) })
+	}
+	rg.link.Send(dest, msg)
+}
+
+// ticks forwards the ticks from tickC, discarding them until the epoch has
+// resumed, except for the first.  The node does not begin processing the
+// events recovered from the WAL until it receives some input, and a single
+// tick is harmless.
+func (rg *resumeGate) ticks(tickC <-chan time.Time, doneC <-chan struct{}) <-chan time.Time {
+	gatedC := make(chan time.Time)
+	go func() {
+		first := true
+		for {
+			var tick time.Time
+			select {
+			case tick = <-tickC:
+			case <-doneC:
+				return
+			}
+
+			select {
+			case <-rg.resumedC:
+			default:
+				if !first {
+					continue
+				}
+				first = false
+			}
+
+			select {
+			case gatedC <- tick:
+			case <-doneC:
+				return
+			}
+		}
+	}()
+	return gatedC
+}
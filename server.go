@@ -7,36 +7,65 @@ SPDX-License-Identifier: Apache-2.0
 package sample
 
 import (
-	"compress/gzip"
 	"context"
 	"crypto"
 	"encoding/binary"
-	"fmt"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hyperledger-labs/mirbft"
-	"github.com/hyperledger-labs/mirbft/pkg/eventlog"
 	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
-	"github.com/hyperledger-labs/mirbft/pkg/reqstore"
-	"github.com/hyperledger-labs/mirbft/pkg/simplewal"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
 	"github.com/jyellick/mirbft-sample/config"
+	"github.com/jyellick/mirbft-sample/logging"
 	"github.com/jyellick/mirbft-sample/network"
+	"github.com/jyellick/mirbft-sample/tracing"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
 type Server struct {
-	Logger           *zap.SugaredLogger
-	NodeConfig       *config.NodeConfig
-	WALPath          string
-	RequestStorePath string
-	EventLogPath     string
-	Serial           bool
-
-	doneC chan struct{}
-	exitC chan struct{}
+	Loggers      *logging.Loggers
+	NodeConfig   *config.NodeConfig
+	EventLogPath string
+	Serial       bool
+
+	// WAL and RequestStore persist the state of the node, see OpenStorage.
+	// The server does not close them.
+	WAL          WAL
+	RequestStore RequestStore
+
+	// SnapshotDir is where the snapshot of each stable checkpoint is
+	// persisted, if not empty.
+	SnapshotDir string
+
+	// Registerer is where the node's metrics are registered, if nil they
+	// are collected but not exported.
+	Registerer prometheus.Registerer
+
+	// Tracer records the spans of each request as the node proposes,
+	// commits, and applies it, if nil none are recorded.
+	Tracer trace.Tracer
+
+	doneC    chan struct{}
+	exitC    chan struct{}
+	restartC chan struct{}
+	node     *nodeHolder
+
+	logger *zap.SugaredLogger
+
+	mutex     sync.Mutex
+	ticker    *time.Ticker
+	transport *network.ServerTransport
+	app       *application
+	status    *statusTracker
+	eventLog  *eventLog
+	fence     *fence
 }
 
 type MirLogAdapter zap.SugaredLogger
@@ -56,66 +85,131 @@ func (m *MirLogAdapter) Log(level mirbft.LogLevel, msg string, pairs ...interfac
 }
 
 func (s *Server) Run() error {
+	if s.WAL == nil || s.RequestStore == nil {
+		return errors.Errorf("a WAL and a request store are required, see OpenStorage")
+	}
+
 	s.doneC = make(chan struct{})
 	s.exitC = make(chan struct{})
+	s.restartC = make(chan struct{}, 1)
+	s.node = &nodeHolder{}
+	s.logger = s.Loggers.Named(logging.App)
 	defer close(s.exitC)
 
-	mirConfig := mirConfig(s.NodeConfig)
-	mirConfig.Logger = (*MirLogAdapter)(s.Logger)
-
-	var recorder *eventlog.Recorder
+	var eventLog *eventLog
 	if s.EventLogPath != "" {
-		file, err := os.Create(s.EventLogPath)
+		var err error
+		eventLog, err = openEventLog(s.logger, s.EventLogPath, s.NodeConfig.ID, s.NodeConfig.EventLog)
 		if err != nil {
-			return errors.WithMessage(err, "could not create event log file")
+			return err
 		}
-		recorder = eventlog.NewRecorder(
-			s.NodeConfig.ID,
-			file,
-			eventlog.CompressionLevelOpt(gzip.NoCompression),
-		)
-		defer recorder.Stop()
+		defer func() {
+			if err := eventLog.Close(); err != nil {
+				s.logger.Errorw("Could not close event log", "error", err)
+			}
+		}()
 	}
 
-	wal, err := simplewal.Open(s.WALPath)
-	if err != nil {
-		return errors.WithMessage(err, "could not open WAL")
-	}
-	defer wal.Close()
+	wal, reqStore := s.WAL, s.RequestStore
 
 	firstStart, err := wal.IsEmpty()
 	if err != nil {
 		return errors.WithMessage(err, "could not query WAL")
 	}
 
-	reqStore, err := reqstore.Open(s.RequestStorePath)
-	if err != nil {
-		return errors.WithMessage(err, "could not open request store")
+	networkState := initialNetworkState(s.NodeConfig.Genesis())
+	var checkpoint *pb.CEntry
+	if !firstStart {
+		if err := s.verifyStorage(); err != nil {
+			return err
+		}
+
+		checkpoint, err = lastCheckpoint(wal)
+		if err != nil {
+			return errors.WithMessage(err, "could not recover network state from WAL")
+		}
+		networkState = checkpoint.NetworkState
+	}
+
+	registerer := s.Registerer
+	if registerer == nil {
+		registerer = prometheus.NewRegistry()
 	}
-	defer reqStore.Close()
+	metrics := newMetrics(registerer, wal, reqStore)
 
 	// Create transport
-	t, err := network.NewServerTransport(s.Logger, s.NodeConfig)
+	t, err := network.NewServerTransport(s.Loggers, s.NodeConfig, network.NewMetrics(registerer))
 	if err != nil {
 		return errors.WithMessage(err, "could not create networking")
 	}
 
-	node, err := mirbft.NewNode(
-		s.NodeConfig.ID,
-		mirConfig,
-		&mirbft.ProcessorConfig{
-			Link:   t,
-			Hasher: crypto.SHA256,
-			App: &application{
-				reqStore: reqStore,
-			}, // TODO, make more useful fixme
-			RequestStore: reqStore,
-			WAL:          wal,
-			Interceptor:  recorder,
-		},
-	)
+	commitLog := newCommitLog()
+
+	tracer := s.Tracer
+	if tracer == nil {
+		tracer = nopTracer
+	}
+	traces := newRequestTraces(tracer)
+
+	app := &application{
+		logger:    s.logger,
+		reqStore:  reqStore,
+		commitLog: commitLog,
+		metrics:   metrics,
+		traces:    traces,
+	}
+	app.setClientStates(networkState.Clients)
+	if checkpoint != nil {
+		app.restore(checkpoint)
+	}
+
+	processorConfig := &mirbft.ProcessorConfig{
+		Link:         t,
+		Hasher:       crypto.SHA256,
+		App:          app, // TODO, make more useful fixme
+		RequestStore: reqStore,
+		WAL:          &meteredWAL{metrics: metrics, wal: wal},
+		Interceptor:  interceptor(eventLog),
+	}
+
+	if s.SnapshotDir != "" {
+		processorConfig.Interceptor, err = newSnapshotter(processorConfig.Interceptor, s.logger, metrics, s.NodeConfig.ID, s.SnapshotDir, int(s.NodeConfig.Snapshots.Retain))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Tracking the status costs a second application of every event, so
+	// is only done if the status may be queried.
+	var tracker *statusTracker
+	if s.NodeConfig.AdminAddress != "" {
+		tracker = newStatusTracker(processorConfig.Interceptor)
+		processorConfig.Interceptor = tracker
+	}
+
+	s.mutex.Lock()
+	s.transport = t
+	s.app = app
+	s.status = tracker
+	s.eventLog = eventLog
+	s.mutex.Unlock()
+
+	f, err := s.newNode(processorConfig)
 	if err != nil {
-		return errors.WithMessage(err, "could not create mirbft node")
+		return err
+	}
+
+	clientHandler := &clientHandler{
+		logger:        s.logger,
+		node:          s.node,
+		app:           app,
+		reqStore:      reqStore,
+		commitLog:     commitLog,
+		metrics:       metrics,
+		tracer:        tracer,
+		traces:        traces,
+		doneC:         s.doneC,
+		subscriptions: map[uint64]*subscription{},
 	}
 
 	t.Handle(
@@ -126,49 +220,14 @@ func (s *Server) Run() error {
 				return nil, errors.WithMessage(err, "unexpected unmarshaling error")
 			}
 
-			err = node.Step(context.Background(), nodeID, msg)
+			err = s.node.get().Step(context.Background(), nodeID, msg)
 			if err != nil {
 				return nil, errors.WithMessage(err, "failed to step message to mir node")
 			}
 
 			return nil, nil
 		},
-		func(clientID uint64, data []byte) ([]byte, error) {
-			// This simple protocol replies with the next reqno if no request
-			// data is supplied.
-			if len(data) == 0 {
-				proposer := node.Client(clientID)
-				nextReqNo, err := proposer.NextReqNo()
-				if err != nil {
-					return nil, errors.WithMessage(err, "could not get next request number")
-				}
-				encodedNextReqNo := make([]byte, 8)
-				binary.BigEndian.PutUint64(encodedNextReqNo, nextReqNo)
-				return encodedNextReqNo, nil
-			}
-
-			msg := &pb.Request{}
-			err := proto.Unmarshal(data, msg)
-			if err != nil {
-				return nil, errors.WithMessage(err, "unexpected unmarshaling error")
-			}
-
-			if msg.ClientId != clientID {
-				return nil, errors.Errorf("client ID mismatch, claims to be %d but is %d\n", msg.ClientId, clientID)
-			}
-
-			proposer := node.Client(clientID)
-			if err != nil {
-				return nil, errors.Errorf("unknown client id\n", clientID)
-			}
-
-			err = proposer.Propose(context.Background(), msg.ReqNo, msg.Data)
-			if err != nil {
-				return nil, errors.WithMessagef(err, "failed to propose message to client %d", clientID)
-			}
-
-			return nil, nil
-		},
+		clientHandler.handle,
 	)
 
 	err = t.Start()
@@ -183,15 +242,257 @@ func (s *Server) Run() error {
 	// let the links establish first to reduce logspam...
 	time.Sleep(2 * time.Second)
 
-	ticker := time.NewTicker(s.NodeConfig.MirRuntime.TickInterval)
-	defer ticker.Stop()
+	s.mutex.Lock()
+	s.ticker = time.NewTicker(s.NodeConfig.MirRuntime.TickInterval)
+	s.mutex.Unlock()
+	defer s.ticker.Stop()
+
+	// Main control loop, a new Mir node replaces the last each time
+	// processing is restarted to apply new runtime parameters.
+	for {
+		err = s.process(firstStart, networkState)
+		f.close()
+		if err != errRestart {
+			return err
+		}
+		firstStart = false
+
+		f, err = s.newNode(processorConfig)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// verifyStorage refuses to start the node from an inconsistent WAL and
+// request store, rather than leave Mir to fail on them later.
+func (s *Server) verifyStorage() error {
+	start := time.Now()
+	report, err := VerifyStorage(s.WAL, s.RequestStore)
+	if err != nil {
+		return errors.WithMessage(err, "could not verify storage, see nodedata fsck")
+	}
+	if err := report.Err(); err != nil {
+		return errors.WithMessage(err, "could not verify storage, see nodedata fsck")
+	}
+
+	s.logger.Infow("Verified storage", "entries", report.Entries(), "checkpoints", report.Checkpoints, "requests", report.Requests, "duration", time.Since(start))
+	return nil
+}
+
+var errRestart = errors.Errorf("restarting to apply new runtime parameters")
+
+// newNode creates a Mir node from the current config, with its components
+// guarded by the returned fence, and makes it the current node.
+func (s *Server) newNode(processorConfig *mirbft.ProcessorConfig) (*fence, error) {
+	s.mutex.Lock()
+	mirConfig := mirConfig(s.NodeConfig)
+	nodeID := s.NodeConfig.ID
+	s.mutex.Unlock()
+	mirConfig.Logger = (*MirLogAdapter)(s.Loggers.Named(logging.Mir))
 
-	// Main control loop
+	gate := newResumeGate(processorConfig.Link)
+	f := &fence{}
+	fenced := f.processorConfig(processorConfig)
+	fenced.Link = gate
+
+	node, err := mirbft.NewNode(nodeID, mirConfig, fenced)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not create mirbft node")
+	}
+	s.node.set(node, gate)
+
+	s.mutex.Lock()
+	s.fence = f
+	s.mutex.Unlock()
+
+	return f, nil
+}
+
+// process runs the current Mir node until the server is stopped, in which
+// case nil is returned, or until a reload requests a restart, in which case
+// errRestart is returned.
+func (s *Server) process(firstStart bool, networkState *pb.NetworkState) error {
+	node, gate := s.node.getWithGate()
+
+	exitC := make(chan struct{})
+	stopC := make(chan struct{})
+	restarting := false
+	go func() {
+		select {
+		case <-s.doneC:
+		case <-s.restartC:
+			restarting = true
+		case <-stopC:
+		}
+		close(exitC)
+	}()
+
+	var err error
 	if firstStart {
-		return node.ProcessAsNewNode(s.doneC, ticker.C, initialNetworkState(s.NodeConfig), []byte("initial-checkpoint-value"))
+		// The genesis hash serves as the initial checkpoint value, so that
+		// nodes which disagree on the genesis cannot agree on a checkpoint.
+		err = node.ProcessAsNewNode(exitC, s.ticker.C, networkState, s.Config().Genesis().Hash())
+	} else {
+		err = node.RestartProcessing(exitC, gate.ticks(s.ticker.C, exitC))
+	}
+
+	close(stopC)
+	<-exitC
+
+	if err == mirbft.ErrStopped {
+		if restarting {
+			return errRestart
+		}
+		// The server was stopped.
+		return nil
+	}
+
+	return err
+}
+
+// Reload applies the runtime parameters, health settings, and log levels from
+// an updated node config, logging each change, and refuses the reload in its
+// entirety if any other field has changed.  New log levels, health settings,
+// and a new tick interval are applied immediately, while other runtime parameters, which Mir reads only when
+// processing starts, cause processing to restart with a new Mir node
+// recovered from the WAL.
+func (s *Server) Reload(nodeConfig *config.NodeConfig) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ticker == nil {
+		return errors.Errorf("server is not running")
+	}
+
+	changes := s.NodeConfig.Diff(nodeConfig)
+	if len(changes) == 0 {
+		s.logger.Infof("Reloaded config, nothing changed")
+		return nil
+	}
+
+	var immutable []string
+	restart := false
+	for _, change := range changes {
+		s.logger.Infof("Reloading config, %s", change)
+		switch {
+		case change.Key == "mir_runtime.tick_interval":
+		case strings.HasPrefix(change.Key, "mir_runtime."):
+			restart = true
+		case change.Key == "logging.level", strings.HasPrefix(change.Key, "logging.levels."):
+		case strings.HasPrefix(change.Key, "health."):
+		default:
+			immutable = append(immutable, change.Key)
+		}
+	}
+
+	if len(immutable) > 0 {
+		return errors.Errorf("refusing to reload, only mir_runtime and health fields, and logging levels, may change, but %s changed", strings.Join(immutable, ", "))
+	}
+
+	if err := s.Loggers.SetLevels(&nodeConfig.Logging); err != nil {
+		return err
+	}
+	s.ticker.Reset(nodeConfig.MirRuntime.TickInterval)
+	s.NodeConfig = nodeConfig
+
+	if restart {
+		s.logger.Infof("Restarting Mir processing to apply new runtime parameters")
+		select {
+		case s.restartC <- struct{}{}:
+		default:
+			// A restart is already pending, and will use the new config.
+		}
+	}
+
+	return nil
+}
+
+// Config returns the node config in effect, which Reload may replace.
+func (s *Server) Config() *config.NodeConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.NodeConfig
+}
+
+// Status reports the consensus state of the node, including the complete
+// status of the state machine if full is set.  The status is only available
+// if the node config sets an admin_address.
+func (s *Server) Status(full bool) (*Status, error) {
+	s.mutex.Lock()
+	t, app, tracker := s.transport, s.app, s.status
+	nodeID := s.NodeConfig.ID
+	s.mutex.Unlock()
+
+	if tracker == nil {
+		return nil, errors.Errorf("status is not tracked, the node is not running or has no admin_address")
+	}
+
+	sm, err := tracker.status()
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not get state machine status")
 	}
 
-	return node.RestartProcessing(s.doneC, ticker.C)
+	status := newStatus(sm)
+	status.NodeID = nodeID
+	status.LastCommittedSeqNo = app.lastApplied()
+	status.Peers = t.Peers()
+	if full {
+		status.StateMachine = sm
+	}
+
+	return status, nil
+}
+
+// Quiesce pauses the node's processing, syncs the WAL and request store to
+// disk, closes a request store whose files change in the background, and
+// completes the event log, then invokes fn, during which the node's durable
+// state neither changes nor is left part way through an operation, as when
+// backing up the run directory.  Processing resumes once fn returns.
+func (s *Server) Quiesce(fn func() error) error {
+	var f *fence
+	for {
+		s.mutex.Lock()
+		f = s.fence
+		s.mutex.Unlock()
+
+		if f == nil {
+			return errors.Errorf("server is not running")
+		}
+		if f.pause() {
+			break
+		}
+
+		// The node is being replaced, or the server is stopping.
+		select {
+		case <-s.exitC:
+			return errors.Errorf("server is not running")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	defer f.resume()
+
+	for name, store := range map[string]interface{}{"WAL": s.WAL, "request store": s.RequestStore} {
+		if flusher, ok := store.(flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				return errors.WithMessagef(err, "could not sync %s", name)
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	eventLog := s.eventLog
+	s.mutex.Unlock()
+	if eventLog != nil {
+		if err := eventLog.flush(); err != nil {
+			return errors.WithMessage(err, "could not complete event log")
+		}
+	}
+
+	if suspender, ok := s.RequestStore.(suspender); ok {
+		return suspender.Suspend(fn)
+	}
+	return fn()
 }
 
 func (s *Server) Stop() {
@@ -199,21 +500,164 @@ func (s *Server) Stop() {
 	<-s.exitC
 }
 
+// interceptor returns the event log, or if there is none, an interceptor
+// which discards events, as the node requires one.
+func interceptor(eventLog *eventLog) processor.EventInterceptor {
+	if eventLog == nil {
+		return nopInterceptor{}
+	}
+	return eventLog
+}
+
+type nopInterceptor struct{}
+
+func (nopInterceptor) Intercept(*state.Event) error {
+	return nil
+}
+
+// nodeHolder holds the Mir node currently processing, which is replaced
+// each time processing restarts.
+type nodeHolder struct {
+	mutex sync.Mutex
+	node  *mirbft.Node
+	gate  *resumeGate
+}
+
+func (nh *nodeHolder) get() *mirbft.Node {
+	node, _ := nh.getWithGate()
+	return node
+}
+
+func (nh *nodeHolder) getWithGate() (*mirbft.Node, *resumeGate) {
+	nh.mutex.Lock()
+	defer nh.mutex.Unlock()
+	return nh.node, nh.gate
+}
+
+func (nh *nodeHolder) set(node *mirbft.Node, gate *resumeGate) {
+	nh.mutex.Lock()
+	defer nh.mutex.Unlock()
+	nh.node = node
+	nh.gate = gate
+}
+
 type application struct {
-	count    uint64
-	reqStore *reqstore.Store
+	logger    *zap.SugaredLogger
+	count     uint64
+	reqStore  RequestStore
+	commitLog *commitLog
+	metrics   *metrics
+	traces    *requestTraces
+
+	mutex sync.Mutex
+
+	// lastSeqNo is the last sequence number applied, at lastAppliedAt.  When
+	// Mir processing is restarted within the process, the entries since the
+	// last checkpoint are applied once more, and must be ignored.
+	lastSeqNo     uint64
+	lastAppliedAt time.Time
+	clientStates  map[uint64]*pb.NetworkState_Client
+}
+
+// setClientStates records the client windows as of the latest checkpoint.
+func (app *application) setClientStates(clients []*pb.NetworkState_Client) {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+
+	app.clientStates = map[uint64]*pb.NetworkState_Client{}
+	for _, client := range clients {
+		app.clientStates[client.Id] = client
+	}
+}
+
+// clientWindow returns the range of request numbers the given client may
+// currently propose, or nil if the client is not known.
+func (app *application) clientWindow(clientID uint64) *network.Window {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+
+	client, ok := app.clientStates[clientID]
+	if !ok || client.Width == 0 {
+		return nil
+	}
+
+	// Note, the state machine itself will allocate up to and including
+	// LowWatermark+Width, but only tracks commits for Width requests, so
+	// proposing the last of these crashes the node.
+	return &network.Window{
+		LowWatermark:  client.LowWatermark,
+		HighWatermark: client.LowWatermark + uint64(client.Width) - 1,
+	}
+}
+
+// lastApplied returns the sequence number of the last entry applied.
+func (app *application) lastApplied() uint64 {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	return app.lastSeqNo
+}
+
+// sinceLastApplied returns how long it has been since an entry was applied,
+// or false if none has been since the node started.
+func (app *application) sinceLastApplied() (time.Duration, bool) {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	if app.lastAppliedAt.IsZero() {
+		return 0, false
+	}
+	return time.Since(app.lastAppliedAt), true
+}
+
+// restore resumes the application from the checkpoint a restarted node
+// recovers from its WAL, unless it has applied the checkpoint already, as when
+// processing restarts within the process.  The initial checkpoint holds the
+// genesis hash rather than a snapshot, and leaves the application as it began.
+func (app *application) restore(checkpoint *pb.CEntry) {
+	if checkpoint.SeqNo == 0 || checkpoint.SeqNo <= app.lastApplied() {
+		return
+	}
+
+	count := binary.BigEndian.Uint64(checkpoint.CheckpointValue[:8])
+	app.mutex.Lock()
+	app.count = count
+	app.lastSeqNo = checkpoint.SeqNo
+	app.mutex.Unlock()
+	app.setClientStates(checkpoint.NetworkState.Clients)
+	app.logger.Infow("Restored from checkpoint", "seq_no", checkpoint.SeqNo, "total_requests", count)
 }
 
 func (app *application) Apply(entry *pb.QEntry) error {
-	fmt.Printf("Committing an entry for seq_no=%d (current count=%d)\n", entry.SeqNo, app.count)
+	if entry.SeqNo <= app.lastApplied() {
+		return nil
+	}
+
 	for _, request := range entry.Requests {
-		reqData, err := app.reqStore.GetRequest(request)
-		if err != nil {
-			return errors.WithMessage(err, "could get entry from request store")
+		span := app.traces.apply(entry.SeqNo, request)
+
+		// A replayed application may have no request store, and applies
+		// requests without their data.
+		var reqData []byte
+		if app.reqStore != nil {
+			var err error
+			reqData, err = app.reqStore.GetRequest(request)
+			if err != nil {
+				tracing.End(span, err)
+				return errors.WithMessage(err, "could get entry from request store")
+			}
 		}
-		fmt.Printf("  Applying clientID=%d reqNo=%d with data of length %d start %q to log\n", request.ClientId, request.ReqNo, len(reqData), string(reqData[:26]))
+		app.logger.Debugw("Applying request", "seq_no", entry.SeqNo, "client_id", request.ClientId, "req_no", request.ReqNo, "length", len(reqData))
 		app.count++
+		span.End()
 	}
+	app.logger.Infow("Committed entry", "seq_no", entry.SeqNo, "requests", len(entry.Requests), "total_requests", app.count)
+
+	app.mutex.Lock()
+	app.lastSeqNo = entry.SeqNo
+	app.lastAppliedAt = time.Now()
+	app.mutex.Unlock()
+
+	app.commitLog.append(entry)
+	app.metrics.applied(entry)
 
 	return nil
 }
@@ -230,6 +674,9 @@ func (app *application) Snap(networkConfig *pb.NetworkState_Config, clients []*p
 		return nil, nil, errors.WithMessage(err, "could not marsshal network state")
 	}
 
+	app.setClientStates(clients)
+	app.traces.prune(clients)
+
 	countValue := make([]byte, 8)
 	binary.BigEndian.PutUint64(countValue, uint64(app.count))
 
@@ -239,6 +686,9 @@ func (app *application) Snap(networkConfig *pb.NetworkState_Config, clients []*p
 func (app *application) TransferTo(seq uint64, value []byte) (*pb.NetworkState, error) {
 	countValue := value[:8]
 	app.count = binary.BigEndian.Uint64(countValue)
+	app.mutex.Lock()
+	app.lastSeqNo = seq
+	app.mutex.Unlock()
 
 	stateValue := value[8:]
 	ns := &pb.NetworkState{}
@@ -246,7 +696,9 @@ func (app *application) TransferTo(seq uint64, value []byte) (*pb.NetworkState,
 	if err != nil {
 		return nil, errors.WithMessage(err, "could not unmarshal checkpoint value to network state")
 	}
-	fmt.Printf("Completed state transfer to sequence %d with a total count of %d requests applied\n", seq, app.count)
+	app.setClientStates(ns.Clients)
+	app.traces.prune(ns.Clients)
+	app.logger.Infow("Completed state transfer", "seq_no", seq, "total_requests", app.count)
 
 	return ns, nil
 }
@@ -262,15 +714,34 @@ func mirConfig(nodeConfig *config.NodeConfig) *mirbft.Config {
 
 }
 
-func initialNetworkState(nodeConfig *config.NodeConfig) *pb.NetworkState {
+func initialNetworkState(genesis *config.Genesis) *pb.NetworkState {
 
 	// The sample application relies on the configuration assigning client IDs contiguously starting from 0.
-	networkState := mirbft.StandardInitialNetworkState(len(nodeConfig.Nodes), len(nodeConfig.Clients))
-	networkState.Config.NumberOfBuckets = int32(nodeConfig.MirBootstrap.NumberOfBuckets)
-	networkState.Config.CheckpointInterval = int32(nodeConfig.MirBootstrap.CheckpointInterval)
+	networkState := mirbft.StandardInitialNetworkState(len(genesis.Nodes), len(genesis.Clients))
+	networkState.Config.NumberOfBuckets = int32(genesis.MirBootstrap.NumberOfBuckets)
+	networkState.Config.CheckpointInterval = int32(genesis.MirBootstrap.CheckpointInterval)
 	for _, client := range networkState.Clients {
-		client.Width = nodeConfig.MirBootstrap.ClientWindowSize
+		client.Width = genesis.MirBootstrap.ClientWindowSize
 	}
 
 	return networkState
 }
+
+// lastCheckpoint returns the most recent checkpoint entry in the WAL.
+func lastCheckpoint(wal processor.WAL) (*pb.CEntry, error) {
+	var checkpoint *pb.CEntry
+	err := wal.LoadAll(func(index uint64, p *pb.Persistent) {
+		if cEntry := p.GetCEntry(); cEntry != nil {
+			checkpoint = cEntry
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if checkpoint == nil {
+		return nil, errors.Errorf("WAL contains no checkpoint entry")
+	}
+
+	return checkpoint, nil
+}
@@ -0,0 +1,337 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// SnapshotDir is the directory within the run directory in which the
+	// node persists the snapshot of each stable checkpoint.
+	SnapshotDir = "snapshots"
+
+	// defaultSnapshotRetain is the number of snapshots kept if the config
+	// sets none.
+	defaultSnapshotRetain = 5
+)
+
+// Snapshot is the state of the network as of a stable checkpoint.  The
+// certificate lists the nodes whose checkpoint messages this node saw agree on
+// the value, at least an intersection quorum of the network.  As Mir's
+// checkpoint messages are not signed, it is this node's record of the
+// agreement, rather than evidence another node could check.
+type Snapshot struct {
+	SeqNo        uint64
+	Value        []byte
+	NetworkState *pb.NetworkState
+	Certificate  []uint64
+}
+
+// snapshotFile is the JSON encoding of a snapshot.
+type snapshotFile struct {
+	SeqNo        uint64          `json:"seq_no"`
+	Value        []byte          `json:"value"`
+	NetworkState json.RawMessage `json:"network_state"`
+	Certificate  []uint64        `json:"certificate"`
+}
+
+// intersectionQuorum is the number of nodes which must agree on a checkpoint
+// for it to be stable, as Mir computes it.
+func intersectionQuorum(networkConfig *pb.NetworkState_Config) int {
+	return (len(networkConfig.Nodes) + int(networkConfig.F) + 2) / 2
+}
+
+func snapshotPath(dir string, seqNo uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("snapshot-%020d.json", seqNo))
+}
+
+// writeSnapshot writes the snapshot to dir, replacing any with the same
+// sequence number only once it has been written in its entirety.
+func writeSnapshot(dir string, s *Snapshot) error {
+	networkState, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(s.NetworkState)
+	if err != nil {
+		return errors.WithMessage(err, "could not marshal network state")
+	}
+
+	data, err := json.MarshalIndent(&snapshotFile{
+		SeqNo:        s.SeqNo,
+		Value:        s.Value,
+		NetworkState: networkState,
+		Certificate:  s.Certificate,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.WithMessage(err, "could not create snapshot dir")
+	}
+
+	f, err := ioutil.TempFile(dir, ".snapshot-*")
+	if err != nil {
+		return errors.WithMessage(err, "could not create snapshot")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return errors.WithMessage(err, "could not write snapshot")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.WithMessage(err, "could not sync snapshot")
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithMessage(err, "could not write snapshot")
+	}
+
+	return os.Rename(f.Name(), snapshotPath(dir, s.SeqNo))
+}
+
+// readSnapshot reads the snapshot at path.
+func readSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sf := &snapshotFile{}
+	if err := json.Unmarshal(data, sf); err != nil {
+		return nil, errors.WithMessagef(err, "could not parse snapshot '%s'", path)
+	}
+
+	networkState := &pb.NetworkState{}
+	if err := protojson.Unmarshal(sf.NetworkState, networkState); err != nil {
+		return nil, errors.WithMessagef(err, "could not parse network state of snapshot '%s'", path)
+	}
+
+	return &Snapshot{
+		SeqNo:        sf.SeqNo,
+		Value:        sf.Value,
+		NetworkState: networkState,
+		Certificate:  sf.Certificate,
+	}, nil
+}
+
+// listSnapshots returns the paths of the snapshots within dir, oldest first.
+func listSnapshots(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "snapshot-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The zero padded sequence numbers sort in order.
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// latestSnapshot reads the snapshot of the latest stable checkpoint within
+// dir, or returns nil if there is none.
+func latestSnapshot(dir string) (*Snapshot, error) {
+	paths, err := listSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	return readSnapshot(paths[len(paths)-1])
+}
+
+// snapshotter persists a snapshot of each checkpoint once it becomes stable,
+// that is once the checkpoint messages of an intersection quorum of the
+// network, including that of this node, agree with the value this node's
+// application took.  It observes the events applied to the state machine,
+// passing each on to the next interceptor.
+type snapshotter struct {
+	interceptor processor.EventInterceptor
+	logger      *zap.SugaredLogger
+	metrics     *metrics
+	nodeID      uint64
+	dir         string
+	retain      int
+
+	// results are the checkpoints taken by this node yet to become stable.
+	results map[uint64]*state.EventCheckpointResult
+
+	// votes are the value each node's checkpoint message reported, by
+	// sequence number.
+	votes map[uint64]map[uint64]string
+
+	// own is the latest checkpoint this node has taken or loaded, and
+	// interval the network's checkpoint interval as of it, which together
+	// bound the checkpoints votes are kept for, see inWindow.
+	own      uint64
+	interval uint64
+
+	// lastSeqNo is that of the latest snapshot persisted.
+	lastSeqNo uint64
+}
+
+func newSnapshotter(interceptor processor.EventInterceptor, logger *zap.SugaredLogger, metrics *metrics, nodeID uint64, dir string, retain int) (*snapshotter, error) {
+	if retain <= 0 {
+		retain = defaultSnapshotRetain
+	}
+
+	s := &snapshotter{
+		interceptor: interceptor,
+		logger:      logger,
+		metrics:     metrics,
+		nodeID:      nodeID,
+		dir:         dir,
+		retain:      retain,
+		results:     map[uint64]*state.EventCheckpointResult{},
+		votes:       map[uint64]map[uint64]string{},
+	}
+
+	latest, err := latestSnapshot(dir)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not read latest snapshot")
+	}
+	if latest != nil {
+		s.lastSeqNo = latest.SeqNo
+	}
+
+	return s, nil
+}
+
+func (s *snapshotter) Intercept(event *state.Event) error {
+	switch t := event.Type.(type) {
+	case *state.Event_LoadPersistedEntry:
+		if cEntry := t.LoadPersistedEntry.GetEntry().GetCEntry(); cEntry != nil {
+			s.advance(cEntry.SeqNo, cEntry.NetworkState)
+		}
+	case *state.Event_CheckpointResult:
+		result := t.CheckpointResult
+		s.advance(result.SeqNo, result.NetworkState)
+		if result.SeqNo > s.lastSeqNo {
+			s.results[result.SeqNo] = result
+			s.vote(s.nodeID, result.SeqNo, result.Value)
+		}
+	case *state.Event_Step:
+		if cp := t.Step.GetMsg().GetCheckpoint(); cp != nil && cp.SeqNo > s.lastSeqNo {
+			s.vote(t.Step.Source, cp.SeqNo, cp.Value)
+		}
+	}
+
+	return s.interceptor.Intercept(event)
+}
+
+// advance records a checkpoint this node has taken or loaded, discarding the
+// votes and results of those which have fallen out of Mir's window.
+func (s *snapshotter) advance(seqNo uint64, networkState *pb.NetworkState) {
+	if seqNo < s.own {
+		return
+	}
+	s.own = seqNo
+	s.interval = uint64(networkState.GetConfig().GetCheckpointInterval())
+
+	for cpSeqNo := range s.votes {
+		if cpSeqNo+2*s.interval < s.own {
+			delete(s.votes, cpSeqNo)
+			delete(s.results, cpSeqNo)
+		}
+	}
+}
+
+// inWindow reports whether the checkpoint at seqNo is one which Mir tracks,
+// at most two intervals beyond the latest this node has taken, so that a
+// faulty peer cannot grow the votes without bound.
+func (s *snapshotter) inWindow(seqNo uint64) bool {
+	return s.interval > 0 && seqNo%s.interval == 0 && seqNo <= s.own+2*s.interval
+}
+
+// vote records the value the node reported for the checkpoint, replacing any
+// it reported before, and persists the snapshot if this makes the checkpoint
+// stable.
+func (s *snapshotter) vote(nodeID, seqNo uint64, value []byte) {
+	if !s.inWindow(seqNo) {
+		return
+	}
+
+	nodes, ok := s.votes[seqNo]
+	if !ok {
+		nodes = map[uint64]string{}
+		s.votes[seqNo] = nodes
+	}
+	nodes[nodeID] = string(value)
+
+	result, ok := s.results[seqNo]
+	if !ok || string(result.Value) != string(value) {
+		return
+	}
+
+	var certificate []uint64
+	for id, v := range nodes {
+		if v == string(value) {
+			certificate = append(certificate, id)
+		}
+	}
+
+	networkConfig := result.NetworkState.GetConfig()
+	if len(certificate) < intersectionQuorum(networkConfig) {
+		return
+	}
+	sort.Slice(certificate, func(i, j int) bool { return certificate[i] < certificate[j] })
+
+	s.persist(&Snapshot{
+		SeqNo:        seqNo,
+		Value:        result.Value,
+		NetworkState: result.NetworkState,
+		Certificate:  certificate,
+	})
+}
+
+// persist writes the snapshot and removes those beyond the retention count.
+// A snapshot which cannot be written is logged rather than halting the node,
+// as the checkpoint remains in the WAL.
+func (s *snapshotter) persist(snapshot *Snapshot) {
+	s.lastSeqNo = snapshot.SeqNo
+	for seqNo := range s.results {
+		if seqNo <= snapshot.SeqNo {
+			delete(s.results, seqNo)
+		}
+	}
+	for seqNo := range s.votes {
+		if seqNo <= snapshot.SeqNo {
+			delete(s.votes, seqNo)
+		}
+	}
+
+	if err := writeSnapshot(s.dir, snapshot); err != nil {
+		s.logger.Errorw("Could not persist snapshot", "seq_no", snapshot.SeqNo, "error", err)
+		return
+	}
+	s.metrics.snapshotPersisted(snapshot.SeqNo)
+	s.logger.Infow("Persisted snapshot of stable checkpoint", "seq_no", snapshot.SeqNo, "certificate", snapshot.Certificate)
+
+	paths, err := listSnapshots(s.dir)
+	if err != nil {
+		s.logger.Warnw("Could not list snapshots to prune", "error", err)
+		return
+	}
+	for len(paths) > s.retain {
+		if err := os.Remove(paths[0]); err != nil {
+			s.logger.Warnw("Could not remove snapshot", "path", paths[0], "error", err)
+		}
+		paths = paths[1:]
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func checkpointResult(seqNo uint64) *state.Event {
+	return &state.Event{Type: &state.Event_CheckpointResult{CheckpointResult: &state.EventCheckpointResult{
+		SeqNo: seqNo,
+		Value: []byte(fmt.Sprintf("value-%d", seqNo)),
+		NetworkState: &pb.NetworkState{
+			Config: &pb.NetworkState_Config{Nodes: []uint64{0, 1, 2, 3}, F: 1, CheckpointInterval: 20},
+		},
+	}}}
+}
+
+// loadCheckpoint is the event of the node loading the checkpoint from its WAL
+// as it initializes.
+func loadCheckpoint(seqNo uint64) *state.Event {
+	result := checkpointResult(seqNo).GetCheckpointResult()
+	return &state.Event{Type: &state.Event_LoadPersistedEntry{LoadPersistedEntry: &state.EventLoadPersistedEntry{
+		Entry: &pb.Persistent{Type: &pb.Persistent_CEntry{CEntry: &pb.CEntry{
+			SeqNo:           seqNo,
+			CheckpointValue: result.Value,
+			NetworkState:    result.NetworkState,
+		}}},
+	}}}
+}
+
+func checkpointMsg(source, seqNo uint64, value string) *state.Event {
+	return &state.Event{Type: &state.Event_Step{Step: &state.EventStep{
+		Source: source,
+		Msg: &pb.Msg{Type: &pb.Msg_Checkpoint{Checkpoint: &pb.Checkpoint{
+			SeqNo: seqNo,
+			Value: []byte(value),
+		}}},
+	}}}
+}
+
+func TestSnapshotter(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    []*state.Event
+		snapshots []uint64
+		certified []uint64
+	}{
+		{
+			name: "stable",
+			events: []*state.Event{
+				checkpointMsg(1, 20, "value-20"),
+				checkpointResult(20),
+				checkpointMsg(2, 20, "value-20"),
+			},
+			snapshots: []uint64{20},
+			certified: []uint64{0, 1, 2},
+		},
+		{
+			name: "disagreement",
+			events: []*state.Event{
+				checkpointResult(20),
+				checkpointMsg(1, 20, "value-20"),
+				checkpointMsg(2, 20, "other"),
+				checkpointMsg(0, 20, "value-20"),
+			},
+		},
+		{
+			name: "not taken by this node",
+			events: []*state.Event{
+				checkpointMsg(1, 20, "value-20"),
+				checkpointMsg(2, 20, "value-20"),
+				checkpointMsg(3, 20, "value-20"),
+			},
+		},
+		{
+			name: "changed vote",
+			events: []*state.Event{
+				checkpointResult(20),
+				checkpointMsg(1, 20, "other"),
+				checkpointMsg(1, 20, "value-20"),
+				checkpointMsg(2, 20, "other"),
+				checkpointMsg(2, 20, "value-20"),
+			},
+			snapshots: []uint64{20},
+			certified: []uint64{0, 1, 2},
+		},
+		{
+			name: "beyond the window",
+			events: []*state.Event{
+				checkpointMsg(1, 60, "value-60"),
+				checkpointMsg(2, 60, "value-60"),
+				checkpointMsg(1, 30, "value-30"),
+				checkpointResult(20),
+				checkpointResult(40),
+				checkpointResult(60),
+				checkpointMsg(3, 60, "value-60"),
+			},
+		},
+		{
+			name: "retention",
+			events: []*state.Event{
+				checkpointResult(20),
+				checkpointMsg(1, 20, "value-20"),
+				checkpointMsg(2, 20, "value-20"),
+				checkpointResult(40),
+				checkpointMsg(1, 40, "value-40"),
+				checkpointMsg(3, 40, "value-40"),
+				checkpointResult(60),
+				checkpointMsg(2, 60, "value-60"),
+				checkpointMsg(3, 60, "value-60"),
+			},
+			snapshots: []uint64{40, 60},
+			certified: []uint64{0, 2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), SnapshotDir)
+			metrics := newMetrics(prometheus.NewRegistry(), nil, nil)
+			s, err := newSnapshotter(nopInterceptor{}, zap.NewNop().Sugar(), metrics, 0, dir, 2)
+			require.NoError(t, err)
+
+			require.NoError(t, s.Intercept(loadCheckpoint(0)))
+			for _, event := range tt.events {
+				require.NoError(t, s.Intercept(event))
+			}
+
+			paths, err := listSnapshots(dir)
+			require.NoError(t, err)
+			var seqNos []uint64
+			for _, path := range paths {
+				snapshot, err := readSnapshot(path)
+				require.NoError(t, err)
+				seqNos = append(seqNos, snapshot.SeqNo)
+			}
+			assert.Equal(t, tt.snapshots, seqNos)
+
+			latest, err := latestSnapshot(dir)
+			require.NoError(t, err)
+			if tt.certified == nil {
+				assert.Nil(t, latest)
+				return
+			}
+			assert.Equal(t, tt.certified, latest.Certificate)
+			assert.Equal(t, []byte(fmt.Sprintf("value-%d", latest.SeqNo)), latest.Value)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/mirbft/pkg/pb/state"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
+	"github.com/hyperledger-labs/mirbft/pkg/statemachine"
+	"github.com/hyperledger-labs/mirbft/pkg/status"
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/pkg/errors"
+)
+
+// Status summarizes the consensus state of a node.
+type Status struct {
+	NodeID uint64 `json:"node_id"`
+
+	// Epoch is the number of the epoch the node is in, or is changing to,
+	// and EpochState describes its progress, e.g. "in_progress" once the
+	// epoch is active.
+	Epoch      uint64   `json:"epoch"`
+	EpochState string   `json:"epoch_state"`
+	Leaders    []uint64 `json:"leaders"`
+
+	// LastCommittedSeqNo is the sequence number of the last entry applied.
+	LastCommittedSeqNo uint64 `json:"last_committed_seq_no"`
+
+	// StableCheckpointSeqNo is the sequence number of the latest checkpoint
+	// agreed by a quorum of nodes, including this one.
+	StableCheckpointSeqNo uint64 `json:"stable_checkpoint_seq_no"`
+
+	LowWatermark  uint64               `json:"low_watermark"`
+	HighWatermark uint64               `json:"high_watermark"`
+	Clients       []ClientStatus       `json:"clients"`
+	Peers         []network.PeerStatus `json:"peers"`
+
+	// StateMachine is the complete status reported by the state machine,
+	// included only when requested.
+	StateMachine *status.StateMachine `json:"state_machine,omitempty"`
+}
+
+// ClientStatus describes the request window of a client.
+type ClientStatus struct {
+	ID            uint64 `json:"id"`
+	LowWatermark  uint64 `json:"low_watermark"`
+	HighWatermark uint64 `json:"high_watermark"`
+}
+
+// epochStateNames names the states reported for an epoch.  The state machine
+// reports its internal states, which since resuming and ending were added no
+// longer line up with the status.EpochTargetState constants.
+var epochStateNames = []string{
+	"prepending",
+	"pending",
+	"verifying",
+	"fetching",
+	"echoing",
+	"readying",
+	"resuming",
+	"ready",
+	"in_progress",
+	"ending",
+	"done",
+}
+
+func epochStateName(s status.EpochTargetState) string {
+	if s >= 0 && int(s) < len(epochStateNames) {
+		return epochStateNames[s]
+	}
+	return fmt.Sprintf("unknown(%d)", s)
+}
+
+// newStatus summarizes the status reported by the state machine.
+func newStatus(sm *status.StateMachine) *Status {
+	s := &Status{
+		NodeID:        sm.NodeID,
+		LowWatermark:  sm.LowWatermark,
+		HighWatermark: sm.HighWatermark,
+	}
+
+	// The watermarks begin just after the last checkpoint garbage collected,
+	// unless a later checkpoint has since become stable.
+	if sm.LowWatermark > 0 {
+		s.StableCheckpointSeqNo = sm.LowWatermark - 1
+	}
+
+	if sm.EpochTracker != nil && sm.EpochTracker.ActiveEpoch != nil {
+		epoch := sm.EpochTracker.ActiveEpoch
+		s.Epoch = epoch.Number
+		s.EpochState = epochStateName(epoch.State)
+		s.Leaders = epoch.Leaders
+	}
+
+	for _, cp := range sm.Checkpoints {
+		if cp.NetQuorum && cp.LocalDecision && cp.SeqNo > s.StableCheckpointSeqNo {
+			s.StableCheckpointSeqNo = cp.SeqNo
+		}
+	}
+
+	for _, client := range sm.ClientWindows {
+		s.Clients = append(s.Clients, ClientStatus{
+			ID:            client.ClientID,
+			LowWatermark:  client.LowWatermark,
+			HighWatermark: client.HighWatermark,
+		})
+	}
+
+	return s
+}
+
+// statusTracker maintains a replica of the node's state machine from which to
+// report its status, as the pinned version of Mir never answers Node.Status.
+// It intercepts each event before the node's state machine applies it, and
+// applies it to the replica likewise, which, as the state machine is
+// deterministic, stays identical to the node's own.
+type statusTracker struct {
+	interceptor processor.EventInterceptor
+
+	mutex   sync.Mutex
+	machine *statemachine.StateMachine
+	err     error
+
+	// lastEventAt is when processing last handled an event.
+	lastEventAt time.Time
+}
+
+func newStatusTracker(interceptor processor.EventInterceptor) *statusTracker {
+	return &statusTracker{
+		interceptor: interceptor,
+		machine:     &statemachine.StateMachine{Logger: nopLogger{}},
+		lastEventAt: time.Now(),
+	}
+}
+
+func (st *statusTracker) Intercept(event *state.Event) error {
+	st.apply(event)
+	return st.interceptor.Intercept(event)
+}
+
+func (st *statusTracker) apply(event *state.Event) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.lastEventAt = time.Now()
+
+	if _, ok := event.Type.(*state.Event_Initialize); ok {
+		// Mir processing has restarted, and the new node's state machine
+		// begins afresh.
+		st.machine = &statemachine.StateMachine{Logger: nopLogger{}}
+		st.err = nil
+	}
+
+	if st.err != nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			st.err = errors.Errorf("state machine replica failed to apply event: %v", r)
+		}
+	}()
+
+	st.machine.ApplyEvent(event)
+}
+
+// status returns the status of the replica.
+func (st *statusTracker) status() (*status.StateMachine, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.err != nil {
+		return nil, st.err
+	}
+
+	return st.machine.Status()
+}
+
+// sinceLastEvent returns how long it has been since processing last handled
+// an event.
+func (st *statusTracker) sinceLastEvent() time.Duration {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	return time.Since(st.lastEventAt)
+}
+
+// nopLogger discards the log of the replica, which would only repeat that of
+// the node's own state machine.
+type nopLogger struct{}
+
+func (nopLogger) Log(statemachine.LogLevel, string, ...interface{}) {}
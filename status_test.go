@@ -0,0 +1,73 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"testing"
+
+	"github.com/hyperledger-labs/mirbft/pkg/status"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		sm         *status.StateMachine
+		epoch      uint64
+		epochState string
+		checkpoint uint64
+	}{
+		{
+			name: "uninitialized",
+			sm:   &status.StateMachine{},
+		},
+		{
+			name: "epoch in progress",
+			sm: &status.StateMachine{
+				LowWatermark:  21,
+				HighWatermark: 60,
+				EpochTracker: &status.EpochTracker{
+					ActiveEpoch: &status.EpochTarget{Number: 1, State: 8},
+				},
+				Checkpoints: []*status.Checkpoint{
+					{SeqNo: 20, NetQuorum: true, LocalDecision: true},
+					{SeqNo: 40},
+				},
+			},
+			epoch:      1,
+			epochState: "in_progress",
+			checkpoint: 20,
+		},
+		{
+			name: "later checkpoint stable",
+			sm: &status.StateMachine{
+				LowWatermark:  21,
+				HighWatermark: 60,
+				EpochTracker: &status.EpochTracker{
+					ActiveEpoch: &status.EpochTarget{Number: 3, State: 6},
+				},
+				Checkpoints: []*status.Checkpoint{
+					{SeqNo: 20, NetQuorum: true, LocalDecision: true},
+					{SeqNo: 40, NetQuorum: true, LocalDecision: true},
+					{SeqNo: 60, NetQuorum: true},
+				},
+			},
+			epoch:      3,
+			epochState: "resuming",
+			checkpoint: 40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStatus(tt.sm)
+			assert.Equal(t, tt.epoch, s.Epoch)
+			assert.Equal(t, tt.epochState, s.EpochState)
+			assert.Equal(t, tt.checkpoint, s.StableCheckpointSeqNo)
+		})
+	}
+}
@@ -0,0 +1,580 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/hyperledger-labs/mirbft/pkg/processor"
+	"github.com/hyperledger-labs/mirbft/pkg/reqstore"
+	"github.com/hyperledger-labs/mirbft/pkg/simplewal"
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// WALDir and RequestStoreDir are the directories within the run
+	// directory in which the disk backend keeps the WAL and request store.
+	WALDir          = "WAL"
+	RequestStoreDir = "reqStore"
+)
+
+// defaultSyncInterval is the least time between syncs under the interval
+// sync policy if the config sets none.
+const defaultSyncInterval = 100 * time.Millisecond
+
+// WAL is the write-ahead log to which Mir persists its state.  IsEmpty
+// reports whether nothing has been written to it, as before the node first
+// starts.
+type WAL interface {
+	processor.WAL
+	IsEmpty() (bool, error)
+}
+
+// RequestStore persists the requests the node receives, and the digests of
+// the requests allocated to each client's request numbers.
+type RequestStore interface {
+	processor.RequestStore
+}
+
+// suspender is implemented by stores whose files change in the background
+// while they are open, which must be closed for the files to be copied.
+type suspender interface {
+	Suspend(fn func() error) error
+}
+
+// flusher is implemented by stores which can sync their writes to disk
+// whatever their sync policy.
+type flusher interface {
+	Flush() error
+}
+
+// sizer is implemented by stores which can report how many bytes they hold.
+type sizer interface {
+	Size() int64
+}
+
+// SyncMode is when a disk store syncs its writes to disk.
+type SyncMode string
+
+const (
+	// SyncAlways syncs whenever Mir requires the writes to be durable.
+	SyncAlways SyncMode = "always"
+
+	// SyncInterval syncs at most once per interval, so the writes of up to
+	// an interval may be lost if the host fails.
+	SyncInterval SyncMode = "interval"
+
+	// SyncNever leaves syncing to the operating system.
+	SyncNever SyncMode = "never"
+)
+
+// SyncPolicy determines when a disk store syncs its writes to disk.
+type SyncPolicy struct {
+	Mode     SyncMode
+	Interval time.Duration
+}
+
+// syncer applies a sync policy to the syncs Mir requests of a store.
+type syncer struct {
+	policy SyncPolicy
+	sync   func() error
+
+	mutex sync.Mutex
+	last  time.Time
+
+	// pending syncs, at the end of the interval, the writes of a sync which
+	// was skipped, so that no write goes unsynced for longer than an
+	// interval.
+	pending *time.Timer
+
+	// err is the error of a pending sync, returned by the next sync.
+	err error
+}
+
+func (s *syncer) Sync() error {
+	switch s.policy.Mode {
+	case SyncNever:
+		return nil
+	case SyncInterval:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if err := s.err; err != nil {
+			s.err = nil
+			return err
+		}
+		if wait := s.policy.Interval - time.Since(s.last); wait > 0 {
+			if s.pending == nil {
+				s.pending = time.AfterFunc(wait, s.syncPending)
+			}
+			return nil
+		}
+		s.stopPending()
+		s.last = time.Now()
+	}
+
+	return s.sync()
+}
+
+func (s *syncer) syncPending() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.pending == nil {
+		// The writes were synced, or the store closed, in the meantime.
+		return
+	}
+	s.pending = nil
+	s.last = time.Now()
+	s.err = s.sync()
+}
+
+func (s *syncer) stopPending() {
+	if s.pending != nil {
+		s.pending.Stop()
+		s.pending = nil
+	}
+}
+
+// flush syncs any writes the policy has yet to, as the store is closed.
+func (s *syncer) flush() error {
+	if s.policy.Mode != SyncInterval {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stopPending()
+	return s.sync()
+}
+
+// DiskWAL is a WAL kept in a directory, whose syncs follow a sync policy.
+type DiskWAL struct {
+	*simplewal.WAL
+	syncer *syncer
+	path   string
+}
+
+// OpenDiskWAL opens the WAL in the directory at path, creating it if need be.
+func OpenDiskWAL(path string, policy SyncPolicy) (*DiskWAL, error) {
+	wal, err := simplewal.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskWAL{
+		WAL:    wal,
+		syncer: &syncer{policy: policy, sync: wal.Sync},
+		path:   path,
+	}, nil
+}
+
+func (w *DiskWAL) Sync() error {
+	return w.syncer.Sync()
+}
+
+// Flush syncs the WAL to disk whatever the sync policy.
+func (w *DiskWAL) Flush() error {
+	return w.syncer.sync()
+}
+
+func (w *DiskWAL) Size() int64 {
+	return dirSize(w.path)
+}
+
+func (w *DiskWAL) Close() error {
+	if err := w.syncer.flush(); err != nil {
+		w.WAL.Close()
+		return errors.WithMessage(err, "could not sync WAL")
+	}
+	return w.WAL.Close()
+}
+
+// DiskRequestStore is a request store kept in a directory, whose syncs follow
+// a sync policy.
+type DiskRequestStore struct {
+	syncer *syncer
+	path   string
+
+	// mutex guards store, which is closed while the request store is
+	// suspended, and err, the reason it could not be reopened.
+	mutex sync.RWMutex
+	store *reqstore.Store
+	err   error
+}
+
+// OpenDiskRequestStore opens the request store in the directory at path,
+// creating it if need be.
+func OpenDiskRequestStore(path string, policy SyncPolicy) (*DiskRequestStore, error) {
+	if path == "" {
+		// The request store would otherwise be kept in memory.
+		return nil, errors.Errorf("a path is required")
+	}
+
+	store, err := reqstore.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &DiskRequestStore{
+		path:  path,
+		store: store,
+	}
+	rs.syncer = &syncer{policy: policy, sync: func() error {
+		return rs.withStore((*reqstore.Store).Sync)
+	}}
+	return rs, nil
+}
+
+// withStore invokes fn with the open store, waiting for it to be reopened if
+// it is suspended.
+func (rs *DiskRequestStore) withStore(fn func(*reqstore.Store) error) error {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	if rs.store == nil {
+		return rs.err
+	}
+	return fn(rs.store)
+}
+
+func (rs *DiskRequestStore) GetAllocation(clientID, reqNo uint64) ([]byte, error) {
+	var digest []byte
+	err := rs.withStore(func(store *reqstore.Store) error {
+		var err error
+		digest, err = store.GetAllocation(clientID, reqNo)
+		return err
+	})
+	return digest, err
+}
+
+func (rs *DiskRequestStore) PutAllocation(clientID, reqNo uint64, digest []byte) error {
+	return rs.withStore(func(store *reqstore.Store) error {
+		return store.PutAllocation(clientID, reqNo, digest)
+	})
+}
+
+func (rs *DiskRequestStore) GetRequest(requestAck *pb.RequestAck) ([]byte, error) {
+	var data []byte
+	err := rs.withStore(func(store *reqstore.Store) error {
+		var err error
+		data, err = store.GetRequest(requestAck)
+		return err
+	})
+	return data, err
+}
+
+func (rs *DiskRequestStore) PutRequest(requestAck *pb.RequestAck, data []byte) error {
+	return rs.withStore(func(store *reqstore.Store) error {
+		return store.PutRequest(requestAck, data)
+	})
+}
+
+func (rs *DiskRequestStore) Sync() error {
+	return rs.syncer.Sync()
+}
+
+// Flush syncs the request store to disk whatever the sync policy.
+func (rs *DiskRequestStore) Flush() error {
+	return rs.syncer.sync()
+}
+
+// Suspend closes the request store while fn runs and then reopens it, as
+// badger otherwise compacts and flushes its files in the background, so that
+// they may be copied consistently.  Calls made meanwhile wait for the store to
+// be reopened.  The store's lock on its directory is held in its stead, so
+// that the node is still seen to be running.
+func (rs *DiskRequestStore) Suspend(fn func() error) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if rs.store == nil {
+		return rs.err
+	}
+
+	dir, err := os.Open(rs.path)
+	if err != nil {
+		return errors.WithMessage(err, "could not open request store directory")
+	}
+	defer dir.Close()
+
+	rs.store.Close()
+	rs.store = nil
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		// Another process took the lock as the store was closed.
+		rs.err = errors.WithMessage(err, "could not lock request store while it was closed")
+		return rs.err
+	}
+
+	fnErr := fn()
+
+	// The store takes the lock afresh as it is reopened.
+	syscall.Flock(int(dir.Fd()), syscall.LOCK_UN)
+	store, err := reqstore.Open(rs.path)
+	if err != nil {
+		rs.err = errors.WithMessage(err, "could not reopen request store")
+		return rs.err
+	}
+	rs.store = store
+
+	return fnErr
+}
+
+func (rs *DiskRequestStore) Size() int64 {
+	return dirSize(rs.path)
+}
+
+func (rs *DiskRequestStore) Close() error {
+	err := rs.syncer.flush()
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if rs.store != nil {
+		rs.store.Close()
+		rs.store = nil
+		rs.err = errors.Errorf("request store is closed")
+	}
+
+	return errors.WithMessage(err, "could not sync request store")
+}
+
+// dirSize returns the total size of the files within dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// MemWAL is a WAL kept in memory, which is lost when the process exits.  As
+// with the disk WAL, entries must be written in order of their indices.
+type MemWAL struct {
+	mutex sync.Mutex
+
+	// first is the index of the first of the entries.
+	first   uint64
+	entries [][]byte
+	size    int64
+}
+
+func NewMemWAL() *MemWAL {
+	return &MemWAL{first: 1}
+}
+
+func (w *MemWAL) IsEmpty() (bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.entries) == 0, nil
+}
+
+func (w *MemWAL) Write(index uint64, entry *pb.Persistent) error {
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return errors.WithMessage(err, "could not marshal")
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if next := w.first + uint64(len(w.entries)); index != next {
+		return errors.Errorf("out of order write of index %d, the next index is %d", index, next)
+	}
+
+	w.entries = append(w.entries, data)
+	w.size += int64(len(data))
+	return nil
+}
+
+// Truncate removes the entries before index.
+func (w *MemWAL) Truncate(index uint64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if index <= w.first {
+		return nil
+	}
+
+	last := w.first + uint64(len(w.entries)) - 1
+	if index > last {
+		return errors.Errorf("cannot truncate to index %d, the last index is %d", index, last)
+	}
+
+	removed := w.entries[:index-w.first]
+	for _, data := range removed {
+		w.size -= int64(len(data))
+	}
+	w.entries = append([][]byte(nil), w.entries[index-w.first:]...)
+	w.first = index
+	return nil
+}
+
+func (w *MemWAL) Sync() error {
+	return nil
+}
+
+func (w *MemWAL) LoadAll(forEach func(index uint64, p *pb.Persistent)) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for i, data := range w.entries {
+		entry := &pb.Persistent{}
+		if err := proto.Unmarshal(data, entry); err != nil {
+			return errors.WithMessagef(err, "could not decode index %d", w.first+uint64(i))
+		}
+		forEach(w.first+uint64(i), entry)
+	}
+
+	return nil
+}
+
+func (w *MemWAL) Size() int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.size
+}
+
+func (w *MemWAL) Close() error {
+	return nil
+}
+
+// MemRequestStore is a request store kept in memory, which is lost when the
+// process exits.
+type MemRequestStore struct {
+	mutex       sync.Mutex
+	allocations map[string][]byte
+	requests    map[string][]byte
+	size        int64
+}
+
+func NewMemRequestStore() *MemRequestStore {
+	return &MemRequestStore{
+		allocations: map[string][]byte{},
+		requests:    map[string][]byte{},
+	}
+}
+
+func allocKey(clientID, reqNo uint64) string {
+	return fmt.Sprintf("%d.%d", clientID, reqNo)
+}
+
+func reqKey(ack *pb.RequestAck) string {
+	return fmt.Sprintf("%d.%d.%x", ack.ClientId, ack.ReqNo, ack.Digest)
+}
+
+// GetAllocation returns the digest allocated to the request number, or nil
+// if there is none.
+func (rs *MemRequestStore) GetAllocation(clientID, reqNo uint64) ([]byte, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.allocations[allocKey(clientID, reqNo)], nil
+}
+
+func (rs *MemRequestStore) PutAllocation(clientID, reqNo uint64, digest []byte) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.put(rs.allocations, allocKey(clientID, reqNo), digest)
+	return nil
+}
+
+// GetRequest returns the data of the request, or nil if it is not stored.
+func (rs *MemRequestStore) GetRequest(requestAck *pb.RequestAck) ([]byte, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.requests[reqKey(requestAck)], nil
+}
+
+func (rs *MemRequestStore) PutRequest(requestAck *pb.RequestAck, data []byte) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.put(rs.requests, reqKey(requestAck), data)
+	return nil
+}
+
+// put stores a copy of value, as callers may reuse it.
+func (rs *MemRequestStore) put(values map[string][]byte, key string, value []byte) {
+	rs.size += int64(len(value)) - int64(len(values[key]))
+	values[key] = append([]byte{}, value...)
+}
+
+func (rs *MemRequestStore) Sync() error {
+	return nil
+}
+
+func (rs *MemRequestStore) Size() int64 {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.size
+}
+
+func (rs *MemRequestStore) Close() error {
+	return nil
+}
+
+// Storage is the WAL and request store of a node, opened from its config.
+type Storage struct {
+	WAL          WAL
+	RequestStore RequestStore
+
+	closers []func() error
+}
+
+// OpenStorage opens the WAL and request store of the configured backend,
+// within runDir if they are kept on disk.
+func OpenStorage(c config.Storage, runDir string) (*Storage, error) {
+	if c.Backend == "memory" {
+		wal, reqStore := NewMemWAL(), NewMemRequestStore()
+		return &Storage{
+			WAL:          wal,
+			RequestStore: reqStore,
+			closers:      []func() error{wal.Close, reqStore.Close},
+		}, nil
+	}
+
+	policy := SyncPolicy{Mode: SyncMode(c.Sync), Interval: c.SyncInterval}
+	if policy.Mode == "" {
+		policy.Mode = SyncAlways
+	}
+	if policy.Interval == 0 {
+		policy.Interval = defaultSyncInterval
+	}
+
+	wal, err := OpenDiskWAL(filepath.Join(runDir, WALDir), policy)
+	if err != nil {
+		return nil, errors.WithMessage(err, "could not open WAL")
+	}
+
+	reqStore, err := OpenDiskRequestStore(filepath.Join(runDir, RequestStoreDir), policy)
+	if err != nil {
+		wal.Close()
+		return nil, errors.WithMessage(err, "could not open request store")
+	}
+
+	return &Storage{
+		WAL:          wal,
+		RequestStore: reqStore,
+		closers:      []func() error{wal.Close, reqStore.Close},
+	}, nil
+}
+
+// Close closes the WAL and request store, returning the first error.
+func (s *Storage) Close() error {
+	var firstErr error
+	for _, close := range s.closers {
+		if err := close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
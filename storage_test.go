@@ -0,0 +1,190 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL(t *testing.T) {
+	entry := func(seqNo uint64) *pb.Persistent {
+		return &pb.Persistent{Type: &pb.Persistent_CEntry{CEntry: &pb.CEntry{SeqNo: seqNo}}}
+	}
+
+	tests := []struct {
+		name    string
+		openWAL func(t *testing.T) WAL
+	}{
+		{
+			name: "disk",
+			openWAL: func(t *testing.T) WAL {
+				wal, err := OpenDiskWAL(filepath.Join(t.TempDir(), WALDir), SyncPolicy{Mode: SyncAlways})
+				require.NoError(t, err)
+				t.Cleanup(func() { wal.Close() })
+				return wal
+			},
+		},
+		{
+			name:    "memory",
+			openWAL: func(t *testing.T) WAL { return NewMemWAL() },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wal := tt.openWAL(t)
+
+			empty, err := wal.IsEmpty()
+			require.NoError(t, err)
+			assert.True(t, empty)
+
+			for i := uint64(1); i <= 4; i++ {
+				require.NoError(t, wal.Write(i, entry(i*10)))
+			}
+			assert.Error(t, wal.Write(6, entry(60)), "entries must be written in order")
+			require.NoError(t, wal.Truncate(3))
+			require.NoError(t, wal.Sync())
+
+			var indices, seqNos []uint64
+			require.NoError(t, wal.LoadAll(func(index uint64, p *pb.Persistent) {
+				indices = append(indices, index)
+				seqNos = append(seqNos, p.GetCEntry().SeqNo)
+			}))
+			assert.Equal(t, []uint64{3, 4}, indices)
+			assert.Equal(t, []uint64{30, 40}, seqNos)
+
+			empty, err = wal.IsEmpty()
+			require.NoError(t, err)
+			assert.False(t, empty)
+		})
+	}
+}
+
+func TestMemRequestStore(t *testing.T) {
+	rs := NewMemRequestStore()
+	ack := &pb.RequestAck{ClientId: 1, ReqNo: 2, Digest: []byte("digest")}
+
+	digest, err := rs.GetAllocation(1, 2)
+	require.NoError(t, err)
+	assert.Nil(t, digest)
+
+	require.NoError(t, rs.PutAllocation(1, 2, ack.Digest))
+	data := []byte("data")
+	require.NoError(t, rs.PutRequest(ack, data))
+	data[0] = 'D'
+
+	digest, err = rs.GetAllocation(1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("digest"), digest)
+
+	stored, err := rs.GetRequest(ack)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), stored, "the store keeps a copy of the data")
+	assert.Equal(t, int64(10), rs.Size())
+
+	stored, err = rs.GetRequest(&pb.RequestAck{ClientId: 1, ReqNo: 2, Digest: []byte("other")})
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestSyncPolicy(t *testing.T) {
+	tests := []struct {
+		policy SyncPolicy
+		syncs  int
+	}{
+		{policy: SyncPolicy{Mode: SyncAlways}, syncs: 3},
+		{policy: SyncPolicy{Mode: SyncInterval, Interval: time.Hour}, syncs: 1},
+		{policy: SyncPolicy{Mode: SyncNever}, syncs: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy.Mode), func(t *testing.T) {
+			syncs := 0
+			s := &syncer{policy: tt.policy, sync: func() error { syncs++; return nil }}
+			for i := 0; i < 3; i++ {
+				require.NoError(t, s.Sync())
+			}
+			assert.Equal(t, tt.syncs, syncs)
+		})
+	}
+}
+
+func TestSyncPolicyIntervalFlushesSkippedSync(t *testing.T) {
+	var syncs int32
+	s := &syncer{
+		policy: SyncPolicy{Mode: SyncInterval, Interval: 50 * time.Millisecond},
+		sync: func() error {
+			atomic.AddInt32(&syncs, 1)
+			return nil
+		},
+	}
+
+	require.NoError(t, s.Sync())
+	require.NoError(t, s.Sync())
+	require.NoError(t, s.Sync())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&syncs), "syncs within the interval are skipped")
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&syncs) == 2
+	}, 100*time.Millisecond, 5*time.Millisecond, "the skipped syncs are flushed once, within an interval")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&syncs), "nothing is left to flush")
+
+	require.NoError(t, s.Sync())
+	require.NoError(t, s.Sync())
+	require.NoError(t, s.flush())
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&syncs), "closing flushes in place of the pending sync")
+}
+
+func TestDiskRequestStoreSuspend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), RequestStoreDir)
+	rs, err := OpenDiskRequestStore(path, SyncPolicy{Mode: SyncAlways})
+	require.NoError(t, err)
+	defer rs.Close()
+
+	ack := &pb.RequestAck{ClientId: 1, ReqNo: 2, Digest: []byte("digest")}
+	require.NoError(t, rs.PutRequest(ack, []byte("data")))
+
+	got := make(chan []byte)
+	err = rs.Suspend(func() error {
+		dir, err := os.Open(path)
+		require.NoError(t, err)
+		defer dir.Close()
+		assert.Equal(t, syscall.EWOULDBLOCK, syscall.Flock(int(dir.Fd()), syscall.LOCK_EX|syscall.LOCK_NB), "the directory stays locked")
+
+		go func() {
+			data, err := rs.GetRequest(ack)
+			assert.NoError(t, err)
+			got <- data
+		}()
+		select {
+		case <-got:
+			t.Error("the request store was read while suspended")
+		case <-time.After(50 * time.Millisecond):
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), <-got, "the read completes once the store is reopened")
+
+	assert.EqualError(t, rs.Suspend(func() error { return errors.New("failed") }), "failed")
+	data, err := rs.GetRequest(ack)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+}
@@ -0,0 +1,231 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jyellick/mirbft-sample/logging"
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/pkg/errors"
+)
+
+// subscriptionWindow is how far beyond the next sequence number to be
+// delivered entries are held while awaiting agreement.  Entries further ahead
+// are discarded, and the node sending them is resubscribed once the
+// subscription catches up.
+const subscriptionWindow = 1000
+
+var errAhead = errors.Errorf("too far ahead of the entries being verified")
+
+// Subscribe follows the entries committed by the network beginning with
+// fromSeqNo, invoking deliver for each entry in sequence order.  An entry is
+// only delivered once f+1 nodes have reported identical contents for its
+// sequence number, so at least one correct node vouches for it.  Each node is
+// sent a keepalive every RequestTimeout so that it retains the subscription,
+// and nodes which go quiet for longer than that are resubscribed.
+// Subscribe returns when the context ends, when deliver returns an error, or
+// when too few nodes retain the next sequence number for it to be verified.
+func (c *Client) Subscribe(ctx context.Context, fromSeqNo uint64, includeData bool, deliver func(*network.CommittedEntry) error) error {
+	c.logger = c.Loggers.Named(logging.App)
+
+	t, err := network.NewClientTransport(c.Loggers, c.ClientConfig)
+	if err != nil {
+		return errors.WithMessage(err, "could not create networking")
+	}
+
+	type received struct {
+		nodeID uint64
+		entry  *network.CommittedEntry
+	}
+
+	receivedC := make(chan received, 1000)
+	t.Handle(func(nodeID uint64, msg *network.ClientMsg) {
+		if msg.CommittedEntry == nil {
+			return
+		}
+
+		select {
+		case receivedC <- received{nodeID: nodeID, entry: msg.CommittedEntry}:
+		case <-ctx.Done():
+		}
+	})
+
+	err = t.Start()
+	if err != nil {
+		return errors.WithMessage(err, "could not start networking")
+	}
+	defer t.Close()
+
+	quorum := (len(c.ClientConfig.Nodes)-1)/3 + 1
+	verifier := newEntryVerifier(fromSeqNo, quorum, subscriptionWindow, includeData)
+
+	send := func(nodeID uint64, msg *network.ClientMsg) {
+		ctx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+		err := t.Send(ctx, nodeID, msg)
+		if err != nil {
+			c.logger.Debugw("Could not send to node", "node_id", nodeID, "error", err)
+		}
+	}
+
+	subscribe := func(nodeID uint64, fromSeqNo uint64) {
+		send(nodeID, &network.ClientMsg{
+			Subscribe: &network.Subscribe{
+				FromSeqNo:     fromSeqNo,
+				IncludeData:   includeData,
+				TimeoutMillis: uint64(3 * c.RequestTimeout / time.Millisecond),
+			},
+		})
+	}
+
+	lastHeard := map[uint64]time.Time{}
+	unavailable := map[uint64]string{}
+	ahead := map[uint64]struct{}{}
+
+	ticker := time.NewTicker(c.RequestTimeout)
+	defer ticker.Stop()
+
+	for _, node := range c.ClientConfig.Nodes {
+		lastHeard[node.ID] = time.Now()
+		go subscribe(node.ID, fromSeqNo)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			for nodeID, heard := range lastHeard {
+				_, isAhead := ahead[nodeID]
+				if time.Since(heard) < c.RequestTimeout && !isAhead {
+					go send(nodeID, &network.ClientMsg{KeepAlive: &network.KeepAlive{}})
+					continue
+				}
+				delete(ahead, nodeID)
+				lastHeard[nodeID] = time.Now()
+				go subscribe(nodeID, verifier.nextSeqNo)
+			}
+		case r := <-receivedC:
+			lastHeard[r.nodeID] = time.Now()
+
+			if r.entry.Error != "" {
+				if r.entry.SeqNo != verifier.nextSeqNo {
+					continue
+				}
+				unavailable[r.nodeID] = r.entry.Error
+				if len(unavailable) > len(c.ClientConfig.Nodes)-quorum {
+					return errors.Errorf("seq_no=%d is not available from enough nodes to verify it: %v", r.entry.SeqNo, unavailable)
+				}
+				continue
+			}
+
+			if err := verifier.add(r.nodeID, r.entry); err != nil {
+				if err == errAhead {
+					ahead[r.nodeID] = struct{}{}
+					continue
+				}
+				c.logger.Warnw("Discarding committed entry", "node_id", r.nodeID, "error", err)
+				continue
+			}
+
+			for entry := verifier.next(); entry != nil; entry = verifier.next() {
+				unavailable = map[uint64]string{}
+				if err := deliver(entry); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// entryVerifier collects the committed entries reported by each node, and
+// releases them in order once enough nodes agree on their contents.  Only
+// entries within window sequence numbers of the next are held.
+type entryVerifier struct {
+	nextSeqNo   uint64
+	quorum      int
+	window      uint64
+	includeData bool
+
+	pending map[uint64]map[uint64]*network.CommittedEntry
+}
+
+func newEntryVerifier(nextSeqNo uint64, quorum int, window uint64, includeData bool) *entryVerifier {
+	return &entryVerifier{
+		nextSeqNo:   nextSeqNo,
+		quorum:      quorum,
+		window:      window,
+		includeData: includeData,
+		pending:     map[uint64]map[uint64]*network.CommittedEntry{},
+	}
+}
+
+func (ev *entryVerifier) add(nodeID uint64, entry *network.CommittedEntry) error {
+	if entry.SeqNo < ev.nextSeqNo {
+		return nil
+	}
+
+	if entry.SeqNo >= ev.nextSeqNo+ev.window {
+		return errAhead
+	}
+
+	if ev.includeData {
+		for _, req := range entry.Requests {
+			digest := sha256.Sum256(req.Data)
+			if !bytes.Equal(digest[:], req.Digest) {
+				return errors.Errorf("data for client_id=%d req_no=%d at seq_no=%d does not match its digest", req.ClientID, req.ReqNo, entry.SeqNo)
+			}
+		}
+	}
+
+	votes, ok := ev.pending[entry.SeqNo]
+	if !ok {
+		votes = map[uint64]*network.CommittedEntry{}
+		ev.pending[entry.SeqNo] = votes
+	}
+	votes[nodeID] = entry
+
+	return nil
+}
+
+// next returns the entry at the next sequence number if a quorum of nodes
+// agree on it, or nil otherwise.
+func (ev *entryVerifier) next() *network.CommittedEntry {
+	votes := ev.pending[ev.nextSeqNo]
+
+	counts := map[string]int{}
+	for _, entry := range votes {
+		key := entryKey(entry)
+		counts[key]++
+		if counts[key] < ev.quorum {
+			continue
+		}
+
+		delete(ev.pending, ev.nextSeqNo)
+		ev.nextSeqNo++
+		return entry
+	}
+
+	return nil
+}
+
+// entryKey identifies the contents of a committed entry.  The request data
+// need not be included, as it has already been checked against the digests.
+func entryKey(entry *network.CommittedEntry) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d", entry.SeqNo)
+	for _, req := range entry.Requests {
+		fmt.Fprintf(&sb, ";%d.%d.%x", req.ClientID, req.ReqNo, req.Digest)
+	}
+	return sb.String()
+}
@@ -0,0 +1,132 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func committedEntry(seqNo uint64, data string) *network.CommittedEntry {
+	digest := sha256.Sum256([]byte(data))
+	return &network.CommittedEntry{
+		SeqNo: seqNo,
+		Requests: []*network.CommittedRequest{
+			{
+				ClientID: 0,
+				ReqNo:    seqNo,
+				Digest:   digest[:],
+				Data:     []byte(data),
+			},
+		},
+	}
+}
+
+func TestEntryVerifier(t *testing.T) {
+	type report struct {
+		nodeID uint64
+		entry  *network.CommittedEntry
+		err    string
+	}
+
+	tampered := committedEntry(1, "a")
+	tampered.Requests[0].Data = []byte("tampered")
+
+	tests := []struct {
+		name      string
+		reports   []report
+		delivered []uint64
+	}{
+		{
+			name: "quorum agrees",
+			reports: []report{
+				{nodeID: 0, entry: committedEntry(1, "a")},
+				{nodeID: 1, entry: committedEntry(1, "a")},
+			},
+			delivered: []uint64{1},
+		},
+		{
+			name: "too few agree",
+			reports: []report{
+				{nodeID: 0, entry: committedEntry(1, "a")},
+				{nodeID: 1, entry: committedEntry(1, "b")},
+			},
+		},
+		{
+			name: "same node reports twice",
+			reports: []report{
+				{nodeID: 0, entry: committedEntry(1, "a")},
+				{nodeID: 0, entry: committedEntry(1, "a")},
+			},
+		},
+		{
+			name: "released in order",
+			reports: []report{
+				{nodeID: 0, entry: committedEntry(2, "b")},
+				{nodeID: 1, entry: committedEntry(2, "b")},
+				{nodeID: 0, entry: committedEntry(1, "a")},
+				{nodeID: 1, entry: committedEntry(1, "a")},
+				{nodeID: 2, entry: committedEntry(3, "c")},
+			},
+			delivered: []uint64{1, 2},
+		},
+		{
+			name: "already delivered",
+			reports: []report{
+				{nodeID: 0, entry: committedEntry(1, "a")},
+				{nodeID: 1, entry: committedEntry(1, "a")},
+				{nodeID: 2, entry: committedEntry(1, "a")},
+			},
+			delivered: []uint64{1},
+		},
+		{
+			name: "data does not match digest",
+			reports: []report{
+				{nodeID: 0, entry: tampered, err: "data for client_id=0 req_no=1 at seq_no=1 does not match its digest"},
+				{nodeID: 1, entry: committedEntry(1, "a")},
+			},
+		},
+		{
+			name: "last within window",
+			reports: []report{
+				{nodeID: 0, entry: committedEntry(10, "j")},
+			},
+		},
+		{
+			name: "beyond window",
+			reports: []report{
+				{nodeID: 0, entry: committedEntry(11, "k"), err: errAhead.Error()},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := newEntryVerifier(1, 2, 10, true)
+
+			var delivered []uint64
+			for _, r := range tt.reports {
+				err := ev.add(r.nodeID, r.entry)
+				if r.err != "" {
+					assert.EqualError(t, err, r.err)
+				} else {
+					require.NoError(t, err)
+				}
+
+				for entry := ev.next(); entry != nil; entry = ev.next() {
+					delivered = append(delivered, entry.SeqNo)
+				}
+			}
+
+			assert.Equal(t, tt.delivered, delivered)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"context"
+	"sync"
+
+	pb "github.com/hyperledger-labs/mirbft/pkg/pb/msgs"
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/jyellick/mirbft-sample/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// nopTracer records nothing, for servers and clients given no tracer.
+var nopTracer = trace.NewNoopTracerProvider().Tracer("")
+
+type requestKey struct {
+	clientID uint64
+	reqNo    uint64
+}
+
+// proposedTrace is the trace of a request this node has proposed, which
+// awaits commit.
+type proposedTrace struct {
+	// ctx holds the span of the node receiving the request from the client.
+	ctx    context.Context
+	commit trace.Span
+}
+
+// requestTraces follows each traced request this node proposes until it
+// commits, so that committing and applying it are recorded within the trace
+// begun by the client.
+type requestTraces struct {
+	tracer trace.Tracer
+
+	mutex    sync.Mutex
+	proposed map[requestKey]*proposedTrace
+}
+
+func newRequestTraces(tracer trace.Tracer) *requestTraces {
+	return &requestTraces{
+		tracer:   tracer,
+		proposed: map[requestKey]*proposedTrace{},
+	}
+}
+
+// propose begins the span covering the batching and ordering of a request,
+// given the context of the span receiving it.  A request resent by the
+// client is already awaiting commit, and keeps its first span.
+func (rt *requestTraces) propose(ctx context.Context, clientID, reqNo uint64) {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return
+	}
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	key := requestKey{clientID: clientID, reqNo: reqNo}
+	if _, ok := rt.proposed[key]; ok {
+		return
+	}
+
+	_, span := rt.tracer.Start(ctx, "node.commit", tracing.Request(clientID, reqNo))
+	rt.proposed[key] = &proposedTrace{ctx: ctx, commit: span}
+}
+
+// apply ends the commit span of the request, and begins the span applying
+// it, which records nothing if the request was not traced.
+func (rt *requestTraces) apply(seqNo uint64, request *pb.RequestAck) trace.Span {
+	key := requestKey{clientID: request.ClientId, reqNo: request.ReqNo}
+
+	rt.mutex.Lock()
+	pt, ok := rt.proposed[key]
+	delete(rt.proposed, key)
+	rt.mutex.Unlock()
+
+	if !ok {
+		return trace.SpanFromContext(context.Background())
+	}
+
+	pt.commit.SetAttributes(tracing.SeqNo.Int64(int64(seqNo)))
+	pt.commit.End()
+
+	_, span := rt.tracer.Start(pt.ctx, "node.apply", tracing.Request(request.ClientId, request.ReqNo), trace.WithAttributes(tracing.SeqNo.Int64(int64(seqNo))))
+	return span
+}
+
+// prune ends the commit spans of requests which have since fallen below
+// their client's window without this node applying them, for instance as
+// they were resent after committing, or committed while the node was
+// catching up by state transfer.
+func (rt *requestTraces) prune(clients []*pb.NetworkState_Client) {
+	lowWatermarks := map[uint64]uint64{}
+	for _, client := range clients {
+		lowWatermarks[client.Id] = client.LowWatermark
+	}
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	for key, pt := range rt.proposed {
+		if key.reqNo >= lowWatermarks[key.clientID] {
+			continue
+		}
+		pt.commit.AddEvent("no longer awaiting commit, as the client window has passed the request")
+		pt.commit.End()
+		delete(rt.proposed, key)
+	}
+}
+
+// requestSpans holds the span of each request the client is sending, until
+// the request is settled with every node.
+type requestSpans struct {
+	tracer trace.Tracer
+
+	mutex sync.Mutex
+	spans map[uint64]context.Context
+}
+
+func newRequestSpans(tracer trace.Tracer) *requestSpans {
+	return &requestSpans{
+		tracer: tracer,
+		spans:  map[uint64]context.Context{},
+	}
+}
+
+func (rs *requestSpans) start(request *network.Propose) {
+	ctx, _ := rs.tracer.Start(context.Background(), "client.request", tracing.Request(request.ClientID, request.ReqNo))
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.spans[request.ReqNo] = ctx
+}
+
+// context returns the context holding the span of the request.
+func (rs *requestSpans) context(reqNo uint64) context.Context {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	ctx, ok := rs.spans[reqNo]
+	if !ok {
+		return context.Background()
+	}
+	return ctx
+}
+
+func (rs *requestSpans) end(reqNo uint64) {
+	rs.mutex.Lock()
+	ctx, ok := rs.spans[reqNo]
+	delete(rs.spans, reqNo)
+	rs.mutex.Unlock()
+
+	if ok {
+		trace.SpanFromContext(ctx).End()
+	}
+}
+
+// endAll ends the spans of the requests which were never settled.
+func (rs *requestSpans) endAll() {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	for reqNo, ctx := range rs.spans {
+		trace.SpanFromContext(ctx).End()
+		delete(rs.spans, reqNo)
+	}
+}
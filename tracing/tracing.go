@@ -0,0 +1,151 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// The attributes identifying the request a span belongs to, and where it was
+// recorded.
+const (
+	ClientID = attribute.Key("client_id")
+	ReqNo    = attribute.Key("req_no")
+	NodeID   = attribute.Key("node_id")
+	SeqNo    = attribute.Key("seq_no")
+)
+
+// propagator carries the trace context of a request as a W3C traceparent.
+var propagator = propagation.TraceContext{}
+
+// Provider hands out the tracer of a node or client, exporting the spans it
+// records to the destinations configured.
+type Provider struct {
+	provider trace.TracerProvider
+	shutdown []func(context.Context) error
+}
+
+// New builds the provider described by the config, which describes every
+// span with the given attributes, as well as the name of the service.  If
+// tracing is not configured, the provider's tracer records nothing.
+func New(c *config.Tracing, service string, attrs ...attribute.KeyValue) (*Provider, error) {
+	if c.File == "" && c.OTLPEndpoint == "" {
+		return &Provider{provider: trace.NewNoopTracerProvider()}, nil
+	}
+
+	ratio := c.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(append(attrs, attribute.String("service.name", service))...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	}
+
+	p := &Provider{}
+
+	if c.File != "" {
+		file, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.WithMessage(err, "could not open trace file")
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, errors.WithMessage(err, "could not create trace file exporter")
+		}
+
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+		p.shutdown = append(p.shutdown, func(context.Context) error {
+			return file.Close()
+		})
+	}
+
+	if c.OTLPEndpoint != "" {
+		opts = append(opts, sdktrace.WithBatcher(newOTLPExporter(c.OTLPEndpoint)))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	p.provider = provider
+
+	// The provider flushes its exporters before the file is closed.
+	p.shutdown = append([]func(context.Context) error{provider.Shutdown}, p.shutdown...)
+
+	return p, nil
+}
+
+// LogErrors logs the errors encountered exporting spans, which would
+// otherwise go to stderr.
+func LogErrors(logger *zap.SugaredLogger) {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warnw("Tracing failed", "error", err)
+	}))
+}
+
+// Tracer returns the tracer with which to record spans.
+func (p *Provider) Tracer() trace.Tracer {
+	return p.provider.Tracer("github.com/jyellick/mirbft-sample")
+}
+
+// Close exports any spans not yet exported, and releases the exporters.
+func (p *Provider) Close() error {
+	var firstErr error
+	for _, shutdown := range p.shutdown {
+		if err := shutdown(context.Background()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Inject returns the traceparent describing the span of the context, or an
+// empty string if the context holds no sampled span.
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Extract returns a context holding the remote span described by the
+// traceparent, which is ignored if empty or malformed.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// Request returns the attributes identifying a request.
+func Request(clientID, reqNo uint64) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		ClientID.Int64(int64(clientID)),
+		ReqNo.Int64(int64(reqNo)),
+	)
+}
+
+// End ends the span, marking it as failed if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,108 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jyellick/mirbft-sample/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestProviderExports(t *testing.T) {
+	var mutex sync.Mutex
+	var received []*otlpTraces
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		traces := &otlpTraces{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(traces))
+		mutex.Lock()
+		received = append(received, traces)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	c := &config.Tracing{
+		File:         filepath.Join(t.TempDir(), "traces.json"),
+		OTLPEndpoint: server.URL,
+	}
+	p, err := New(c, "node", attribute.Int64("node_id", 2))
+	require.NoError(t, err)
+
+	// The client's span, as carried in the client protocol.
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx := Extract(context.Background(), traceparent)
+
+	ctx, span := p.Tracer().Start(ctx, "node.propose", Request(1, 7))
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-"+span.SpanContext().SpanID().String()+"-01", Inject(ctx))
+	span.SetStatus(codes.Error, "failed")
+	span.End()
+
+	require.NoError(t, p.Close())
+
+	require.Len(t, received, 1)
+	require.Len(t, received[0].ResourceSpans, 1)
+	rs := received[0].ResourceSpans[0]
+	assert.Contains(t, rs.Resource.Attributes, otlpAttribute{Key: "service.name", Value: otlpValue{StringValue: strPtr("node")}})
+	assert.Contains(t, rs.Resource.Attributes, otlpAttribute{Key: "node_id", Value: otlpValue{IntValue: strPtr("2")}})
+	require.Len(t, rs.ScopeSpans, 1)
+	require.Len(t, rs.ScopeSpans[0].Spans, 1)
+	s := rs.ScopeSpans[0].Spans[0]
+	assert.Equal(t, "node.propose", s.Name)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", s.TraceID)
+	assert.Equal(t, "b7ad6b7169203331", s.ParentSpanID)
+	assert.Equal(t, otlpStatus{Code: 2, Message: "failed"}, s.Status)
+	assert.Equal(t, []otlpAttribute{
+		{Key: "client_id", Value: otlpValue{IntValue: strPtr("1")}},
+		{Key: "req_no", Value: otlpValue{IntValue: strPtr("7")}},
+	}, s.Attributes)
+
+	f, err := os.Open(c.File)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		assert.Equal(t, "node.propose", entry["Name"])
+		lines++
+	}
+	assert.Equal(t, 1, lines)
+}
+
+func TestProviderDisabled(t *testing.T) {
+	p, err := New(&config.Tracing{}, "client")
+	require.NoError(t, err)
+
+	ctx, span := p.Tracer().Start(context.Background(), "client.request")
+	assert.False(t, span.IsRecording())
+	assert.Equal(t, "", Inject(ctx))
+	span.End()
+
+	assert.Equal(t, trace.SpanContext{}, trace.SpanContextFromContext(Extract(context.Background(), "malformed")))
+	assert.NoError(t, p.Close())
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/jyellick/mirbft-sample/network"
+)
+
+// windowTracker records the most recent window each node has reported for
+// the client, so that the client never proposes further ahead than the
+// network will accept.
+type windowTracker struct {
+	quorum int
+
+	mutex   sync.Mutex
+	windows map[uint64]*network.Window
+}
+
+func newWindowTracker(quorum int) *windowTracker {
+	return &windowTracker{
+		quorum:  quorum,
+		windows: map[uint64]*network.Window{},
+	}
+}
+
+func (wt *windowTracker) update(nodeID uint64, window *network.Window) {
+	if window == nil {
+		return
+	}
+
+	wt.mutex.Lock()
+	defer wt.mutex.Unlock()
+
+	// Replies may arrive out of order, but windows only ever advance.
+	if prev, ok := wt.windows[nodeID]; ok && prev.HighWatermark > window.HighWatermark {
+		return
+	}
+
+	wt.windows[nodeID] = window
+}
+
+// highWatermark returns the highest request number which at least a quorum
+// of nodes have reported they will accept.  If fewer than a quorum of nodes
+// have reported a window, it returns false.
+func (wt *windowTracker) highWatermark() (uint64, bool) {
+	wt.mutex.Lock()
+	defer wt.mutex.Unlock()
+
+	if len(wt.windows) < wt.quorum {
+		return 0, false
+	}
+
+	highs := make([]uint64, 0, len(wt.windows))
+	for _, window := range wt.windows {
+		highs = append(highs, window.HighWatermark)
+	}
+	sort.Slice(highs, func(i, j int) bool { return highs[i] > highs[j] })
+
+	return highs[wt.quorum-1], true
+}
+
+// width returns the number of request numbers spanned by the widest window
+// reported, or zero if none has been.
+func (wt *windowTracker) width() uint64 {
+	wt.mutex.Lock()
+	defer wt.mutex.Unlock()
+
+	var width uint64
+	for _, window := range wt.windows {
+		if w := window.HighWatermark - window.LowWatermark + 1; w > width {
+			width = w
+		}
+	}
+
+	return width
+}
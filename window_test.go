@@ -0,0 +1,89 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sample
+
+import (
+	"testing"
+
+	"github.com/jyellick/mirbft-sample/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowTrackerHighWatermark(t *testing.T) {
+	type update struct {
+		nodeID uint64
+		high   uint64
+	}
+
+	tests := []struct {
+		name    string
+		updates []update
+		high    uint64
+		ok      bool
+	}{
+		{
+			name: "no windows",
+		},
+		{
+			name:    "fewer than quorum",
+			updates: []update{{0, 100}},
+		},
+		{
+			name:    "quorum agrees",
+			updates: []update{{0, 100}, {1, 100}},
+			high:    100,
+			ok:      true,
+		},
+		{
+			name:    "highest reported by a quorum",
+			updates: []update{{0, 300}, {1, 100}, {2, 200}, {3, 100}},
+			high:    200,
+			ok:      true,
+		},
+		{
+			name:    "single node ahead",
+			updates: []update{{0, 300}, {1, 100}, {2, 100}},
+			high:    100,
+			ok:      true,
+		},
+		{
+			name:    "stale reply ignored",
+			updates: []update{{0, 200}, {1, 200}, {1, 100}},
+			high:    200,
+			ok:      true,
+		},
+		{
+			name:    "window advances",
+			updates: []update{{0, 100}, {1, 100}, {0, 200}, {1, 200}},
+			high:    200,
+			ok:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wt := newWindowTracker(2)
+			wt.update(3, nil)
+			for _, u := range tt.updates {
+				wt.update(u.nodeID, &network.Window{HighWatermark: u.high})
+			}
+
+			high, ok := wt.highWatermark()
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.high, high)
+		})
+	}
+}
+
+func TestWindowTrackerWidth(t *testing.T) {
+	wt := newWindowTracker(2)
+	assert.Equal(t, uint64(0), wt.width())
+
+	wt.update(0, &network.Window{LowWatermark: 100, HighWatermark: 199})
+	wt.update(1, &network.Window{LowWatermark: 0, HighWatermark: 49})
+	assert.Equal(t, uint64(100), wt.width())
+}